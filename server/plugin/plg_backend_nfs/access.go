@@ -0,0 +1,58 @@
+package plg_backend_nfs
+
+import (
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// CanAccess tells whether the credential of the share can read, write,
+// execute or delete path. It relies on ACCESS which accounts for ACLs and
+// squashing on the server side, nothing gets touched in the process.
+// Deleting being an operation on the folder holding an entry, that's the
+// one we ask about
+func (this NfsShare) CanAccess(path string, mode string) (_ bool, err error) {
+	defer this.Close()
+	defer this.wrapError("access", path, &err)
+	this.metadataOp()
+
+	p := this.nfsPath(path)
+	if mode == "delete" {
+		if p == "" || p == "/" {
+			return false, nil
+		}
+		p = filepath.Dir(p)
+	}
+	attr, fh, err := this.resolve(p)
+	if err != nil {
+		return false, err
+	}
+	isDir := attr == nil || attr.Type == nfs.NF3Dir
+
+	var mask uint32
+	switch mode {
+	case "read":
+		mask = ACCESS3_READ
+	case "write":
+		mask = ACCESS3_MODIFY
+		if isDir {
+			mask = ACCESS3_EXTEND
+		}
+	case "execute":
+		mask = ACCESS3_EXECUTE
+		if isDir {
+			mask = ACCESS3_LOOKUP
+		}
+	case "delete":
+		mask = ACCESS3_DELETE
+	default:
+		return false, ErrNotValid
+	}
+	granted, err := this.access(fh, mask)
+	if err != nil {
+		return false, err
+	}
+	return granted&mask == mask, nil
+}
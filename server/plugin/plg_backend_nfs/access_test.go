@@ -0,0 +1,49 @@
+package plg_backend_nfs
+
+import (
+	"testing"
+)
+
+func TestCanAccess(t *testing.T) {
+	for _, c := range []struct {
+		fileDeny uint32
+		dirDeny  uint32
+		expected map[string]bool
+	}{
+		{0, 0, map[string]bool{"read": true, "write": true, "execute": true, "delete": true}},
+		{ACCESS3_READ, 0, map[string]bool{"read": false, "write": true, "execute": true, "delete": true}},
+		{ACCESS3_MODIFY | ACCESS3_EXECUTE, 0, map[string]bool{"read": true, "write": false, "execute": false, "delete": true}},
+		// deleting is up to the folder holding the file
+		{ACCESS3_DELETE, 0, map[string]bool{"read": true, "write": true, "execute": true, "delete": true}},
+		{0, ACCESS3_DELETE, map[string]bool{"read": true, "write": true, "execute": true, "delete": false}},
+	} {
+		srv := newFakeServer(t)
+		dir := srv.dir("/docs")
+		file := srv.file("/docs/report.pdf", "report")
+		srv.Lock()
+		file.deny = c.fileDeny
+		dir.deny = c.dirDeny
+		srv.Unlock()
+
+		for mode, expected := range c.expected {
+			if ok, err := srv.share(t, nil).CanAccess("/docs/report.pdf", mode); err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			} else if ok != expected {
+				t.Fatalf("%s with %x denied on the file and %x on the folder: expected %t", mode, c.fileDeny, c.dirDeny, expected)
+			}
+		}
+		for _, proc := range []uint32{NFSPROC3_SETATTR, NFSPROC3_WRITE, NFSPROC3_CREATE, NFSPROC3_REMOVE} {
+			if n := srv.count(proc); n != 0 {
+				t.Fatalf("expected nothing touched, got %d calls to %d", n, proc)
+			}
+		}
+	}
+
+	srv := newFakeServer(t)
+	srv.file("/a.txt", "a")
+	if _, err := srv.share(t, nil).CanAccess("/a.txt", "chmod"); err == nil {
+		t.Fatalf("expected an unknown mode to be refused")
+	} else if ok, err := srv.share(t, nil).CanAccess("/", "delete"); err != nil || ok {
+		t.Fatalf("expected the root to never be deletable, got %t %v", ok, err)
+	}
+}
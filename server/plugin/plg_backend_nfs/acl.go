@@ -0,0 +1,24 @@
+package plg_backend_nfs
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// nfsace4 as of RFC7530 in:
+// https://www.rfc-editor.org/rfc/rfc7530#section-6.2.1
+type NfsAce struct {
+	Type       uint32
+	Flag       uint32
+	AccessMask uint32
+	Who        string
+}
+
+// NFSv4 ACLs are an attribute of the v4 GETATTR which only exists within a
+// COMPOUND. This backend speaks v3 through go-nfs-client where the closest
+// thing is the ACCESS call Meta already relies on, so ACLs are reported as
+// unsupported rather than guessed from the mode bits
+func (this NfsShare) GetACL(path string) (_ []NfsAce, err error) {
+	defer this.Close()
+	defer this.wrapError("acl", path, &err)
+	return nil, ErrNotSupported
+}
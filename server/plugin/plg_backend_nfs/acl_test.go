@@ -0,0 +1,33 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestGetACLNotSupported(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	if _, err := srv.share(t, nil).GetACL("/file.txt"); errors.Is(err, ErrNotSupported) == false {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+// what Meta gives comes from ACCESS, which the server answers with its
+// ACLs taken into account
+func TestMetaFromAccess(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.dir("/inbox")
+	srv.Lock()
+	n.deny = ACCESS3_DELETE
+	srv.Unlock()
+
+	meta := srv.share(t, nil).Meta("/inbox")
+	if meta.CanSee == nil || *meta.CanSee == false || *meta.CanUpload == false {
+		t.Fatalf("expected the folder to be readable and writable, got %+v", meta)
+	} else if *meta.CanDelete || *meta.CanRename {
+		t.Fatalf("expected delete and rename to be refused, got %+v", meta)
+	}
+}
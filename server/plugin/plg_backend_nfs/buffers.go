@@ -0,0 +1,28 @@
+package plg_backend_nfs
+
+import (
+	"sync"
+)
+
+// transfers go chunk by chunk, rsize or wsize at a time. Allocating one
+// buffer per chunk makes for a lot of garbage once a few uploads and
+// downloads run side by side, buffers are kept around per pool instead
+// where they come back already sized to what the server uses
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func (this *bufferPool) get(size int) []byte {
+	if b, ok := this.pool.Get().(*[]byte); ok && cap(*b) >= size {
+		return (*b)[:size]
+	}
+	return make([]byte, size)
+}
+
+func (this *bufferPool) put(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	b = b[:0]
+	this.pool.Put(&b)
+}
@@ -0,0 +1,69 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// one transfer is 1MB going through in wsize chunks, "make" is how it went
+// before the pool came along
+func BenchmarkTransferBuffers(b *testing.B) {
+	const size, chunk = 1024 * 1024, 64 * 1024
+	src := make([]byte, size)
+	var sink []byte
+
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			for off := 0; off < size; off += chunk {
+				buf := make([]byte, chunk)
+				copy(buf, src[off:])
+				sink = buf
+			}
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		var pool bufferPool
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			for off := 0; off < size; off += chunk {
+				buf := pool.get(chunk)
+				copy(buf, src[off:])
+				sink = buf
+				pool.put(buf)
+			}
+		}
+	})
+	_ = sink
+}
+
+func BenchmarkSaveCat(b *testing.B) {
+	srv := newFakeServer(b)
+	s := srv.share(b, nil)
+	data := make([]byte, 1024*1024)
+
+	b.Run("save", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if err := s.Save("/bench.bin", bytes.NewReader(data)); err != nil {
+				b.Fatalf("save: %v", err)
+			}
+		}
+	})
+	b.Run("cat", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			r, err := s.Cat("/bench.bin")
+			if err != nil {
+				b.Fatalf("cat: %v", err)
+			}
+			io.Copy(io.Discard, r)
+			r.Close()
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package plg_backend_nfs
+
+import (
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// NFS transmits filenames as opaque bytes, servers from older systems might
+// be using something else than UTF-8 in which case we transcode names as they
+// cross the boundary
+
+func (this NfsShare) encodeName(name string) string {
+	name = this.normalize(name)
+	if this.charset == nil {
+		return name
+	}
+	if s, err := this.charset.NewEncoder().String(name); err == nil {
+		return s
+	}
+	return name
+}
+
+func (this NfsShare) decodeName(name string) string {
+	if this.charset == nil {
+		return this.normalize(name)
+	}
+	if s, err := this.charset.NewDecoder().String(name); err == nil {
+		return this.normalize(s)
+	}
+	return name
+}
+
+// the same accented name can be written in 2 ways: composed (NFC) like most
+// linux tools do or decomposed (NFD) like macOS does. Without picking one,
+// a name shown in a listing might not be found when looked up again
+func (this NfsShare) normalize(name string) string {
+	switch this.normalization {
+	case "nfc":
+		return norm.NFC.String(name)
+	case "nfd":
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// names we send are normalized but the ones already on the server might not
+// be, a file created as NFD by a mac can't be found back from its NFC form.
+// When that happens, the raw name is picked out from the directory entries
+func (this NfsShare) lookupDenormalized(dirFh []byte, name string, notFound error) (*nfs.Fattr, []byte, error) {
+	want := this.decodeName(name)
+	var cookie, cookieVerf uint64
+	for {
+		entries, next, verf, eof, err := this.readdirplus(dirFh, cookie, cookieVerf)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if entry.FileName != name && this.decodeName(entry.FileName) == want {
+				return this.lookup(dirFh, entry.FileName)
+			}
+		}
+		if eof {
+			return nil, nil, notFound
+		}
+		cookie, cookieVerf = next, verf
+	}
+}
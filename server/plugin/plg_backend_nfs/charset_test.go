@@ -0,0 +1,88 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNormalizationReopensNfdName(t *testing.T) {
+	srv := newFakeServer(t)
+	nfd := norm.NFD.String("résumé.txt")
+	srv.file("/"+nfd, "from a mac")
+	s := srv.share(t, map[string]string{"filename_normalization": "nfc"})
+
+	files, err := s.Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 1 || files[0].Name() != norm.NFC.String("résumé.txt") {
+		t.Fatalf("expected the name in NFC, got %v", files)
+	}
+
+	r, err := s.Cat("/" + files[0].Name())
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "from a mac" {
+		t.Fatalf("unexpected content '%s'", b)
+	}
+}
+
+func TestNormalizationPassthrough(t *testing.T) {
+	srv := newFakeServer(t)
+	nfd := norm.NFD.String("résumé.txt")
+	srv.file("/"+nfd, "from a mac")
+	s := srv.share(t, nil)
+
+	files, err := s.Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 1 || files[0].Name() != nfd {
+		t.Fatalf("expected the name as is, got %v", files)
+	}
+	if _, err := s.Cat("/" + norm.NFC.String("résumé.txt")); err == nil {
+		t.Fatalf("expected the NFC form not to be found without normalization")
+	}
+}
+
+func TestFilenameCharset(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/caf\xe9.txt", "latin-1")
+	s := func() NfsShare {
+		return srv.share(t, map[string]string{"filename_charset": "ISO-8859-1"})
+	}
+
+	files, err := s().Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 1 || files[0].Name() != "café.txt" {
+		t.Fatalf("expected the name in UTF-8, got %v", files)
+	}
+	r, err := s().Cat("/café.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "latin-1" {
+		t.Fatalf("unexpected content '%s'", b)
+	}
+
+	if err = s().Mkdir("/dossier é"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	} else if err = s().Save("/dossier é/résumé.txt", strings.NewReader("cv")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if got, ok := srv.content("/dossier \xe9/r\xe9sum\xe9.txt"); ok == false || got != "cv" {
+		t.Fatalf("expected the names stored in Latin-1, got %v", srv.names("/"))
+	}
+
+	// passthrough by default, the name is whatever bytes the server sent
+	files, err = srv.share(t, nil).Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if names := fileNames(files); names[0] != "caf\xe9.txt" && names[1] != "caf\xe9.txt" {
+		t.Fatalf("expected the raw name without a charset, got %q", names)
+	}
+}
@@ -0,0 +1,102 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// ChmodAll is a chmod -R applying fileMode to files and dirMode to
+// directories. A failure on one entry doesn't stop the others from being
+// updated, all errors are reported together at the end. Symlinks are left
+// alone as their mode is meaningless
+func (this NfsShare) ChmodAll(path string, fileMode os.FileMode, dirMode os.FileMode) (err error) {
+	defer this.Close()
+	defer this.wrapError("chmod", path, &err)
+	this.metadataOp()
+
+	root := this.nfsPath(path)
+	attr, fh, err := this.resolve(root)
+	if err != nil {
+		return err
+	}
+	if attr != nil && attr.Type != nfs.NF3Dir {
+		return this.chmod(fh, fileMode)
+	} else if err = this.chmod(fh, dirMode); err != nil {
+		return err
+	}
+
+	errs := []error{}
+	err = this.Walk(root, func(p string, entry *nfs.EntryPlus) error {
+		mode := fileMode
+		switch entry.Attr.Attr.Type {
+		case nfs.NF3Dir:
+			mode = dirMode
+		case nfs.NF3Lnk:
+			return nil
+		}
+		fh := entry.Handle.FH
+		if entry.Handle.IsSet == false {
+			_, h, err := this.resolve(p)
+			if err != nil {
+				errs = append(errs, NewError(this.decodeName(p)+": "+err.Error(), 500))
+				return nil
+			}
+			fh = h
+		}
+		if err := this.chmod(fh, mode); err != nil {
+			Log.Debug("plg_backend_nfs::chmod '%s' err[%s]", p, err.Error())
+			errs = append(errs, NewError(this.decodeName(p)+": "+err.Error(), 500))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func (this NfsShare) chmod(fh []byte, mode os.FileMode) error {
+	return this.setattr(fh, (&AttrChanges{Mode: &mode}).sattr())
+}
+
+// go keeps the setuid, setgid and sticky bits away from the permissions,
+// the wire wants them where unix has them
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// an octal mode as typed in a login form, eg: 2775 for a folder where new
+// files inherit the group
+func modeParam(value string, def os.FileMode) os.FileMode {
+	n, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || n > 07777 {
+		return def
+	}
+	mode := os.FileMode(n & 0777)
+	if n&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if n&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if n&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,66 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestChmodAll(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/tree/a.txt", "a")
+	srv.file("/tree/sub/b.txt", "b")
+	srv.file("/tree/sub/deep/c.txt", "c")
+	srv.symlink("/tree/link", "a.txt")
+	locked := srv.file("/tree/sub/locked.txt", "locked")
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_SETATTR && bytes.Equal(c.fh(), srv.fh(locked)) {
+			return nfs.NFS3ErrPerm
+		}
+		return 0
+	})
+
+	err := srv.share(t, nil).ChmodAll("/tree/", 0640, 0750)
+	if err == nil || strings.Contains(err.Error(), "locked.txt") == false {
+		t.Fatalf("expected the refused entry to be reported, got %v", err)
+	}
+	for path, expected := range map[string]uint32{
+		"/tree":                0750,
+		"/tree/a.txt":          0640,
+		"/tree/sub":            0750,
+		"/tree/sub/b.txt":      0640,
+		"/tree/sub/deep":       0750,
+		"/tree/sub/deep/c.txt": 0640,
+		"/tree/sub/locked.txt": 0644,
+		"/tree/link":           0777,
+	} {
+		if mode := srv.node(path).mode; mode != expected {
+			t.Fatalf("%s: expected %o, got %o", path, expected, mode)
+		}
+	}
+}
+
+func TestMkdirSpecialBits(t *testing.T) {
+	for _, noSpecial := range []bool{false, true} {
+		srv := newFakeServer(t)
+		srv.Lock()
+		srv.noSpecial = noSpecial
+		srv.Unlock()
+		s := srv.share(t, map[string]string{"dir_mode": "2775", "debug": "true"})
+
+		if err := s.Mkdir("/shared/"); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		attr, err := s.RawAttr("/shared/")
+		if err != nil {
+			t.Fatalf("getattr: %v", err)
+		} else if attr.FileMode&07777 != 02775 {
+			t.Fatalf("noSpecial=%v: expected 2775, got %o", noSpecial, attr.FileMode&07777)
+		}
+		if setattr := srv.count(NFSPROC3_SETATTR); noSpecial != (setattr == 1) {
+			t.Fatalf("noSpecial=%v: unexpected %d SETATTR", noSpecial, setattr)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const (
+	// files larger than this aren't worth holding in memory, they go
+	// straight to the server
+	COALESCE_MAX_FILE = 256 * 1024
+	// total amount of data buffered for a share before we force a flush
+	COALESCE_MAX_TOTAL = 16 * 1024 * 1024
+)
+
+// editors with autosave can send many small Save for the same file in a
+// short amount of time. Rather than hitting the server for each of them, we
+// keep the last version in memory and only write it once things settle down
+type coalescer struct {
+	pending map[string]*pendingSave
+	size    int
+	flush   func(path string, data []byte) error
+	sync.Mutex
+}
+
+type pendingSave struct {
+	data  []byte
+	timer *time.Timer
+}
+
+func newCoalescer(flush func(path string, data []byte) error) *coalescer {
+	return &coalescer{
+		pending: map[string]*pendingSave{},
+		flush:   flush,
+	}
+}
+
+func (this NfsShare) saveCoalesced(path string, file io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(file, COALESCE_MAX_FILE+1))
+	if err != nil {
+		return err
+	}
+	c := this.pool.coalescer
+	if len(data) > COALESCE_MAX_FILE {
+		c.drop(path)
+		return this.save(path, io.MultiReader(bytes.NewReader(data), file))
+	}
+	if c.put(path, data, this.coalesce) > COALESCE_MAX_TOTAL {
+		go c.Close()
+	}
+	return nil
+}
+
+// put replaces whatever was pending for path and returns the total amount of
+// buffered data
+func (this *coalescer) put(path string, data []byte, debounce time.Duration) int {
+	this.Lock()
+	defer this.Unlock()
+	if p, ok := this.pending[path]; ok {
+		p.timer.Stop()
+		this.size -= len(p.data)
+	}
+	p := &pendingSave{data: data}
+	p.timer = time.AfterFunc(debounce, func() {
+		this.flushPath(path, p)
+	})
+	this.pending[path] = p
+	this.size += len(data)
+	return this.size
+}
+
+// drop forgets what is pending for path and anything below it, what was
+// removed mustn't come back on the next flush. It tells if there was
+// anything to forget
+func (this *coalescer) drop(path string) bool {
+	if this == nil {
+		return false
+	}
+	this.Lock()
+	defer this.Unlock()
+	pending := this.take(path)
+	for _, p := range pending {
+		p.timer.Stop()
+	}
+	return len(pending) > 0
+}
+
+// take removes from the pending set what is at path or below, the lock
+// being held by the caller
+func (this *coalescer) take(path string) map[string]*pendingSave {
+	out := map[string]*pendingSave{}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for k, p := range this.pending {
+		if k != path && strings.HasPrefix(k, prefix) == false {
+			continue
+		}
+		out[k] = p
+		this.size -= len(p.data)
+		delete(this.pending, k)
+	}
+	return out
+}
+
+func (this *coalescer) flushPath(path string, p *pendingSave) {
+	this.Lock()
+	if this.pending[path] != p {
+		// a newer version came in and will be flushed on its own
+		this.Unlock()
+		return
+	}
+	delete(this.pending, path)
+	this.size -= len(p.data)
+	this.Unlock()
+	if err := this.flush(path, p.data); err != nil {
+		Log.Warning("plg_backend_nfs::coalesce flush error path=%s err=%s", path, err.Error())
+	}
+}
+
+// flushNow writes what is pending for path, or below it for a folder,
+// without waiting for the debounce. Anything reading or moving path has to
+// go through here first or it would see the server lagging behind
+func (this *coalescer) flushNow(path string) error {
+	if this == nil {
+		return nil
+	}
+	this.Lock()
+	pending := this.take(path)
+	this.Unlock()
+	var err error
+	for k, p := range pending {
+		p.timer.Stop()
+		if e := this.flush(k, p.data); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close writes everything that is still pending
+func (this *coalescer) Close() {
+	if this == nil {
+		return
+	}
+	this.Lock()
+	pending := this.pending
+	this.pending = map[string]*pendingSave{}
+	this.size = 0
+	this.Unlock()
+	for path, p := range pending {
+		p.timer.Stop()
+		if err := this.flush(path, p.data); err != nil {
+			Log.Warning("plg_backend_nfs::coalesce flush error path=%s err=%s", path, err.Error())
+		}
+	}
+}
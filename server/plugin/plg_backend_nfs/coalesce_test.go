@@ -0,0 +1,100 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var coalesceParams = map[string]string{"coalesce_writes": "200", "remount_backoff": "1", "remount_jitter": "1"}
+
+func TestCoalesceReducesWrites(t *testing.T) {
+	srv := newFakeServer(t)
+	for i := 1; i <= 5; i++ {
+		if err := srv.share(t, coalesceParams).Save("/notes.txt", strings.NewReader(strings.Repeat("v", i))); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	if n := srv.count(NFSPROC3_WRITE); n != 0 {
+		t.Fatalf("expected nothing written before the debounce, got %d WRITE", n)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got, _ := srv.content("/notes.txt"); got != "vvvvv" {
+		t.Fatalf("expected the last version, got '%s'", got)
+	} else if n := srv.count(NFSPROC3_WRITE); n != 1 {
+		t.Fatalf("expected a single WRITE, got %d", n)
+	} else if n := srv.count(NFSPROC3_COMMIT); n > 1 {
+		t.Fatalf("expected a single COMMIT, got %d", n)
+	}
+}
+
+func TestCoalesceReadsSeePending(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/notes.txt", "old")
+	if err := srv.share(t, coalesceParams).Save("/notes.txt", strings.NewReader("new content")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	r, err := srv.share(t, coalesceParams).Cat("/notes.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "new content" {
+		t.Fatalf("expected the pending content, got '%s'", b)
+	}
+
+	if err := srv.share(t, coalesceParams).Save("/notes.txt", strings.NewReader("newer")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if size, _, err := srv.share(t, coalesceParams).Sizes("/notes.txt"); err != nil {
+		t.Fatalf("sizes: %v", err)
+	} else if size != 5 {
+		t.Fatalf("expected the size of the pending content, got %d", size)
+	}
+}
+
+// a late flush mustn't bring back what was removed or moved
+func TestCoalesceRmMv(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/old.txt", "old")
+	for _, path := range []string{"/old.txt", "/new.txt"} {
+		if err := srv.share(t, coalesceParams).Save(path, strings.NewReader("pending")); err != nil {
+			t.Fatalf("save: %v", err)
+		} else if err = srv.share(t, coalesceParams).Rm(path); err != nil {
+			t.Fatalf("rm %s: %v", path, err)
+		}
+	}
+
+	if err := srv.share(t, coalesceParams).Save("/a.txt", strings.NewReader("moved")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if err = srv.share(t, coalesceParams).Mv("/a.txt", "/b.txt"); err != nil {
+		t.Fatalf("mv: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if names := srv.names("/"); len(names) != 1 || names[0] != "b.txt" {
+		t.Fatalf("expected only the moved file, got %v", names)
+	} else if got, _ := srv.content("/b.txt"); got != "moved" {
+		t.Fatalf("unexpected content '%s'", got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	srv := newFakeServer(t)
+	params := map[string]string{"coalesce_writes": "60000"}
+	if err := srv.share(t, params).Save("/journal.log", strings.NewReader("checkpoint")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if n := srv.count(NFSPROC3_WRITE); n != 0 {
+		t.Fatalf("expected the write to be held back, got %d WRITE", n)
+	}
+	if err := srv.share(t, params).Sync("/journal.log"); err != nil {
+		t.Fatalf("sync: %v", err)
+	} else if srv.count(NFSPROC3_COMMIT) == 0 {
+		t.Fatalf("expected a COMMIT")
+	}
+	// what's committed survives the server going down
+	srv.reboot()
+	if got, _ := srv.content("/journal.log"); got != "checkpoint" {
+		t.Fatalf("expected the data on stable storage, got '%s'", got)
+	}
+}
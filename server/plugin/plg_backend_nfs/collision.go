@@ -0,0 +1,52 @@
+package plg_backend_nfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const (
+	COLLISION_OVERWRITE = "overwrite"
+	COLLISION_SKIP      = "skip"
+	COLLISION_RENAME    = "rename"
+
+	// past this many "file (n).txt", something is wrong with the folder
+	COLLISION_MAX = 1000
+)
+
+// what to do when an upload lands on an existing file: overwrite it like
+// it always did, skip the upload or save it under the first free name of
+// the form "file (1).txt". Returns the path to save to, empty when the
+// upload is to be skipped
+func (this NfsShare) collision(path string) (string, error) {
+	if this.onCollision == "" || this.onCollision == COLLISION_OVERWRITE {
+		return path, nil
+	}
+	exists := func(p string) (bool, error) {
+		_, _, err := this.resolve(p)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if ok, err := exists(path); err != nil || ok == false {
+		return path, err
+	} else if this.onCollision == COLLISION_SKIP {
+		return "", nil
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; i <= COLLISION_MAX; i++ {
+		p := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if ok, err := exists(p); err != nil {
+			return "", err
+		} else if ok == false {
+			return p, this.checkName(p)
+		}
+	}
+	return "", ErrConflict
+}
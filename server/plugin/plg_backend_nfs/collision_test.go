@@ -0,0 +1,41 @@
+package plg_backend_nfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSaveCollision(t *testing.T) {
+	for _, c := range []struct {
+		strategy string
+		path     string
+		files    int
+		expected map[string]string
+	}{
+		{"", "/docs/file.txt", 3, map[string]string{"/docs/file.txt": "new", "/docs/file (1).txt": "first copy"}},
+		{"overwrite", "/docs/file.txt", 3, map[string]string{"/docs/file.txt": "new", "/docs/file (1).txt": "first copy"}},
+		{"skip", "/docs/file.txt", 3, map[string]string{"/docs/file.txt": "original", "/docs/file (1).txt": "first copy"}},
+		// "file (1).txt" is taken already
+		{"rename", "/docs/file.txt", 4, map[string]string{"/docs/file.txt": "original", "/docs/file (1).txt": "first copy", "/docs/file (2).txt": "new"}},
+		{"rename", "/docs/README", 4, map[string]string{"/docs/README": "original", "/docs/README (1)": "new"}},
+		{"rename", "/docs/free.txt", 4, map[string]string{"/docs/free.txt": "new"}},
+	} {
+		srv := newFakeServer(t)
+		srv.file("/docs/file.txt", "original")
+		srv.file("/docs/file (1).txt", "first copy")
+		srv.file("/docs/README", "original")
+
+		err := srv.share(t, map[string]string{"on_collision": c.strategy}).Save(c.path, strings.NewReader("new"))
+		if err != nil {
+			t.Fatalf("%q %s: save: %v", c.strategy, c.path, err)
+		}
+		for path, expected := range c.expected {
+			if got, _ := srv.content(path); got != expected {
+				t.Fatalf("%q %s: expected '%s' in %s, got '%s'", c.strategy, c.path, expected, path, got)
+			}
+		}
+		if names := srv.names("/docs"); len(names) != c.files {
+			t.Fatalf("%q %s: unexpected files %v", c.strategy, c.path, names)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func datedLayout(pattern string) string {
+	return strings.NewReplacer(
+		"YYYY", "2006",
+		"MM", "01",
+		"DD", "02",
+	).Replace(strings.Trim(pattern, "/"))
+}
+
+// SaveDated puts the file in a date based subdirectory of where it was meant
+// to go, eg: /inbox/report.pdf => /inbox/2024/01/31/report.pdf and returns
+// that final path. It goes through the same steps as Save does, on_collision
+// included, an empty path meaning the upload was skipped
+func (this NfsShare) SaveDated(path string, file io.Reader) (_ string, err error) {
+	defer this.Close()
+	defer this.wrapError("save", path, &err)
+	defer this.slowOp("save", path, time.Now())
+	this.dataOp()
+	if this.datedLayout == "" {
+		this.datedLayout = datedLayout("YYYY/MM/DD")
+	}
+	if err = this.checkName(this.nfsPath(path)); err != nil {
+		return "", err
+	}
+	final, err := this.saveFile(path, file)
+	if err != nil || final == "" {
+		return "", err
+	}
+	return this.decodeName(final), nil
+}
+
+// NFSv3 has no way to ask the server what time it is. The closest we get is
+// the ctime of a file it just created, a probe that's gone once we read it
+func (this NfsShare) datedPath(path string) (string, error) {
+	base, name := filepath.Split(path)
+	probe := base + ".filestash-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	fh, err := this.v.Create(probe, 0644)
+	if err != nil {
+		return "", err
+	}
+	attr, err := this.getattr(fh)
+	if rmErr := this.v.Remove(probe); rmErr != nil {
+		Log.Warning("plg_backend_nfs::dated can't remove probe '%s' err[%s]", probe, rmErr.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+	dir := base + time.Unix(int64(attr.Ctime.Seconds), 0).Format(this.datedLayout)
+	if err = this.mkdirAll(dir); err != nil {
+		return "", err
+	}
+	return dir + "/" + name, nil
+}
@@ -0,0 +1,94 @@
+package plg_backend_nfs
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func datedServer(t *testing.T) *fakeServer {
+	srv := newFakeServer(t)
+	srv.dir("/inbox")
+	srv.Lock()
+	srv.now = func() time.Time { return time.Date(2024, 1, 31, 12, 0, 0, 0, time.Local) }
+	srv.Unlock()
+	return srv
+}
+
+func TestSaveDated(t *testing.T) {
+	srv := datedServer(t)
+	s := srv.share(t, map[string]string{"dated_upload": "YYYY/MM/DD"})
+
+	final, err := s.SaveDated("/inbox/report.pdf", strings.NewReader("pdf"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	} else if final != "/inbox/2024/01/31/report.pdf" {
+		t.Fatalf("unexpected final path '%s'", final)
+	}
+	if got, ok := srv.content("/inbox/2024/01/31/report.pdf"); ok == false || got != "pdf" {
+		t.Fatalf("expected the file in the dated directory, got '%s'", got)
+	} else if names := srv.names("/inbox"); reflect.DeepEqual(names, []string{"2024"}) == false {
+		t.Fatalf("expected nothing else in the base directory, got %v", names)
+	}
+
+	// Save goes the same way when the share is configured for it
+	s = srv.share(t, map[string]string{"dated_upload": "YYYY-MM"})
+	if err = s.Save("/inbox/other.pdf", strings.NewReader("other")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if got, _ := srv.content("/inbox/2024-01/other.pdf"); got != "other" {
+		t.Fatalf("expected the file in the dated directory, got '%s'", got)
+	}
+}
+
+func TestSaveDatedCollision(t *testing.T) {
+	srv := datedServer(t)
+	srv.file("/inbox/2024/01/31/report.pdf", "original")
+
+	s := srv.share(t, map[string]string{"on_collision": "skip"})
+	if final, err := s.SaveDated("/inbox/report.pdf", strings.NewReader("new")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if final != "" {
+		t.Fatalf("expected the upload to be skipped, got '%s'", final)
+	} else if got, _ := srv.content("/inbox/2024/01/31/report.pdf"); got != "original" {
+		t.Fatalf("expected the existing file to be left alone, got '%s'", got)
+	}
+
+	s = srv.share(t, map[string]string{"on_collision": "rename"})
+	if final, err := s.SaveDated("/inbox/report.pdf", strings.NewReader("new")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if final != "/inbox/2024/01/31/report (1).pdf" {
+		t.Fatalf("unexpected final path '%s'", final)
+	} else if got, _ := srv.content("/inbox/2024/01/31/report.pdf"); got != "original" {
+		t.Fatalf("expected the existing file to be left alone, got '%s'", got)
+	}
+}
+
+// what gets cancelled midway is a truncated copy nobody will come back for
+func TestSaveDatedCancelLeavesNothing(t *testing.T) {
+	srv := datedServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b, err := srv.initCtx(ctx, nil)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	file := io.MultiReader(strings.NewReader("part"), readerFunc(func(p []byte) (int, error) {
+		cancel()
+		return copy(p, "more"), nil
+	}), strings.NewReader("rest"))
+	if _, err := b.(NfsShare).SaveDated("/inbox/report.pdf", file); err == nil {
+		t.Fatalf("expected the upload to fail")
+	}
+	if names := srv.names("/inbox/2024/01/31"); len(names) != 0 {
+		t.Fatalf("expected no partial file, got %v", names)
+	}
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) {
+	return fn(p)
+}
@@ -0,0 +1,26 @@
+package plg_backend_nfs
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// RawAttr gives the attributes of path exactly as GETATTR decoded them,
+// before any of the mapping Ls and Stat do. It's there to make sense of a
+// listing that looks wrong, which is why it stays off unless the share has
+// the debug option on: uid, gid and fileid aren't for everyone to see
+func (this NfsShare) RawAttr(path string) (_ *nfs.Fattr, err error) {
+	defer this.Close()
+	defer this.wrapError("rawattr", path, &err)
+	this.metadataOp()
+
+	if this.debug == false {
+		return nil, ErrNotAllowed
+	}
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return this.getattr(fh)
+}
@@ -0,0 +1,53 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestRawAttr(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.file("/docs/report.pdf", "0123456789")
+	srv.Lock()
+	n.mode = 0640
+	n.uid = 1001
+	n.gid = 2002
+	n.fsid = 0xabcd
+	n.used = 4096
+	n.atime = nfs.NFS3Time{Seconds: 1700000001, Nseconds: 1}
+	n.mtime = nfs.NFS3Time{Seconds: 1700000002, Nseconds: 2}
+	n.ctime = nfs.NFS3Time{Seconds: 1700000003, Nseconds: 3}
+	id := n.id
+	srv.Unlock()
+
+	attr, err := srv.share(t, map[string]string{"debug": "true"}).RawAttr("/docs/report.pdf")
+	if err != nil {
+		t.Fatalf("rawattr: %v", err)
+	}
+	expected := nfs.Fattr{
+		Type:     nfs.NF3Reg,
+		FileMode: 0640,
+		Nlink:    1,
+		UID:      1001,
+		GID:      2002,
+		Filesize: 10,
+		Used:     4096,
+		FSID:     0xabcd,
+		Fileid:   id,
+		Atime:    nfs.NFS3Time{Seconds: 1700000001, Nseconds: 1},
+		Mtime:    nfs.NFS3Time{Seconds: 1700000002, Nseconds: 2},
+		Ctime:    nfs.NFS3Time{Seconds: 1700000003, Nseconds: 3},
+	}
+	if reflect.DeepEqual(*attr, expected) == false {
+		t.Fatalf("unexpected attributes\n got %+v\nwant %+v", *attr, expected)
+	}
+
+	if _, err := srv.share(t, nil).RawAttr("/docs/report.pdf"); errors.Is(err, ErrNotAllowed) == false {
+		t.Fatalf("expected ErrNotAllowed without debug, got %v", err)
+	}
+}
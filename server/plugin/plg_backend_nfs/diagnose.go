@@ -0,0 +1,90 @@
+package plg_backend_nfs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"strconv"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// NfsExportReport is our best guess at how the export is configured on the
+// server. NFS has no way to ask for the export options, what's in there is
+// inferred from the export list and a probe write at the root of the share
+type NfsExportReport struct {
+	Export     string
+	Listed     bool     // the server lists the export to us
+	Groups     []string // clients allowed to mount it, empty means everyone
+	ReadOnly   bool
+	Writable   bool
+	RootSquash bool
+	AllSquash  bool
+	Notes      []string
+}
+
+// DiagnoseExport helps admins make sense of writes failing or files ending
+// up with the wrong owner, which more often than not comes down to ro,
+// root_squash or all_squash on the server side
+func (this NfsShare) DiagnoseExport() (_ NfsExportReport, err error) {
+	defer this.Close()
+	defer this.wrapError("diagnose", "/", &err)
+	this.metadataOp()
+
+	report := NfsExportReport{
+		Export: this.params["target"],
+		Groups: []string{},
+		Notes:  []string{},
+	}
+	if exports, err := this.exports(); err != nil {
+		report.Notes = append(report.Notes, "export list unavailable: "+err.Error())
+	} else {
+		for _, e := range exports {
+			if normalizeExport(e.Dir) == normalizeExport(report.Export) {
+				report.Listed = true
+				report.Groups = e.Groups
+			}
+		}
+		if report.Listed == false {
+			report.Notes = append(report.Notes, "the export isn't in the list the server gives out")
+		}
+	}
+
+	var b [8]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return report, err
+	}
+	name := ".filestash-probe-" + hex.EncodeToString(b[:])
+	fh, err := this.createExclusive(this.rootFh(), name, binary.BigEndian.Uint64(b[:]))
+	if isNfsError(err, nfs.NFS3ErrROFS) {
+		report.ReadOnly = true
+		return report, nil
+	} else if os.IsPermission(err) || isNfsError(err, nfs.NFS3ErrAcces) {
+		report.Notes = append(report.Notes, "the root of the share isn't writable by the configured user")
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+	report.Writable = true
+	defer func() {
+		if err := this.v.Remove("/" + name); err != nil {
+			Log.Warning("plg_backend_nfs::diagnose can't remove probe '%s' err[%s]", name, err.Error())
+		}
+	}()
+	attr, err := this.getattr(fh)
+	if err != nil {
+		return report, err
+	}
+	if this.uid == 0 && attr.UID != 0 {
+		report.RootSquash = true
+	} else if this.uid != 0 && attr.UID != this.uid {
+		report.AllSquash = true
+	}
+	if attr.UID != this.uid {
+		report.Notes = append(report.Notes, "files get created as uid "+strconv.Itoa(int(attr.UID)))
+	}
+	return report, nil
+}
@@ -0,0 +1,121 @@
+package plg_backend_nfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestDiagnoseReadOnly(t *testing.T) {
+	srv := newFakeServer(t)
+	s := srv.share(t, nil)
+	srv.Lock()
+	srv.readOnly = true
+	srv.Unlock()
+
+	report, err := s.DiagnoseExport()
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	} else if report.ReadOnly == false || report.Writable {
+		t.Fatalf("expected a read-only export, got %+v", report)
+	} else if report.Listed == false {
+		t.Fatalf("expected the export to be listed, got %+v", report)
+	}
+}
+
+func TestDiagnoseSquash(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.squash = "root"
+	report, err := srv.share(t, map[string]string{"uid": "0", "gid": "0"}).DiagnoseExport()
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	} else if report.Writable == false || report.RootSquash == false || report.AllSquash {
+		t.Fatalf("expected root_squash, got %+v", report)
+	}
+
+	srv.Lock()
+	srv.squash = "all"
+	srv.Unlock()
+	report, err = srv.share(t, nil).DiagnoseExport()
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	} else if report.AllSquash == false || report.RootSquash {
+		t.Fatalf("expected all_squash, got %+v", report)
+	} else if names := srv.names("/"); len(names) != 0 {
+		t.Fatalf("expected the probe to be removed, got %v", names)
+	}
+}
+
+func TestExportsOddLengths(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.export("/odd", "a", "host-1.lan", "*")
+	srv.export("/srv/share5/", "10.0.0.0/8")
+	s := srv.share(t, nil)
+
+	exports, err := s.exports()
+	if err != nil {
+		t.Fatalf("exports: %v", err)
+	}
+	expected := []nfsExport{
+		{Dir: "/export", Groups: []string{}},
+		{Dir: "/odd", Groups: []string{"a", "host-1.lan", "*"}},
+		{Dir: "/srv/share5/", Groups: []string{"10.0.0.0/8"}},
+	}
+	if reflect.DeepEqual(exports, expected) == false {
+		t.Fatalf("unexpected export list %+v", exports)
+	}
+
+	report, err := srv.share(t, map[string]string{"target": "/odd"}).DiagnoseExport()
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	} else if report.Listed == false || reflect.DeepEqual(report.Groups, expected[1].Groups) == false {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestMountAsAdvertised(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.export("/srv/share5/")
+	srv.share(t, map[string]string{"target": "/srv/share5"})
+	srv.Lock()
+	defer srv.Unlock()
+	if last := srv.mnts[len(srv.mnts)-1]; last != "/srv/share5/" {
+		t.Fatalf("expected the export to be mounted as advertised, got '%s'", last)
+	}
+}
+
+func TestNearExport(t *testing.T) {
+	for _, c := range []struct {
+		advertised string
+		target     string
+		expected   string
+	}{
+		{"/data/", "/data", ""},
+		{"/data ", "/data", ""},
+		{"/data", "/data/ ", ""},
+		{"/srv//data/", "/srv/data", ""},
+		{"/Media/", "/media", "did you mean '/Media'?"},
+		{"/data/", "/backup", "export not found"},
+	} {
+		srv := newFakeServer(t)
+		root := srv.export(c.advertised)
+		srv.Lock()
+		srv.newNode(root, "a.txt", nfs.NF3Reg, 0644)
+		srv.Unlock()
+
+		backend, err := srv.init(t, map[string]string{"target": c.target})
+		if c.expected != "" {
+			if err == nil || strings.Contains(err.Error(), c.expected) == false {
+				t.Fatalf("%q as %q: expected %q, got %v", c.advertised, c.target, c.expected, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%q as %q: %v", c.advertised, c.target, err)
+		}
+		if files, err := backend.Ls("/"); err != nil || strings.Join(fileNames(files), ",") != "a.txt" {
+			t.Fatalf("%q as %q: unexpected listing %v %v", c.advertised, c.target, fileNames(files), err)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package plg_backend_nfs
+
+import (
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// there's no TLS on our side, a tunnel fronting the server is dialed as
+// plain TCP like any other host. When it isn't up, the user is told the
+// server can't be reached
+func TestDialUnreachable(t *testing.T) {
+	srv := newFakeServer(t)
+	_, err := srv.init(t, map[string]string{"hostname": "tunnel.invalid"})
+	if err == nil {
+		t.Fatalf("expected the connection to fail")
+	}
+	if e, ok := err.(AppError); ok == false || e.Status() != 502 {
+		t.Fatalf("expected a 502, got %v", err)
+	}
+}
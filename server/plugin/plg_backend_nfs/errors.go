@@ -0,0 +1,93 @@
+package plg_backend_nfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// NfsError gives enough context to make sense of a failure in a deployment
+// with many shares. It unwraps to the underlying error so that errors.Is and
+// errors.As keep working on the NFS error
+type NfsError struct {
+	Op   string
+	Path string
+	Host string
+	Err  error
+}
+
+func (e *NfsError) Error() string {
+	return fmt.Sprintf("%s %s on %s: %s", e.Op, e.Path, e.Host, e.Err.Error())
+}
+
+func (e *NfsError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NfsError) Status() int {
+	if obj, ok := e.Err.(interface{ Status() int }); ok {
+		return obj.Status()
+	} else if errors.Is(e.Err, context.DeadlineExceeded) {
+		return ErrTimeout.Status()
+	} else if os.IsNotExist(e.Err) {
+		return ErrNotFound.Status()
+	} else if os.IsPermission(e.Err) || isNfsError(e.Err, nfs.NFS3ErrAcces) || isNfsError(e.Err, nfs.NFS3ErrROFS) {
+		return ErrPermissionDenied.Status()
+	} else if os.IsExist(e.Err) {
+		return ErrConflict.Status()
+	}
+	return ErrInternal.Status()
+}
+
+func (this NfsShare) wrapError(op string, path string, err *error) {
+	var src sourceError
+	if *err == nil {
+		return
+	} else if errors.As(*err, &src) == false && (isNfsError(*err, nfs.NFS3ErrStale) || isConnLost(*err)) {
+		// the server has most likely rebooted, the next request will remount.
+		// Past a timeout the reply can still show up, whoever sends the next
+		// call on that connection would read it as their own
+		this.conn.markStale()
+	}
+	*err = &NfsError{
+		Op:   op,
+		Path: path,
+		Host: this.host,
+		Err:  *err,
+	}
+}
+
+// NFS3ERR_JUKEBOX is the only status of RFC1813 go-nfs-client doesn't know
+// about, it comes out of nfs.NFS3Error as os.ErrInvalid
+const NFS3ERR_JUKEBOX = 10008
+
+func isJukebox(err error) bool {
+	return err == os.ErrInvalid
+}
+
+// servers in front of tape or slow object storage answer JUKEBOX while they
+// fetch the data, it's expected to show up if we ask again a bit later
+func (this NfsShare) jukebox(fn func() error) error {
+	deadline := time.Now().Add(this.jukeboxMax)
+	for {
+		err := fn()
+		if isJukebox(err) == false || time.Now().Add(this.jukeboxDelay).After(deadline) {
+			return err
+		}
+		Log.Debug("plg_backend_nfs::jukebox retry in %s", this.jukeboxDelay)
+		time.Sleep(this.jukeboxDelay)
+	}
+}
+
+func isNfsError(err error, code uint32) bool {
+	if nfsErr, ok := err.(*nfs.Error); ok {
+		return nfsErr.ErrorNum == code
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestErrorContext(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.dir("/home")
+	_, err := srv.share(t, map[string]string{"path": "/home"}).Ls("/missing")
+	var e *NfsError
+	if errors.As(err, &e) == false {
+		t.Fatalf("expected an NfsError, got %T %v", err, err)
+	} else if expected := "ls /missing on " + srv.host + ": "; strings.HasPrefix(err.Error(), expected) == false {
+		t.Fatalf("expected the error to start with '%s', got '%s'", expected, err.Error())
+	} else if errors.Is(err, os.ErrNotExist) == false || e.Status() != 404 {
+		t.Fatalf("expected a not found, got %v with %d", err, e.Status())
+	}
+
+	srv.Lock()
+	srv.readOnly = true
+	srv.Unlock()
+	err = srv.share(t, nil).Mkdir("/folder")
+	if errors.As(err, &e) == false || e.Op != "mkdir" || e.Path != "/folder" || e.Status() != 403 {
+		t.Fatalf("expected a 403 on mkdir /folder, got %v", err)
+	}
+}
+
+func TestJukebox(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/tape.bin", "from tape")
+	jukebox := 2
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READ && jukebox != 0 {
+			jukebox -= 1
+			return NFS3ERR_JUKEBOX
+		}
+		return 0
+	})
+	s := srv.share(t, nil)
+	s.jukeboxDelay = 10 * time.Millisecond
+	s.jukeboxMax = time.Second
+	r, err := s.Cat("/tape.bin")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("expected the read to go through once the data is there, got %v", err)
+	} else if string(b) != "from tape" {
+		t.Fatalf("unexpected content '%s'", b)
+	} else if n := srv.count(NFSPROC3_READ); n != 3 {
+		t.Fatalf("expected 2 retries, got %d READ", n)
+	}
+
+	// up to a point
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READ {
+			return NFS3ERR_JUKEBOX
+		}
+		return 0
+	})
+	s = srv.share(t, nil)
+	s.jukeboxDelay = 10 * time.Millisecond
+	s.jukeboxMax = 50 * time.Millisecond
+	if r, err = s.Cat("/tape.bin"); err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	if _, err = io.ReadAll(r); err == nil {
+		t.Fatalf("expected to give up past jukebox_max")
+	}
+}
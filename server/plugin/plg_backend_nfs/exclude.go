@@ -0,0 +1,42 @@
+package plg_backend_nfs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// names to hide from listings, comma separated and matched as globs against
+// the name of each entry, eg: ".snapshot, ~$*"
+func excludePatterns(value string) []string {
+	patterns := []string{}
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		} else if _, err := filepath.Match(p, ""); err != nil {
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+func (this NfsShare) excluded(name string) bool {
+	for _, p := range this.exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hidden tells if an entry is left out of listings, either for its type or
+// for its name
+func (this NfsShare) hidden(entry *nfs.EntryPlus) bool {
+	if this.showTypes[entry.Attr.Attr.Type] == false && this.raw == false {
+		return true
+	}
+	return this.excluded(this.decodeName(entry.FileName))
+}
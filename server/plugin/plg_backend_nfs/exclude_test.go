@@ -0,0 +1,103 @@
+package plg_backend_nfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func snapshotServer(t *testing.T) *fakeServer {
+	srv := newFakeServer(t)
+	srv.dir("/data")
+	srv.file("/data/report.txt", "report")
+	srv.file("/data/.snapshot/hourly.0/report.txt", "report")
+	srv.symlink("/data/latest", "report.txt")
+	return srv
+}
+
+func zipNames(t *testing.T, s NfsShare, path string) []string {
+	var buf bytes.Buffer
+	if err := s.Zip(path, &buf); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestExcludeSnapshot(t *testing.T) {
+	srv := snapshotServer(t)
+	excluded := map[string]string{"exclude": ".snapshot"}
+
+	files, err := srv.share(t, nil).Ls("/data")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 2 {
+		t.Fatalf("expected .snapshot to be listed by default, got %v", files)
+	}
+	files, err = srv.share(t, excluded).Ls("/data")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 1 || files[0].Name() != "report.txt" {
+		t.Fatalf("expected .snapshot to be hidden, got %v", files)
+	}
+
+	expected := []string{".snapshot/", ".snapshot/hourly.0/", ".snapshot/hourly.0/report.txt", "report.txt"}
+	if names := zipNames(t, srv.share(t, nil), "/data"); reflect.DeepEqual(names, expected) == false {
+		t.Fatalf("unexpected archive %v", names)
+	}
+	if names := zipNames(t, srv.share(t, excluded), "/data"); reflect.DeepEqual(names, []string{"report.txt"}) == false {
+		t.Fatalf("expected .snapshot out of the archive, got %v", names)
+	}
+}
+
+// walking has to tell folders apart even when READDIRPLUS leaves the
+// attributes out
+func TestWalkWithoutAttributes(t *testing.T) {
+	srv := snapshotServer(t)
+	srv.plusNoAttr = true
+	expected := []string{".snapshot/", ".snapshot/hourly.0/", ".snapshot/hourly.0/report.txt", "report.txt"}
+	if names := zipNames(t, srv.share(t, nil), "/data"); reflect.DeepEqual(names, expected) == false {
+		t.Fatalf("unexpected archive %v", names)
+	}
+}
+
+func TestChmodAllExclude(t *testing.T) {
+	srv := snapshotServer(t)
+	if err := srv.share(t, map[string]string{"exclude": ".snapshot"}).ChmodAll("/data", 0600, 0700); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if mode := srv.node("/data/report.txt").mode; mode != 0600 {
+		t.Fatalf("expected the file to be updated, got %o", mode)
+	} else if mode := srv.node("/data/.snapshot/hourly.0/report.txt").mode; mode == 0600 {
+		t.Fatalf("expected the excluded folder to be left alone")
+	}
+}
+
+func TestRemoveAllExclude(t *testing.T) {
+	srv := snapshotServer(t)
+	err := srv.share(t, map[string]string{"exclude": ".snapshot"}).Rm("/data/")
+	if errors.Is(err, ErrDirectoryNotEmpty) == false {
+		t.Fatalf("expected the folder to be kept for what's excluded, got %v", err)
+	} else if names := srv.names("/data"); reflect.DeepEqual(names, []string{".snapshot"}) == false {
+		t.Fatalf("expected only the excluded entry left, got %v", names)
+	}
+
+	// types hidden from listings still go away with their folder
+	srv = snapshotServer(t)
+	if err = srv.share(t, nil).Rm("/data/"); err != nil {
+		t.Fatalf("rm: %v", err)
+	} else if names := srv.names("/"); len(names) != 0 {
+		t.Fatalf("expected everything to be removed, got %v", names)
+	}
+}
@@ -0,0 +1,41 @@
+package plg_backend_nfs
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+type NfsExtent struct {
+	Offset uint64
+	Length uint64
+}
+
+// Extents lists the ranges of a file that hold data. Finding holes takes
+// SEEK with SEEK_DATA / SEEK_HOLE which only exists from NFSv4.2 onward, as
+// of RFC7862 in: https://www.rfc-editor.org/rfc/rfc7862#section-15.11
+// On v3 the only thing we can tell is when a file isn't sparse at all: the
+// space it takes on disk covers its whole size and the entire file is a
+// single extent. Anything else is unsupported
+func (this NfsShare) Extents(path string) (_ []NfsExtent, err error) {
+	defer this.Close()
+	defer this.wrapError("extents", path, &err)
+	this.metadataOp()
+
+	attr, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return nil, err
+	} else if attr == nil {
+		if attr, err = this.getattr(fh); err != nil {
+			return nil, err
+		}
+	}
+	if attr.Type != nfs.NF3Reg {
+		return nil, ErrNotValid
+	} else if attr.Filesize == 0 {
+		return []NfsExtent{}, nil
+	} else if attr.Used < attr.Filesize {
+		return nil, ErrNotSupported
+	}
+	return []NfsExtent{{Offset: 0, Length: attr.Filesize}}, nil
+}
@@ -0,0 +1,36 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestExtents(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/full.bin", "0123456789")
+	srv.file("/empty.bin", "")
+	sparse := srv.file("/sparse.img", string(make([]byte, 64*1024)))
+	srv.Lock()
+	sparse.used = 4096
+	srv.Unlock()
+	srv.dir("/folder")
+
+	if extents, err := srv.share(t, nil).Extents("/full.bin"); err != nil {
+		t.Fatalf("extents: %v", err)
+	} else if reflect.DeepEqual(extents, []NfsExtent{{Offset: 0, Length: 10}}) == false {
+		t.Fatalf("expected a single extent, got %v", extents)
+	}
+	if extents, err := srv.share(t, nil).Extents("/empty.bin"); err != nil || len(extents) != 0 {
+		t.Fatalf("expected no extent, got %v %v", extents, err)
+	}
+	// telling holes apart from data takes SEEK, which v3 doesn't have
+	if _, err := srv.share(t, nil).Extents("/sparse.img"); errors.Is(err, ErrNotSupported) == false {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+	if _, err := srv.share(t, nil).Extents("/folder"); errors.Is(err, ErrNotValid) == false {
+		t.Fatalf("expected ErrNotValid, got %v", err)
+	}
+}
@@ -0,0 +1,1197 @@
+package plg_backend_nfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// a fake server speaking NFSv3, MOUNT and NLM out of memory, good enough for
+// what the plugin asks of a real one. Each listens on a port of its own on
+// localhost and is reached by a made up hostname, the seams of portmap.go
+// are pointed at it so nothing ever goes to port 111
+
+const (
+	// hook status to close the connection instead of replying
+	FAKE_DROP = 0xffffffff
+	// hook status to carry out the call, then close the connection instead
+	// of replying
+	FAKE_LOST = 0xfffffffe
+
+	RPC_PROG_UNAVAIL = 1
+	RPC_PROC_UNAVAIL = 3
+
+	NFSPROC3_GETATTR     = 1
+	NFSPROC3_SETATTR     = 2
+	NFSPROC3_LOOKUP      = 3
+	NFSPROC3_ACCESS      = 4
+	NFSPROC3_READLINK    = 5
+	NFSPROC3_READ        = 6
+	NFSPROC3_WRITE       = 7
+	NFSPROC3_CREATE      = 8
+	NFSPROC3_MKDIR       = 9
+	NFSPROC3_SYMLINK     = 10
+	NFSPROC3_REMOVE      = 12
+	NFSPROC3_RMDIR       = 13
+	NFSPROC3_RENAME      = 14
+	NFSPROC3_READDIRPLUS = 17
+	NFSPROC3_FSSTAT      = 18
+	NFSPROC3_FSINFO      = 19
+	NFSPROC3_PATHCONF    = 20
+	NFSPROC3_COMMIT      = 21
+)
+
+var (
+	fakeServers     = map[string]*fakeServer{}
+	fakeServersLock sync.Mutex
+	fakeServersSeq  int
+)
+
+func init() {
+	getPort = func(host string, m rpc.Mapping) (int, error) {
+		srv, err := fakeFor(host)
+		if err != nil {
+			return 0, err
+		} else if srv.serves(m.Prog, m.Vers) == false {
+			return 0, nil
+		}
+		return srv.ln.Addr().(*net.TCPAddr).Port, nil
+	}
+	dialService = func(host string, m rpc.Mapping) (*rpc.Client, error) {
+		srv, err := fakeFor(host)
+		if err != nil {
+			return nil, err
+		} else if srv.serves(m.Prog, m.Vers) == false {
+			return nil, errors.New("connection refused")
+		}
+		return rpc.DialTCP("tcp", nil, srv.ln.Addr().String())
+	}
+	dialMount = func(host string) (*nfs.Mount, error) {
+		c, err := dialService(host, rpc.Mapping{Prog: nfs.MountProg, Vers: nfs.MountVers, Prot: rpc.IPProtoTCP})
+		if err != nil {
+			return nil, err
+		}
+		return &nfs.Mount{Client: c, Addr: host}, nil
+	}
+	// nfs.NewTarget dials on its own, the target is put together by hand
+	newTarget = func(host string, auth rpc.Auth, fh []byte, dirpath string) (*nfs.Target, error) {
+		c, err := dialService(host, rpc.Mapping{Prog: nfs.Nfs3Prog, Vers: nfs.Nfs3Vers, Prot: rpc.IPProtoTCP})
+		if err != nil {
+			return nil, err
+		}
+		v := &nfs.Target{Client: c}
+		setUnexported(v, "auth", auth)
+		setUnexported(v, "fh", fh)
+		setUnexported(v, "dirPath", dirpath)
+		fsinfo, err := v.FSInfo()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		setUnexported(v, "fsinfo", fsinfo)
+		return v, nil
+	}
+}
+
+func setUnexported(obj interface{}, name string, value interface{}) {
+	f := reflect.ValueOf(obj).Elem().FieldByName(name)
+	reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().Set(reflect.ValueOf(value))
+}
+
+func fakeFor(host string) (*fakeServer, error) {
+	fakeServersLock.Lock()
+	defer fakeServersLock.Unlock()
+	if srv, ok := fakeServers[host]; ok {
+		return srv, nil
+	}
+	return nil, fmt.Errorf("dial tcp %s: no such host", host)
+}
+
+type fakeCall struct {
+	Prog uint32
+	Vers uint32
+	Proc uint32
+	Cred rpc.Auth
+	Args []byte
+}
+
+// fh reads the handle most calls start with
+func (c *fakeCall) fh() []byte {
+	fh, _ := xdr.ReadOpaque(bytes.NewReader(c.Args))
+	return fh
+}
+
+type fakeProc struct {
+	Prog uint32
+	Proc uint32
+}
+
+type fakeNode struct {
+	id     uint64
+	typ    uint32
+	mode   uint32
+	uid    uint32
+	gid    uint32
+	fsid   uint64
+	data   []byte
+	stable []byte // what survives a reboot
+	link   string
+	used   uint64 // space used on disk, the size when 0
+	verf   uint64 // of the exclusive create that made it
+	atime  nfs.NFS3Time
+	mtime  nfs.NFS3Time
+	ctime  nfs.NFS3Time
+	parent *fakeNode
+	names  []string
+	kids   map[string]*fakeNode
+	noAttr bool // left out of LOOKUP and READDIRPLUS replies
+	deny   uint32
+}
+
+func (n *fakeNode) fattr() nfs.Fattr {
+	size := uint64(len(n.data))
+	switch n.typ {
+	case nfs.NF3Dir:
+		size = 4096
+	case nfs.NF3Lnk:
+		size = uint64(len(n.link))
+	}
+	used := n.used
+	if used == 0 {
+		used = size
+	}
+	return nfs.Fattr{
+		Type:     n.typ,
+		FileMode: n.mode,
+		Nlink:    1,
+		UID:      n.uid,
+		GID:      n.gid,
+		Filesize: size,
+		Used:     used,
+		FSID:     n.fsid,
+		Fileid:   n.id,
+		Atime:    n.atime,
+		Mtime:    n.mtime,
+		Ctime:    n.ctime,
+	}
+}
+
+func (n *fakeNode) post() nfs.PostOpAttr {
+	return nfs.PostOpAttr{IsSet: true, Attr: n.fattr()}
+}
+
+// fh is the node id followed by filler up to fhSize, so a handle sent back
+// truncated or without its length doesn't go unnoticed
+func (this *fakeServer) fh(n *fakeNode) []byte {
+	fh := bytes.Repeat([]byte{0xfe}, this.fhSize)
+	binary.BigEndian.PutUint64(fh, n.id)
+	return fh
+}
+
+type fakeExport struct {
+	dir    string
+	groups []string
+	root   *fakeNode
+}
+
+type fakeServer struct {
+	sync.Mutex
+	host  string
+	ln    net.Listener
+	conns map[net.Conn]bool
+	nodes map[uint64]*fakeNode
+	root  *fakeNode
+	seq   uint64
+	calls map[fakeProc]int
+
+	verf       uint64
+	cookieVerf uint64
+	page       int // READDIRPLUS entries per reply, all of them when 0
+	plusNoAttr bool
+	rtpref     uint32
+	wtpref     uint32
+	readOnly   bool
+	squash     string // "root" or "all"
+	exports    []fakeExport
+	mountProg  uint32
+	mountVers  []uint32
+	nlm        bool
+	locks      map[string]string
+	fsstat     fsstat
+	pathconf   *pathconf
+	fhSize     int  // 8 to NFS3_FHSIZE
+	noSpecial  bool // MKDIR ignores the setuid, setgid and sticky bits
+	mnts       []string
+	creds      []rpc.Auth
+	hook       func(c *fakeCall) uint32
+	now        func() time.Time
+}
+
+func newFakeServer(t testing.TB) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fakeServersLock.Lock()
+	fakeServersSeq += 1
+	srv := &fakeServer{
+		host:      fmt.Sprintf("fake-%d", fakeServersSeq),
+		ln:        ln,
+		conns:     map[net.Conn]bool{},
+		nodes:     map[uint64]*fakeNode{},
+		calls:     map[fakeProc]int{},
+		verf:      1,
+		rtpref:    64 * 1024,
+		wtpref:    64 * 1024,
+		mountProg: nfs.MountProg,
+		mountVers: []uint32{MOUNT_V1, MOUNT_V3},
+		nlm:       true,
+		locks:     map[string]string{},
+		fsstat:    fsstat{TBytes: 1 << 30, FBytes: 1 << 29, ABytes: 1 << 29, TFiles: 1000, FFiles: 900, AFiles: 900},
+		pathconf:  &pathconf{LinkMax: 32000, NameMax: 255, NoTrunc: true, ChownRestricted: true, CasePreserving: true},
+		now:       time.Now,
+		fhSize:    8,
+	}
+	fakeServers[srv.host] = srv
+	fakeServersLock.Unlock()
+	srv.root = srv.newNode(nil, "", nfs.NF3Dir, 0777)
+	srv.exports = []fakeExport{{dir: "/export", groups: []string{}, root: srv.root}}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.Lock()
+			srv.conns[conn] = true
+			srv.Unlock()
+			go srv.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		fakeServersLock.Lock()
+		delete(fakeServers, srv.host)
+		fakeServersLock.Unlock()
+		ln.Close()
+		srv.dropConns()
+	})
+	return srv
+}
+
+// share mounts /export the way a user would through the login form, with
+// params on top of the defaults
+func (this *fakeServer) share(t testing.TB, params map[string]string) NfsShare {
+	t.Helper()
+	s, err := this.init(t, params)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	return s.(NfsShare)
+}
+
+func (this *fakeServer) init(t testing.TB, params map[string]string) (IBackend, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return this.initCtx(ctx, params)
+}
+
+func (this *fakeServer) initCtx(ctx context.Context, params map[string]string) (IBackend, error) {
+	p := map[string]string{
+		"hostname": this.host,
+		"target":   "/export",
+		"uid":      "1000",
+		"gid":      "1000",
+	}
+	for k, v := range params {
+		p[k] = v
+	}
+	return NfsShare{}.Init(p, &App{Context: ctx})
+}
+
+func (this *fakeServer) serves(prog uint32, vers uint32) bool {
+	this.Lock()
+	defer this.Unlock()
+	switch prog {
+	case nfs.Nfs3Prog:
+		return vers == nfs.Nfs3Vers
+	case this.mountProg:
+		for _, v := range this.mountVers {
+			if v == vers {
+				return true
+			}
+		}
+	case NLM_PROG:
+		return this.nlm && vers == NLM_VERS
+	}
+	return false
+}
+
+func (this *fakeServer) dropConns() {
+	this.Lock()
+	defer this.Unlock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.conns = map[net.Conn]bool{}
+}
+
+// reboot gives a new write verifier, whatever wasn't committed is gone
+func (this *fakeServer) reboot() {
+	this.Lock()
+	defer this.Unlock()
+	this.verf += 1
+	for _, n := range this.nodes {
+		if n.typ == nfs.NF3Reg {
+			n.data = append([]byte{}, n.stable...)
+		}
+	}
+}
+
+// newRoot gives the root a handle of its own, the old one is stale from
+// there on as happens with servers that don't keep it across reboots
+func (this *fakeServer) newRoot() {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.nodes, this.root.id)
+	this.seq += 1
+	this.root.id = this.seq
+	this.nodes[this.root.id] = this.root
+}
+
+func (this *fakeServer) setHook(fn func(c *fakeCall) uint32) {
+	this.Lock()
+	this.hook = fn
+	this.Unlock()
+}
+
+func (this *fakeServer) count(proc uint32) int {
+	return this.countProg(nfs.Nfs3Prog, proc)
+}
+
+func (this *fakeServer) countProg(prog uint32, proc uint32) int {
+	this.Lock()
+	defer this.Unlock()
+	return this.calls[fakeProc{prog, proc}]
+}
+
+func (this *fakeServer) resetCounts() {
+	this.Lock()
+	this.calls = map[fakeProc]int{}
+	this.Unlock()
+}
+
+func (this *fakeServer) newNode(parent *fakeNode, name string, typ uint32, mode uint32) *fakeNode {
+	this.seq += 1
+	t := fakeTime(this.now())
+	n := &fakeNode{
+		id:    this.seq,
+		typ:   typ,
+		mode:  mode,
+		uid:   1000,
+		gid:   1000,
+		atime: t,
+		mtime: t,
+		ctime: t,
+		kids:  map[string]*fakeNode{},
+	}
+	if parent != nil {
+		n.fsid = parent.fsid
+		n.parent = parent
+		parent.kids[name] = n
+		parent.names = append(parent.names, name)
+		parent.mtime, parent.ctime = t, t
+	}
+	this.nodes[n.id] = n
+	return n
+}
+
+func (this *fakeServer) unlink(parent *fakeNode, name string) {
+	n := parent.kids[name]
+	delete(parent.kids, name)
+	for i := range parent.names {
+		if parent.names[i] == name {
+			parent.names = append(parent.names[:i:i], parent.names[i+1:]...)
+			break
+		}
+	}
+	t := fakeTime(this.now())
+	parent.mtime, parent.ctime = t, t
+	var forget func(n *fakeNode)
+	forget = func(n *fakeNode) {
+		delete(this.nodes, n.id)
+		for _, kid := range n.kids {
+			forget(kid)
+		}
+	}
+	if n != nil {
+		forget(n)
+	}
+}
+
+// add makes the node at path along with the directories leading to it
+func (this *fakeServer) add(path string, typ uint32, mode uint32) *fakeNode {
+	this.Lock()
+	defer this.Unlock()
+	dir := this.root
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, name := range parts {
+		if kid, ok := dir.kids[name]; ok {
+			if i == len(parts)-1 {
+				return kid
+			}
+			dir = kid
+			continue
+		} else if i == len(parts)-1 {
+			return this.newNode(dir, name, typ, mode)
+		}
+		dir = this.newNode(dir, name, nfs.NF3Dir, 0755)
+	}
+	return dir
+}
+
+func (this *fakeServer) dir(path string) *fakeNode {
+	return this.add(path, nfs.NF3Dir, 0755)
+}
+
+func (this *fakeServer) file(path string, content string) *fakeNode {
+	n := this.add(path, nfs.NF3Reg, 0644)
+	this.Lock()
+	n.data = []byte(content)
+	n.stable = []byte(content)
+	this.Unlock()
+	return n
+}
+
+func (this *fakeServer) symlink(path string, target string) *fakeNode {
+	n := this.add(path, nfs.NF3Lnk, 0777)
+	this.Lock()
+	n.link = target
+	this.Unlock()
+	return n
+}
+
+// node is what sits at path, nil when there's nothing
+func (this *fakeServer) node(path string) *fakeNode {
+	this.Lock()
+	defer this.Unlock()
+	n := this.root
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		} else if n = n.kids[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+func (this *fakeServer) content(path string) (string, bool) {
+	n := this.node(path)
+	if n == nil {
+		return "", false
+	}
+	this.Lock()
+	defer this.Unlock()
+	return string(n.data), true
+}
+
+func (this *fakeServer) names(path string) []string {
+	n := this.node(path)
+	if n == nil {
+		return nil
+	}
+	this.Lock()
+	defer this.Unlock()
+	return append([]string{}, n.names...)
+}
+
+func fakeTime(t time.Time) nfs.NFS3Time {
+	return nfs.NFS3Time{Seconds: uint32(t.Unix()), Nseconds: uint32(t.Nanosecond())}
+}
+
+func (this *fakeServer) serve(conn net.Conn) {
+	defer func() {
+		this.Lock()
+		delete(this.conns, conn)
+		this.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		var hdr uint32
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return
+		}
+		msg := make([]byte, hdr&0x7fffffff)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		reply, ok := this.handle(msg)
+		if ok == false {
+			return
+		}
+		frame := make([]byte, 4, 4+len(reply))
+		binary.BigEndian.PutUint32(frame, uint32(len(reply))|0x80000000)
+		if _, err := conn.Write(append(frame, reply...)); err != nil {
+			return
+		}
+	}
+}
+
+func (this *fakeServer) handle(msg []byte) ([]byte, bool) {
+	type CallHeader struct {
+		Xid     uint32
+		Msgtype uint32
+		Rpcvers uint32
+		Prog    uint32
+		Vers    uint32
+		Proc    uint32
+		Cred    rpc.Auth
+		Verf    rpc.Auth
+	}
+	type ReplyHeader struct {
+		Xid        uint32
+		Msgtype    uint32
+		ReplyStat  uint32
+		Verf       rpc.Auth
+		AcceptStat uint32
+	}
+	r := bytes.NewReader(msg)
+	h := CallHeader{}
+	if err := xdr.Read(r, &h); err != nil {
+		return nil, false
+	}
+	c := &fakeCall{
+		Prog: h.Prog,
+		Vers: h.Vers,
+		Proc: h.Proc,
+		Cred: h.Cred,
+		Args: msg[len(msg)-r.Len():],
+	}
+	this.Lock()
+	this.calls[fakeProc{c.Prog, c.Proc}] += 1
+	hook := this.hook
+	this.Unlock()
+	status := uint32(0)
+	if hook != nil {
+		status = hook(c)
+	}
+	lost := status == FAKE_LOST
+	if status == FAKE_DROP {
+		return nil, false
+	} else if lost {
+		status = 0
+	}
+
+	body := new(bytes.Buffer)
+	accept := uint32(RPC_PROG_UNAVAIL)
+	if this.serves(c.Prog, c.Vers) {
+		switch c.Prog {
+		case nfs.Nfs3Prog:
+			accept = this.nfs3(c, status, body)
+		case NLM_PROG:
+			accept = this.nlmd(c, body)
+		default:
+			accept = this.mountd(c, status, body)
+		}
+	}
+	if lost {
+		return nil, false
+	}
+	w := new(bytes.Buffer)
+	xdr.Write(w, &ReplyHeader{Xid: h.Xid, Msgtype: 1, Verf: rpc.AuthNull, AcceptStat: accept})
+	w.Write(body.Bytes())
+	return w.Bytes(), true
+}
+
+type fakeCred struct {
+	Stamp       uint32
+	Machinename string
+	Uid         uint32
+	Gid         uint32
+	Gids        []uint32
+}
+
+// owner is who the credential of a call ends up as on the server side
+func (this *fakeServer) owner(cred rpc.Auth) (uint32, uint32) {
+	a := fakeCred{}
+	if cred.Flavor != AUTH_SYS || xdr.Read(bytes.NewReader(cred.Body), &a) != nil {
+		return DEFAULT_ANON_ID, DEFAULT_ANON_ID
+	} else if this.squash == "all" || (this.squash == "root" && a.Uid == 0) {
+		return DEFAULT_ANON_ID, DEFAULT_ANON_ID
+	}
+	return a.Uid, a.Gid
+}
+
+func (this *fakeServer) handleNode(fh []byte) (*fakeNode, uint32) {
+	if len(fh) == 0 {
+		return this.root, 0
+	} else if len(fh) < this.fhSize || bytes.Equal(fh[8:this.fhSize], bytes.Repeat([]byte{0xfe}, this.fhSize-8)) == false {
+		return nil, nfs.NFS3ErrBadHandle
+	}
+	n, ok := this.nodes[binary.BigEndian.Uint64(fh[:8])]
+	if ok == false {
+		return nil, nfs.NFS3ErrStale
+	}
+	return n, 0
+}
+
+func (this *fakeServer) dirNode(fh []byte) (*fakeNode, uint32) {
+	n, status := this.handleNode(fh)
+	if status != 0 {
+		return nil, status
+	} else if n.typ != nfs.NF3Dir {
+		return nil, nfs.NFS3ErrNotDir
+	}
+	return n, 0
+}
+
+func (this *fakeServer) setattr(n *fakeNode, a nfs.Sattr3) {
+	now := fakeTime(this.now())
+	if a.Mode.SetIt {
+		n.mode = a.Mode.Mode & 07777
+	}
+	if a.UID.SetIt {
+		n.uid = a.UID.UID
+	}
+	if a.GID.SetIt {
+		n.gid = a.GID.UID
+	}
+	if a.Size.SetIt {
+		size := int(a.Size.Size)
+		if size < len(n.data) {
+			n.data = n.data[:size]
+		} else {
+			n.data = append(n.data, make([]byte, size-len(n.data))...)
+		}
+		n.mtime = now
+	}
+	switch a.Atime.SetIt {
+	case nfs.SetToServerTime:
+		n.atime = now
+	case nfs.SetToClientTime:
+		n.atime = a.Atime.Time
+	}
+	switch a.Mtime.SetIt {
+	case nfs.SetToServerTime:
+		n.mtime = now
+	case nfs.SetToClientTime:
+		n.mtime = a.Mtime.Time
+	}
+	n.ctime = now
+}
+
+func (this *fakeServer) nfs3(c *fakeCall, status uint32, w io.Writer) uint32 {
+	if status != 0 {
+		xdr.Write(w, status)
+		return 0
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.creds = append(this.creds, c.Cred)
+	switch c.Proc {
+	case NFSPROC3_SETATTR, NFSPROC3_WRITE, NFSPROC3_CREATE, NFSPROC3_MKDIR, NFSPROC3_SYMLINK,
+		NFSPROC3_REMOVE, NFSPROC3_RMDIR, NFSPROC3_RENAME:
+		if this.readOnly {
+			xdr.Write(w, uint32(nfs.NFS3ErrROFS))
+			return 0
+		}
+	}
+	res, status, ok := this.nfs3Proc(c)
+	if ok == false {
+		return RPC_PROC_UNAVAIL
+	}
+	xdr.Write(w, status)
+	if status == 0 {
+		for _, v := range res {
+			xdr.Write(w, v)
+		}
+	}
+	return 0
+}
+
+func (this *fakeServer) nfs3Proc(c *fakeCall) ([]interface{}, uint32, bool) {
+	type FhArgs struct {
+		FH []byte
+	}
+	type Entry struct {
+		IsSet bool          `xdr:"union"`
+		Entry nfs.EntryPlus `xdr:"unioncase=1"`
+	}
+	r := bytes.NewReader(c.Args)
+	now := fakeTime(this.now())
+	wcc := func(n *fakeNode) nfs.WccData {
+		return nfs.WccData{After: n.post()}
+	}
+	made := func(n *fakeNode, dir *fakeNode) []interface{} {
+		return []interface{}{nfs.PostOpFH3{IsSet: true, FH: this.fh(n)}, n.post(), wcc(dir)}
+	}
+
+	switch c.Proc {
+	case NFSPROC3_GETATTR:
+		args := FhArgs{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if n.deny&ACCESS3_READ != 0 && n.deny&ACCESS3_LOOKUP != 0 {
+			return nil, nfs.NFS3ErrAcces, true
+		}
+		return []interface{}{n.fattr()}, 0, true
+
+	case NFSPROC3_SETATTR:
+		args := struct {
+			FH    []byte
+			Attr  nfs.Sattr3
+			Guard struct {
+				Check bool         `xdr:"union"`
+				Ctime nfs.NFS3Time `xdr:"unioncase=1"`
+			}
+		}{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		this.setattr(n, args.Attr)
+		return []interface{}{wcc(n)}, 0, true
+
+	case NFSPROC3_LOOKUP:
+		args := nfs.Diropargs3{}
+		xdr.Read(r, &args)
+		dir, status := this.dirNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		n := dir.kids[args.Filename]
+		switch args.Filename {
+		case ".":
+			n = dir
+		case "..":
+			if n = dir.parent; n == nil {
+				n = dir
+			}
+		}
+		if n == nil {
+			return nil, nfs.NFS3ErrNoEnt, true
+		}
+		attr := n.post()
+		if n.noAttr {
+			attr = nfs.PostOpAttr{}
+		}
+		return []interface{}{this.fh(n), attr, dir.post()}, 0, true
+
+	case NFSPROC3_ACCESS:
+		args := struct {
+			FH     []byte
+			Access uint32
+		}{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		return []interface{}{n.post(), args.Access &^ n.deny}, 0, true
+
+	case NFSPROC3_READLINK:
+		args := FhArgs{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if n.typ != nfs.NF3Lnk {
+			return nil, nfs.NFS3ErrInval, true
+		}
+		return []interface{}{n.post(), n.link}, 0, true
+
+	case NFSPROC3_READ:
+		args := struct {
+			FH     []byte
+			Offset uint64
+			Count  uint32
+		}{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if n.typ == nfs.NF3Dir {
+			return nil, nfs.NFS3ErrIsDir, true
+		} else if n.typ != nfs.NF3Reg {
+			return nil, nfs.NFS3ErrInval, true
+		} else if n.deny&ACCESS3_READ != 0 {
+			return nil, nfs.NFS3ErrAcces, true
+		}
+		data := []byte{}
+		if args.Offset < uint64(len(n.data)) {
+			data = n.data[args.Offset:]
+		}
+		if uint32(len(data)) > args.Count {
+			data = data[:args.Count]
+		}
+		eof := args.Offset+uint64(len(data)) >= uint64(len(n.data))
+		return []interface{}{n.post(), uint32(len(data)), eof, data}, 0, true
+
+	case NFSPROC3_WRITE:
+		args := struct {
+			FH       []byte
+			Offset   uint64
+			Count    uint32
+			How      uint32
+			Contents []byte
+		}{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if n.typ != nfs.NF3Reg {
+			return nil, nfs.NFS3ErrInval, true
+		}
+		data := args.Contents
+		if uint32(len(data)) > this.wtpref {
+			data = data[:this.wtpref]
+		}
+		end := int(args.Offset) + len(data)
+		if end > len(n.data) {
+			n.data = append(n.data, make([]byte, end-len(n.data))...)
+		}
+		copy(n.data[args.Offset:], data)
+		n.mtime, n.ctime = now, now
+		committed := uint32(WRITE_UNSTABLE)
+		if args.How != WRITE_UNSTABLE {
+			n.stable = append([]byte{}, n.data...)
+			committed = WRITE_FILE_SYNC
+		}
+		return []interface{}{wcc(n), uint32(len(data)), committed, this.verf}, 0, true
+
+	case NFSPROC3_CREATE:
+		where := nfs.Diropargs3{}
+		xdr.Read(r, &where)
+		mode, _ := xdr.ReadUint32(r)
+		attr := nfs.Sattr3{}
+		var verf uint64
+		if mode == CREATE_EXCLUSIVE {
+			xdr.Read(r, &verf)
+		} else {
+			xdr.Read(r, &attr)
+		}
+		dir, status := this.dirNode(where.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if dir.deny&ACCESS3_EXTEND != 0 {
+			return nil, nfs.NFS3ErrAcces, true
+		}
+		if n, ok := dir.kids[where.Filename]; ok {
+			switch {
+			case mode == CREATE_EXCLUSIVE && n.verf == verf:
+			case mode == CREATE_UNCHECKED && n.typ == nfs.NF3Reg:
+				this.setattr(n, attr)
+			default:
+				return nil, nfs.NFS3ErrExist, true
+			}
+			return made(n, dir), 0, true
+		}
+		n := this.newNode(dir, where.Filename, nfs.NF3Reg, 0644)
+		n.uid, n.gid = this.owner(c.Cred)
+		n.verf = verf
+		this.setattr(n, attr)
+		return made(n, dir), 0, true
+
+	case NFSPROC3_MKDIR:
+		args := struct {
+			Where nfs.Diropargs3
+			Attr  nfs.Sattr3
+		}{}
+		xdr.Read(r, &args)
+		dir, status := this.dirNode(args.Where.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if _, ok := dir.kids[args.Where.Filename]; ok {
+			return nil, nfs.NFS3ErrExist, true
+		} else if dir.deny&ACCESS3_EXTEND != 0 {
+			return nil, nfs.NFS3ErrAcces, true
+		}
+		n := this.newNode(dir, args.Where.Filename, nfs.NF3Dir, 0755)
+		n.uid, n.gid = this.owner(c.Cred)
+		this.setattr(n, args.Attr)
+		if this.noSpecial {
+			n.mode &^= 07000
+		}
+		return made(n, dir), 0, true
+
+	case NFSPROC3_SYMLINK:
+		args := struct {
+			Where nfs.Diropargs3
+			Attr  nfs.Sattr3
+			Path  string
+		}{}
+		xdr.Read(r, &args)
+		dir, status := this.dirNode(args.Where.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if _, ok := dir.kids[args.Where.Filename]; ok {
+			return nil, nfs.NFS3ErrExist, true
+		}
+		n := this.newNode(dir, args.Where.Filename, nfs.NF3Lnk, 0777)
+		n.uid, n.gid = this.owner(c.Cred)
+		n.link = args.Path
+		return made(n, dir), 0, true
+
+	case NFSPROC3_REMOVE, NFSPROC3_RMDIR:
+		args := nfs.Diropargs3{}
+		xdr.Read(r, &args)
+		dir, status := this.dirNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		n, ok := dir.kids[args.Filename]
+		if ok == false {
+			return nil, nfs.NFS3ErrNoEnt, true
+		} else if dir.deny&ACCESS3_DELETE != 0 {
+			return nil, nfs.NFS3ErrAcces, true
+		} else if c.Proc == NFSPROC3_REMOVE && n.typ == nfs.NF3Dir {
+			return nil, nfs.NFS3ErrIsDir, true
+		} else if c.Proc == NFSPROC3_RMDIR && n.typ != nfs.NF3Dir {
+			return nil, nfs.NFS3ErrNotDir, true
+		} else if c.Proc == NFSPROC3_RMDIR && len(n.kids) > 0 {
+			return nil, nfs.NFS3ErrNotEmpty, true
+		}
+		this.unlink(dir, args.Filename)
+		return []interface{}{wcc(dir)}, 0, true
+
+	case NFSPROC3_RENAME:
+		args := struct {
+			From nfs.Diropargs3
+			To   nfs.Diropargs3
+		}{}
+		xdr.Read(r, &args)
+		from, status := this.dirNode(args.From.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		to, status := this.dirNode(args.To.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		n, ok := from.kids[args.From.Filename]
+		if ok == false {
+			return nil, nfs.NFS3ErrNoEnt, true
+		} else if from.fsid != to.fsid {
+			return nil, nfs.NFS3ErrXDev, true
+		}
+		if old, ok := to.kids[args.To.Filename]; ok && old != n {
+			if old.typ == nfs.NF3Dir && len(old.kids) > 0 {
+				return nil, nfs.NFS3ErrNotEmpty, true
+			} else if (old.typ == nfs.NF3Dir) != (n.typ == nfs.NF3Dir) {
+				return nil, nfs.NFS3ErrExist, true
+			}
+			this.unlink(to, args.To.Filename)
+		}
+		delete(from.kids, args.From.Filename)
+		for i := range from.names {
+			if from.names[i] == args.From.Filename {
+				from.names = append(from.names[:i:i], from.names[i+1:]...)
+				break
+			}
+		}
+		to.kids[args.To.Filename] = n
+		to.names = append(to.names, args.To.Filename)
+		n.parent = to
+		n.ctime = now
+		from.mtime, from.ctime, to.mtime, to.ctime = now, now, now, now
+		return []interface{}{wcc(from), wcc(to)}, 0, true
+
+	case NFSPROC3_READDIRPLUS:
+		args := struct {
+			FH         []byte
+			Cookie     uint64
+			CookieVerf uint64
+			DirCount   uint32
+			MaxCount   uint32
+		}{}
+		xdr.Read(r, &args)
+		dir, status := this.dirNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		} else if dir.deny&ACCESS3_READ != 0 {
+			return nil, nfs.NFS3ErrAcces, true
+		} else if args.Cookie != 0 && args.CookieVerf != this.cookieVerf {
+			return nil, nfs.NFS3ErrBadCookie, true
+		}
+		parent := dir.parent
+		if parent == nil {
+			parent = dir
+		}
+		names := append([]string{".", ".."}, dir.names...)
+		res := []interface{}{dir.post(), this.cookieVerf}
+		i := int(args.Cookie)
+		for ; i < len(names) && (this.page == 0 || i < int(args.Cookie)+this.page); i++ {
+			n := dir.kids[names[i]]
+			switch names[i] {
+			case ".":
+				n = dir
+			case "..":
+				n = parent
+			}
+			e := nfs.EntryPlus{
+				FileId:   n.id,
+				FileName: names[i],
+				Cookie:   uint64(i + 1),
+				Handle:   nfs.PostOpFH3{IsSet: true, FH: this.fh(n)},
+			}
+			if n.noAttr == false && this.plusNoAttr == false {
+				e.Attr = n.post()
+			}
+			res = append(res, Entry{IsSet: true, Entry: e})
+		}
+		return append(res, Entry{}, i >= len(names)), 0, true
+
+	case NFSPROC3_FSSTAT:
+		args := FhArgs{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		s := this.fsstat
+		s.Attr = n.post()
+		return []interface{}{s}, 0, true
+
+	case NFSPROC3_FSINFO:
+		return []interface{}{nfs.FSInfo{
+			Attr:       this.root.post(),
+			RTMax:      this.rtpref,
+			RTPref:     this.rtpref,
+			RTMult:     1,
+			WTMax:      this.wtpref,
+			WTPref:     this.wtpref,
+			WTMult:     1,
+			DTPref:     8192,
+			Size:       1 << 40,
+			TimeDelta:  nfs.NFS3Time{Nseconds: 1},
+			Properties: 0x1b,
+		}}, 0, true
+
+	case NFSPROC3_PATHCONF:
+		if this.pathconf == nil {
+			return nil, nfs.NFS3ErrNotSupp, true
+		}
+		return []interface{}{*this.pathconf}, 0, true
+
+	case NFSPROC3_COMMIT:
+		args := FhArgs{}
+		xdr.Read(r, &args)
+		n, status := this.handleNode(args.FH)
+		if status != 0 {
+			return nil, status, true
+		}
+		n.stable = append([]byte{}, n.data...)
+		return []interface{}{wcc(n), this.verf}, 0, true
+	}
+	return nil, 0, false
+}
+
+func (this *fakeServer) mountd(c *fakeCall, status uint32, w io.Writer) uint32 {
+	this.Lock()
+	defer this.Unlock()
+	r := bytes.NewReader(c.Args)
+	switch c.Proc {
+	case 0, nfs.MountProc3UMNT:
+		return 0
+	case nfs.MountProc3MNT:
+		dir, _ := xdr.ReadOpaque(r)
+		this.mnts = append(this.mnts, string(dir))
+		if status != 0 {
+			xdr.Write(w, status)
+			return 0
+		}
+		for _, e := range this.exports {
+			if e.dir != string(dir) {
+				continue
+			}
+			xdr.Write(w, uint32(nfs.MNT3Ok))
+			if c.Vers == MOUNT_V1 {
+				fh := make([]byte, MOUNT_V1_FHSIZE)
+				copy(fh, this.fh(e.root))
+				w.Write(fh)
+				return 0
+			}
+			xdr.Write(w, this.fh(e.root))
+			xdr.Write(w, []uint32{AUTH_SYS})
+			return 0
+		}
+		xdr.Write(w, uint32(nfs.MNT3ErrNoEnt))
+		return 0
+	case nfs.MountProc3Export:
+		for _, e := range this.exports {
+			xdr.Write(w, true)
+			xdr.Write(w, e.dir)
+			for _, g := range e.groups {
+				xdr.Write(w, true)
+				xdr.Write(w, g)
+			}
+			xdr.Write(w, false)
+		}
+		xdr.Write(w, false)
+		return 0
+	}
+	return RPC_PROC_UNAVAIL
+}
+
+// export adds an export of its own root to the list mountd has
+func (this *fakeServer) export(dir string, groups ...string) *fakeNode {
+	this.Lock()
+	defer this.Unlock()
+	root := this.newNode(nil, "", nfs.NF3Dir, 0777)
+	this.exports = append(this.exports, fakeExport{dir: dir, groups: groups, root: root})
+	return root
+}
+
+func (this *fakeServer) nlmd(c *fakeCall, w io.Writer) uint32 {
+	this.Lock()
+	defer this.Unlock()
+	r := bytes.NewReader(c.Args)
+	cookie, _ := xdr.ReadOpaque(r)
+	lock := nlm4Lock{}
+	switch c.Proc {
+	case NLM4_LOCK:
+		var block, exclusive bool
+		xdr.Read(r, &block)
+		xdr.Read(r, &exclusive)
+		xdr.Read(r, &lock)
+	case NLM4_UNLOCK:
+		xdr.Read(r, &lock)
+	default:
+		return RPC_PROC_UNAVAIL
+	}
+	stat := uint32(NLM4_GRANTED)
+	owner, held := this.locks[string(lock.FH)]
+	if c.Proc == NLM4_UNLOCK {
+		delete(this.locks, string(lock.FH))
+	} else if held && owner != string(lock.Owner) {
+		stat = NLM4_DENIED
+	} else {
+		this.locks[string(lock.FH)] = string(lock.Owner)
+	}
+	xdr.Write(w, cookie)
+	xdr.Write(w, stat)
+	return 0
+}
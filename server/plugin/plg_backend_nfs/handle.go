@@ -0,0 +1,61 @@
+package plg_backend_nfs
+
+import (
+	"encoding/hex"
+	"io"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+var ErrStaleHandle = NewError("File handle is stale, the file needs to be looked up again", 410)
+
+// Handle gives the file handle of path in hex. Handles are how the server
+// identifies a file regardless of its name, sync and dedup tools can hold
+// on to them and read the content with CatHandle later on without going
+// through the path again
+func (this NfsShare) Handle(path string) (_ string, err error) {
+	defer this.Close()
+	defer this.wrapError("handle", path, &err)
+	this.metadataOp()
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(fh), nil
+}
+
+// CatHandle is Cat from a handle given by Handle. Handles outlive renames
+// but not deletion, nor a server that doesn't keep them across reboots, in
+// which case the server says they're stale
+func (this NfsShare) CatHandle(handle string) (_ io.ReadCloser, err error) {
+	defer this.wrapError("cat", handle, &err)
+	this.dataOp()
+	fh, err := hex.DecodeString(handle)
+	if err != nil || checkFh(fh) != nil || len(fh) == 0 {
+		this.Close()
+		return nil, NewError("File handle: expected a handle in hex", 400)
+	}
+	attr, err := this.getattr(fh)
+	if isNfsError(err, nfs.NFS3ErrStale) || isNfsError(err, nfs.NFS3ErrBadHandle) {
+		this.Close()
+		return nil, ErrStaleHandle
+	} else if err != nil {
+		this.Close()
+		return nil, err
+	} else if attr.Type != nfs.NF3Reg {
+		this.Close()
+		return nil, ErrNotValid
+	}
+	window := this.prefetch
+	if window < 1 {
+		window = 1
+	}
+	r, err := this.newPrefetchReader(fh, window)
+	if err != nil {
+		this.Close()
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,51 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCatHandle(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/sync/a.txt", "hello world")
+	srv.dir("/sync/folder")
+
+	handle, err := srv.share(t, nil).Handle("/sync/a.txt")
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	// handles outlive renames
+	if err = srv.share(t, nil).Mv("/sync/a.txt", "/sync/b.txt"); err != nil {
+		t.Fatalf("mv: %v", err)
+	}
+	srv.resetCounts()
+	r, err := srv.share(t, nil).CatHandle(handle)
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	} else if n := srv.count(NFSPROC3_LOOKUP); n != 0 {
+		t.Fatalf("expected no path lookup, got %d LOOKUP", n)
+	}
+
+	if err = srv.share(t, nil).Rm("/sync/b.txt"); err != nil {
+		t.Fatalf("rm: %v", err)
+	}
+	if _, err = srv.share(t, nil).CatHandle(handle); errors.Is(err, ErrStaleHandle) == false {
+		t.Fatalf("expected a stale handle, got %v", err)
+	}
+
+	dir, err := srv.share(t, nil).Handle("/sync/folder")
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	for _, handle := range []string{dir, "nothex", ""} {
+		if _, err = srv.share(t, nil).CatHandle(handle); err == nil {
+			t.Fatalf("expected %q to be refused", handle)
+		}
+	}
+}
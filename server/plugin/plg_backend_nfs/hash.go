@@ -0,0 +1,61 @@
+package plg_backend_nfs
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// READs kept in flight when hashing unless the share prefetches more
+const HASH_PREFETCH = 4
+
+// Hash computes the digest of a file as it streams through. NFS has no such
+// thing as a server side checksum, the content has to come our way but
+// never sits in memory as a whole. It stops as soon as the request is gone
+func (this NfsShare) Hash(path string, algo string) (_ string, err error) {
+	defer this.Close()
+	defer this.wrapError("hash", path, &err)
+	this.dataOp()
+
+	var h hash.Hash
+	switch algo {
+	case "sha256", "":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return "", NewError("Hash: unsupported algorithm", 400)
+	}
+	if err = this.pool.coalescer.flushNow(this.nfsPath(path)); err != nil {
+		return "", err
+	}
+	attr, fh, err := this.resolve(this.nfsPath(path))
+	if err == nil && attr == nil {
+		attr, err = this.getattr(fh)
+	}
+	if err != nil {
+		return "", err
+	} else if attr.Filesize == 0 {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	window := this.prefetch
+	if window < HASH_PREFETCH {
+		window = HASH_PREFETCH
+	}
+	r, err := this.newPrefetchReader(fh, window)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err = io.Copy(h, r); err != nil {
+		if this.ctx.Err() != nil {
+			return "", this.ctx.Err()
+		}
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
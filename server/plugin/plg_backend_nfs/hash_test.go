@@ -0,0 +1,64 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestHash(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/fox.txt", "The quick brown fox jumps over the lazy dog")
+	srv.file("/empty.txt", "")
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	srv.file("/big.bin", string(big))
+	sum := sha256.Sum256(big)
+
+	for _, c := range []struct {
+		path     string
+		algo     string
+		expected string
+	}{
+		{"/fox.txt", "sha256", "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592"},
+		{"/fox.txt", "", "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592"},
+		{"/fox.txt", "md5", "9e107d9d372bb6826bd81d3542a419d6"},
+		{"/empty.txt", "sha256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"/big.bin", "sha256", hex.EncodeToString(sum[:])},
+	} {
+		got, err := srv.share(t, nil).Hash(c.path, c.algo)
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.path, c.algo, err)
+		} else if got != c.expected {
+			t.Fatalf("%s %s: expected %s, got %s", c.path, c.algo, c.expected, got)
+		}
+	}
+	if _, err := srv.share(t, nil).Hash("/fox.txt", "crc32"); err == nil {
+		t.Fatalf("expected an unknown algorithm to be refused")
+	}
+}
+
+func TestHashCancel(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/big.bin", string(bytes.Repeat([]byte("0123456789abcdef"), 64*1024)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := srv.initCtx(ctx, nil)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	// gone as soon as the content starts coming
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READ {
+			cancel()
+		}
+		return 0
+	})
+	if _, err = a.(NfsShare).Hash("/big.bin", "sha256"); errors.Is(err, context.Canceled) == false {
+		t.Fatalf("expected the hash to stop, got %v", err)
+	}
+}
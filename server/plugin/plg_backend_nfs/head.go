@@ -0,0 +1,57 @@
+package plg_backend_nfs
+
+import (
+	"net/http"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// past this, Cat is what should be used
+const HEAD_MAX = 1024 * 1024
+
+// Head gives up to the first n bytes of a file with a single READ, which
+// is all a preview or a content type sniff needs. Unlike Cat there's no
+// stream to keep open, the connection goes back to the pool straight away.
+// Servers cap what a single READ returns so less than n might come back
+// even when the file is larger
+func (this NfsShare) Head(path string, n int64) (_ []byte, err error) {
+	defer this.Close()
+	defer this.wrapError("head", path, &err)
+	this.dataOp()
+
+	if n < 0 {
+		return nil, ErrNotValid
+	} else if n == 0 {
+		return []byte{}, nil
+	}
+	if err = this.pool.coalescer.flushNow(this.nfsPath(path)); err != nil {
+		return nil, err
+	}
+	attr, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return nil, err
+	} else if attr != nil && attr.Type == nfs.NF3Reg && attr.Filesize == 0 {
+		return []byte{}, nil
+	}
+	if n > HEAD_MAX {
+		n = HEAD_MAX
+	}
+	var data []byte
+	err = this.jukebox(func() (err error) {
+		data, _, err = this.read(fh, 0, uint32(n))
+		return err
+	})
+	return data, err
+}
+
+// Mime sniffs the content type out of the first bytes of a file as of:
+// https://mimesniff.spec.whatwg.org
+func (this NfsShare) Mime(path string) (string, error) {
+	data, err := this.Head(path, 512)
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(data), nil
+}
@@ -0,0 +1,41 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestHead(t *testing.T) {
+	srv := newFakeServer(t)
+	content := append([]byte("%PDF-1.7\n"), bytes.Repeat([]byte("x"), 4096)...)
+	srv.file("/doc.pdf", string(content))
+	srv.file("/short.txt", "hello")
+	srv.file("/empty.txt", "")
+
+	s := srv.share(t, nil)
+	srv.resetCounts()
+	data, err := s.Head("/doc.pdf", 512)
+	if err != nil {
+		t.Fatalf("head: %v", err)
+	} else if bytes.Equal(data, content[:512]) == false {
+		t.Fatalf("expected the first 512 bytes, got %d", len(data))
+	} else if n := srv.count(NFSPROC3_READ); n != 1 {
+		t.Fatalf("expected a single READ, got %d", n)
+	}
+	for path, expected := range map[string]string{"/short.txt": "hello", "/empty.txt": ""} {
+		if data, err = srv.share(t, nil).Head(path, 512); err != nil {
+			t.Fatalf("head %s: %v", path, err)
+		} else if string(data) != expected {
+			t.Fatalf("head %s: unexpected '%s'", path, data)
+		}
+	}
+	if _, err = srv.share(t, nil).Head("/doc.pdf", -1); errors.Is(err, ErrNotValid) == false {
+		t.Fatalf("expected a negative size to be refused, got %v", err)
+	}
+	if mime, err := srv.share(t, nil).Mime("/doc.pdf"); err != nil || mime != "application/pdf" {
+		t.Fatalf("expected a pdf, got '%s' %v", mime, err)
+	}
+}
@@ -0,0 +1,85 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// WithIdentity gives a share acting as another AUTH_SYS identity, for admin
+// actions like fixing permissions that need to happen as a given user
+// without reconfiguring the whole share. The credential of go-nfs-client
+// is set once and for all when mounting, the share it gives is backed by
+// connections of their own, pooled like any other
+func (this NfsShare) WithIdentity(uid uint32, gid uint32) (NfsShare, error) {
+	defer this.Close()
+	params := make(map[string]string, len(this.params))
+	for k, v := range this.params {
+		params[k] = v
+	}
+	params["uid"] = strconv.FormatUint(uint64(uid), 10)
+	params["gid"] = strconv.FormatUint(uint64(gid), 10)
+	delete(params, "auth_flavor")
+
+	auth, uid, gid, err := credential(params)
+	if err != nil {
+		return this, err
+	}
+	s := this
+	s.auth = auth
+	s.uid = uid
+	s.gid = gid
+	s.params = params
+	return s.acquire(s.poolFor(params), params)
+}
+
+const AUTH_SYS = 1
+
+// NfsIdentity is who the server sees us as. Groups are the supplementary
+// gids of AUTH_SYS, go-nfs-client always sends 0 in there
+type NfsIdentity struct {
+	Flavor      string
+	UID         uint32
+	GID         uint32
+	Groups      []uint32
+	MachineName string
+}
+
+// WhoAmI tells what credential the share ended up with once uid and gid
+// hints went through /etc/passwd, squash_root and auth_flavor. It's read
+// back from the credential we sign our calls with rather than from the
+// params. With AUTH_NULL, the ids are the anonymous ones we expect the
+// server to map us onto
+func (this NfsShare) WhoAmI() (NfsIdentity, error) {
+	defer this.Close()
+	if this.auth.Flavor != AUTH_SYS {
+		return NfsIdentity{
+			Flavor: "none",
+			UID:    this.uid,
+			GID:    this.gid,
+			Groups: []uint32{},
+		}, nil
+	}
+	// authsys_parms as of RFC5531 in:
+	// https://www.rfc-editor.org/rfc/rfc5531#appendix-A
+	// the machine name is padded to 4 bytes like any other XDR string
+	type AuthSys struct {
+		Stamp       uint32
+		MachineName string
+		Uid         uint32
+		Gid         uint32
+		Gids        []uint32
+	}
+	a := AuthSys{}
+	if err := xdr.Read(bytes.NewReader(this.auth.Body), &a); err != nil {
+		return NfsIdentity{Flavor: "sys"}, err
+	}
+	return NfsIdentity{
+		Flavor:      "sys",
+		UID:         a.Uid,
+		GID:         a.Gid,
+		Groups:      a.Gids,
+		MachineName: a.MachineName,
+	}, nil
+}
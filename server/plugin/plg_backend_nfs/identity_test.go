@@ -0,0 +1,203 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+func passwdFixture(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("fixture: %v", err)
+	}
+	previous := ETC_PASSWD
+	ETC_PASSWD = path
+	t.Cleanup(func() { ETC_PASSWD = previous })
+}
+
+func TestWhoAmIUsernameHint(t *testing.T) {
+	passwdFixture(t, "root:x:0:0:root:/root:/bin/sh\n"+
+		"whoami-alice:x:2001:3001:Alice:/home/alice:/bin/sh\n")
+	srv := newFakeServer(t)
+	s := srv.share(t, map[string]string{
+		"uid":          "whoami-alice",
+		"gid":          "whoami-alice",
+		"machine_name": "filestash",
+	})
+
+	id, err := s.WhoAmI()
+	if err != nil {
+		t.Fatalf("whoami: %v", err)
+	}
+	if id.Flavor != "sys" || id.UID != 2001 || id.GID != 3001 {
+		t.Fatalf("unexpected identity %+v", id)
+	} else if id.MachineName != "filestash" {
+		t.Fatalf("unexpected machine name '%s'", id.MachineName)
+	}
+	// and that's who the server sees
+	srv.Lock()
+	uid, gid := srv.owner(srv.creds[len(srv.creds)-1])
+	srv.Unlock()
+	if uid != id.UID || gid != id.GID {
+		t.Fatalf("server saw %d:%d", uid, gid)
+	}
+}
+
+func TestWhoAmIMachineNameLengths(t *testing.T) {
+	srv := newFakeServer(t)
+	for _, name := range []string{"a", "ab", "abc", "abcd", "filestash"} {
+		s := srv.share(t, map[string]string{"uid": "42", "gid": "43", "machine_name": name})
+		id, err := s.WhoAmI()
+		if err != nil {
+			t.Fatalf("whoami: %v", err)
+		} else if id.MachineName != name || id.UID != 42 || id.GID != 43 {
+			t.Fatalf("machine name '%s': unexpected identity %+v", name, id)
+		}
+	}
+}
+
+func TestWhoAmIAnonymous(t *testing.T) {
+	srv := newFakeServer(t)
+	s := srv.share(t, map[string]string{"auth_flavor": "anonymous"})
+	id, err := s.WhoAmI()
+	if err != nil {
+		t.Fatalf("whoami: %v", err)
+	} else if id.Flavor != "none" || id.UID != DEFAULT_ANON_ID || id.GID != DEFAULT_ANON_ID {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+}
+
+func TestAuthStamp(t *testing.T) {
+	srv := newFakeServer(t)
+	s := srv.share(t, map[string]string{"uid": "42", "gid": "43", "machine_name": "filestash", "auth_stamp": "4242"})
+	if _, err := s.Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	srv.Lock()
+	defer srv.Unlock()
+	for _, cred := range srv.creds {
+		a := struct {
+			Stamp       uint32
+			MachineName string
+		}{}
+		if err := xdr.Read(bytes.NewReader(cred.Body), &a); err != nil {
+			t.Fatalf("credential: %v", err)
+		} else if a.Stamp != 4242 || a.MachineName != "filestash" {
+			t.Fatalf("expected the configured stamp on every call, got %d from '%s'", a.Stamp, a.MachineName)
+		}
+	}
+}
+
+func TestAnonymousCredential(t *testing.T) {
+	srv := newFakeServer(t)
+	// what's configured for AUTH_SYS is ignored in anonymous mode
+	anonymous := map[string]string{"auth_flavor": "anonymous", "uid": "1000", "gid": "1000"}
+	report, err := srv.share(t, anonymous).DiagnoseExport()
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	} else if report.Writable == false || report.AllSquash || len(report.Notes) != 0 {
+		t.Fatalf("expected files to be created as the anonymous user, got %+v", report)
+	}
+	srv.Lock()
+	for _, cred := range srv.creds {
+		if cred.Flavor == AUTH_SYS {
+			srv.Unlock()
+			t.Fatalf("expected AUTH_NULL on every call")
+		}
+	}
+	srv.Unlock()
+
+	// expecting another anonymous user than the one of the server
+	anonymous["anon_uid"] = "99"
+	anonymous["anon_gid"] = "98"
+	s := srv.share(t, anonymous)
+	if id, err := s.WhoAmI(); err != nil {
+		t.Fatalf("whoami: %v", err)
+	} else if id.UID != 99 || id.GID != 98 {
+		t.Fatalf("expected the configured anonymous ids, got %+v", id)
+	}
+	if report, err = srv.share(t, anonymous).DiagnoseExport(); err != nil {
+		t.Fatalf("diagnose: %v", err)
+	} else if report.AllSquash == false {
+		t.Fatalf("expected the mismatch to be reported, got %+v", report)
+	}
+}
+
+func TestRefreshCredential(t *testing.T) {
+	passwdFixture(t, "refresh-bob:x:2002:3001:Bob:/home/bob:/bin/sh\n")
+	srv := newFakeServer(t)
+	params := map[string]string{"uid": "refresh-bob", "gid": "refresh-bob"}
+	if _, err := srv.share(t, params).Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	gids := func() map[uint32]bool {
+		srv.Lock()
+		defer srv.Unlock()
+		seen := map[uint32]bool{}
+		for _, cred := range srv.creds {
+			_, gid := srv.owner(cred)
+			seen[gid] = true
+		}
+		srv.creds = nil
+		return seen
+	}
+	gids()
+
+	// bob moved to another group since
+	passwdFixture(t, "refresh-bob:x:2002:3002:Bob:/home/bob:/bin/sh\n")
+	s, err := srv.share(t, params).RefreshCredential()
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if _, err = s.Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if seen := gids(); len(seen) != 1 || seen[3002] == false {
+		t.Fatalf("expected our own calls to carry the new group straight away, got %v", seen)
+	}
+
+	// the connection was retired, the next one is mounted with it
+	if err = srv.share(t, params).Save("/created.txt", strings.NewReader("refreshed")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if seen := gids(); len(seen) != 1 || seen[3002] == false {
+		t.Fatalf("expected the new group on every call, got %v", seen)
+	} else if n := srv.node("/created.txt"); n.gid != 3002 {
+		t.Fatalf("expected the file created with the new group, got %d", n.gid)
+	}
+}
+
+func TestWithIdentity(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.dir("/shared")
+	params := map[string]string{"uid": "1000", "gid": "1000"}
+
+	admin, err := srv.share(t, params).WithIdentity(2000, 3000)
+	if err != nil {
+		t.Fatalf("with identity: %v", err)
+	} else if err = admin.Save("/shared/as.txt", strings.NewReader("as")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if err = admin.Mkdir("/shared/folder/"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// the share it came from is left as it was
+	if err = srv.share(t, params).Save("/shared/own.txt", strings.NewReader("own")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	for path, expected := range map[string][2]uint32{
+		"/shared/as.txt":  {2000, 3000},
+		"/shared/folder":  {2000, 3000},
+		"/shared/own.txt": {1000, 1000},
+	} {
+		n := srv.node(path)
+		if n == nil {
+			t.Fatalf("%s: not found", path)
+		} else if n.uid != expected[0] || n.gid != expected[1] {
+			t.Fatalf("%s: expected %d:%d, got %d:%d", path, expected[0], expected[1], n.uid, n.gid)
+		}
+	}
+}
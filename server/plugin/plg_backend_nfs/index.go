@@ -2,39 +2,109 @@ package plg_backend_nfs
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 
 	"github.com/vmware/go-nfs-client/nfs"
 	"github.com/vmware/go-nfs-client/nfs/rpc"
 	"github.com/vmware/go-nfs-client/nfs/util"
-	"github.com/vmware/go-nfs-client/nfs/xdr"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
 )
 
 const (
 	DEFAULT_UID = 1000
 	DEFAULT_GID = 1000
+	// nfsnobody, the default anonuid/anongid on linux servers
+	DEFAULT_ANON_ID = 65534
+
+	DEFAULT_METADATA_TIMEOUT = 30 * time.Second
+	DEFAULT_DATA_TIMEOUT     = 5 * time.Minute
+
+	DEFAULT_JUKEBOX_DELAY = 5 * time.Second
+	DEFAULT_JUKEBOX_MAX   = 60 * time.Second
 )
 
 type NfsShare struct {
 	mount *nfs.Mount
 	v     *nfs.Target
+	conn  *nfsConn
+	pool  *nfsPool
+	once  *sync.Once
+	busy  *sync.Mutex // held while a stream is using the connection
 	auth  rpc.Auth
 	ctx   context.Context
 	uid   uint32
 	gid   uint32
+	host  string
+	raw   bool
+
+	params map[string]string
+
+	zipSkipErrors bool
+	datedLayout   string
+	charset       encoding.Encoding
+	normalization string
+	dotEntries    bool
+	displayRoot   string
+	sizeOnDisk    bool
+	mkdirParents  bool
+	verifyWrites  bool
+	showTypes     map[uint32]bool
+	windowsPaths  bool
+	ftypes        map[uint32]string
+	prefetch      int
+	listPrefetch  bool
+	listCache     time.Duration
+	exclude       []string
+	unstatEntries string
+	onCollision   string
+	rmSymlinks    string
+	listOnly      string
+	rootRefresh   bool
+	noList        bool
+	debug         bool
+	mvFallback    string
+	noRecursive   bool
+	maxEntries    int
+	dirMode       os.FileMode
+	slowThreshold time.Duration
+
+	metadataTimeout time.Duration
+	dataTimeout     time.Duration
+	jukeboxDelay    time.Duration
+	jukeboxMax      time.Duration
+	stallTimeout    time.Duration
+	chown           nfs.Sattr3
+	coalesce        time.Duration
 }
 
 func init() {
 	Backend.Register("nfs", NfsShare{})
 	util.DefaultLogger.SetDebug(false)
 	cacheForEtc = NewAppCache(120, 60)
+
+	NfsCache = NewAppCache()
+	NfsCache.OnEvict(func(key string, value interface{}) {
+		if p, ok := value.(*nfsPool); ok {
+			p.coalescer.Close()
+			p.evict()
+		}
+	})
 }
 
 func (this NfsShare) Init(params map[string]string, app *App) (IBackend, error) {
@@ -45,21 +115,304 @@ func (this NfsShare) Init(params map[string]string, app *App) (IBackend, error)
 	if params["machine_name"] == "" {
 		params["machine_name"] = "filestash"
 	}
+	if params["targets"] != "" {
+		return this.initMulti(params, app)
+	}
 
-	uid := getUid(params["uid"])
-	gid := getGid(params["gid"])
-	auth := rpc.NewAuthUnix(params["machine_name"], uid, gid).Auth()
-	mount, err := nfs.DialMount(params["hostname"])
+	s, err := newShare(params, app)
 	if err != nil {
 		return nil, err
 	}
-	v, err := mount.Mount(params["target"], auth)
+	s, err = s.acquire(s.poolFor(params), params)
+	if err != nil {
+		return nil, err
+	} else if params["warm_up"] == "true" && s.noList == false {
+		s.warmUp()
+	}
+	go func() {
+		<-app.Context.Done()
+		s.Close()
+	}()
+	return s, nil
+}
+
+// newShare turns the connection params into a share, nothing goes over the
+// network until it gets a connection from acquire
+func newShare(params map[string]string, app *App) (NfsShare, error) {
+	auth, uid, gid, err := credential(params)
+	if err != nil {
+		return NfsShare{}, err
+	}
+	s := NfsShare{
+		auth:   auth,
+		ctx:    app.Context,
+		uid:    uid,
+		gid:    gid,
+		host:   params["hostname"],
+		raw:    params["raw_listing"] == "true",
+		params: params,
+
+		zipSkipErrors: params["zip_errors"] != "abort",
+		datedLayout:   datedLayout(params["dated_upload"]),
+		normalization: params["filename_normalization"],
+		dotEntries:    params["dot_entries"] == "true",
+		displayRoot:   strings.Trim(params["display_root"], "/"),
+		sizeOnDisk:    params["size_display"] == "on_disk",
+		mkdirParents:  params["mkdir_parents"] == "true",
+		verifyWrites:  params["verify_writes"] == "true",
+		showTypes:     showTypes(params["show_types"]),
+		windowsPaths:  params["windows_paths"] == "true",
+		prefetch:      intParam(params["prefetch"], 0),
+		listPrefetch:  params["list_prefetch"] == "true",
+		listCache:     durationParam(params["list_cache"], time.Second, 0),
+		exclude:       excludePatterns(params["exclude"]),
+		unstatEntries: params["unstat_entries"],
+		onCollision:   params["on_collision"],
+		rmSymlinks:    params["rm_symlinks"],
+		listOnly:      params["list_only"],
+		rootRefresh:   params["root_refresh"] == "true",
+		noList:        params["no_list"] == "true",
+		debug:         params["debug"] == "true",
+		mvFallback:    params["rename_fallback"],
+		noRecursive:   params["no_recursive_delete"] == "true",
+		maxEntries:    intParam(params["max_entries"], 0),
+		dirMode:       modeParam(params["dir_mode"], 0775),
+		slowThreshold: durationParam(params["slow_op"], time.Millisecond, 0),
+		coalesce:      durationParam(params["coalesce_writes"], time.Millisecond, 0),
+
+		metadataTimeout: durationParam(params["metadata_timeout"], time.Second, DEFAULT_METADATA_TIMEOUT),
+		dataTimeout:     durationParam(params["data_timeout"], time.Second, DEFAULT_DATA_TIMEOUT),
+		jukeboxDelay:    durationParam(params["jukebox_delay"], time.Second, DEFAULT_JUKEBOX_DELAY),
+		jukeboxMax:      durationParam(params["jukebox_max"], time.Second, DEFAULT_JUKEBOX_MAX),
+		stallTimeout:    durationParam(params["stall_timeout"], time.Second, 0),
+	}
+	if n, err := strconv.Atoi(params["chown_uid"]); err == nil {
+		s.chown.UID = nfs.SetUID{SetIt: true, UID: uint32(n)}
+	}
+	if n, err := strconv.Atoi(params["chown_gid"]); err == nil {
+		s.chown.GID = nfs.SetUID{SetIt: true, UID: uint32(n)}
+	}
+	if s.ftypes, err = ftypes(params["type_labels"]); err != nil {
+		return NfsShare{}, err
+	}
+	if params["filename_charset"] != "" {
+		enc, err := ianaindex.IANA.Encoding(params["filename_charset"])
+		if err != nil || enc == nil {
+			return NfsShare{}, NewError("Filename charset: unknown charset", 400)
+		}
+		s.charset = enc
+	}
+	return s, nil
+}
+
+// connections are pooled per set of params, the credential being part of it
+func (this NfsShare) poolFor(params map[string]string) *nfsPool {
+	pool, ok := NfsCache.Get(params).(*nfsPool)
+	if ok == false {
+		pool = &nfsPool{host: params["hostname"], target: params["target"]}
+		pool.coalescer = newCoalescer(func(path string, data []byte) error {
+			share, err := this.acquire(pool, params)
+			if err != nil {
+				return err
+			}
+			defer share.Close()
+			share.dataOp()
+			// flushes happen once the request that made the pool is long
+			// gone, its context along with it
+			share.ctx = context.Background()
+			return share.save(path, bytes.NewReader(data))
+		})
+		NfsCache.Set(params, pool)
+	}
+	return pool
+}
+
+func (this NfsShare) acquire(pool *nfsPool, params map[string]string) (NfsShare, error) {
+	if this.conn = pool.get(); this.conn == nil {
+		time.Sleep(pool.remountDelay(
+			durationParam(params["remount_backoff"], time.Millisecond, DEFAULT_REMOUNT_BASE),
+			durationParam(params["remount_jitter"], time.Millisecond, DEFAULT_REMOUNT_JITTER),
+		))
+		conn, err := this.dial(params)
+		if err != nil {
+			return this, err
+		}
+		this.conn = conn
+		pool.add(conn)
+	}
+	this.pool = pool
+	this.mount = this.conn.mount
+	this.v = this.conn.v
+	this.once = new(sync.Once)
+	this.busy = new(sync.Mutex)
+	return this, nil
+}
+
+// connections go through go-nfs-client which dials plain TCP on its own,
+// going to the portmapper first to find the port of each service. Its RPC
+// client can't be built on top of a connection we'd give it, which rules
+// out wrapping it in TLS from here: RPC over TLS (RFC9289) or a stunnel
+// endpoint needs the server side to be reached through a local tunnel that
+// also fronts the portmapper
+func (this NfsShare) dial(params map[string]string) (*nfsConn, error) {
+	var (
+		mount *nfs.Mount
+		v     *nfs.Target
+		fh    []byte
+		err   error
+	)
+	if params["public_fh"] != "" {
+		// servers with mountd disabled can still be reached with a file
+		// handle known ahead of time, or with the WebNFS public handle
+		if fh, err = publicFh(params["public_fh"]); err != nil {
+			return nil, err
+		}
+	} else {
+		m, err := mountdParams(params)
+		if err != nil {
+			return nil, err
+		}
+		timeout := durationParam(params["mount_timeout"], time.Second, 0)
+		err = inTime(timeout, func() (err error) {
+			mount, fh, err = this.mountPhase(m, params["target"])
+			return err
+		}, func() {
+			if mount != nil {
+				mount.Close()
+			}
+		})
+		if err == ErrTimeout {
+			return nil, NewError(fmt.Sprintf("Mount timeout: mountd didn't answer within %s", timeout), 504)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	timeout := durationParam(params["nfs_timeout"], time.Second, 0)
+	err = inTime(timeout, func() (err error) {
+		v, err = newTarget(params["hostname"], this.auth, fh, params["target"])
+		return err
+	}, func() {
+		v.Close()
+	})
+	if err != nil && mount != nil {
+		mount.Close()
+	}
+	if err == ErrTimeout {
+		return nil, NewError(fmt.Sprintf("NFS timeout: the NFS server didn't answer within %s", timeout), 504)
+	} else if err != nil {
+		Log.Debug("plg_backend_nfs::init dial nfs error '%s'", err.Error())
+		return nil, NewError("Hostname: can't reach the server", 502)
+	}
+	conn := &nfsConn{
+		mount:    mount,
+		v:        v,
+		idle:     durationParam(params["idle_timeout"], time.Second, DEFAULT_IDLE_TIMEOUT),
+		inUse:    true,
+		created:  time.Now(),
+		lastUsed: time.Now(),
+	}
+	this.v = v
+	root, err := this.getattr(this.rootFh())
 	if err != nil {
+		conn.close()
+		if os.IsPermission(err) || isNfsError(err, nfs.NFS3ErrAcces) {
+			return nil, NewError("Mount Path: permission denied on the root of the export", 403)
+		}
 		return nil, err
+	} else if root.Type != nfs.NF3Dir {
+		// would otherwise only show up later on as a confusing error from Ls
+		conn.close()
+		return nil, NewError("Mount Path: export is not a directory", 400)
+	}
+	conn.rootFsid = root.FSID
+	conn.root = this.rootFh()
+	if conn.pathconf, err = this.pathconf(this.rootFh()); err != nil {
+		// optional for the server, we get by without
+		Log.Debug("plg_backend_nfs::init pathconf error '%s'", err.Error())
 	}
-	return NfsShare{mount, v, auth, app.Context, uid, gid}, nil
+	return conn, nil
 }
 
+func credential(params map[string]string) (rpc.Auth, uint32, uint32, error) {
+	uid := getUid(params["uid"])
+	gid := getGid(params["gid"])
+	if params["auth_flavor"] == "anonymous" {
+		// the server maps AUTH_NULL onto its anonymous user, what we keep
+		// around is who we expect to end up owning files as
+		return rpc.AuthNull, anonId(params["anon_uid"]), anonId(params["anon_gid"]), nil
+	} else if uid == 0 {
+		// a root squashing server would silently map us onto nobody
+		switch params["squash_root"] {
+		case "refuse":
+			return rpc.Auth{}, 0, 0, NewError("uid: root isn't allowed on this share", 403)
+		case "remap":
+			uid = DEFAULT_UID
+			if gid == 0 {
+				gid = DEFAULT_GID
+			}
+		}
+	}
+	return newAuthUnix(params["machine_name"], uid, gid, params["auth_stamp"]).Auth(), uid, gid, nil
+}
+
+// RefreshCredential reads the uid and gid hints again, bypassing the cache of
+// /etc/passwd, and returns the share with the new credential. Our own RPCs
+// pick it up straight away but calls going through go-nfs-client are signed
+// with the credential the target was mounted with, those get it with the
+// next connection as the current ones are retired once they're done with
+func (this NfsShare) RefreshCredential() (NfsShare, error) {
+	cacheForEtc.Del(map[string]string{"username": this.params["uid"]})
+	cacheForEtc.Del(map[string]string{"username": this.params["gid"]})
+	auth, uid, gid, err := credential(this.params)
+	if err != nil {
+		return this, err
+	}
+	this.auth = auth
+	this.uid = uid
+	this.gid = gid
+	this.pool.evict()
+	return this, nil
+}
+
+// the public file handle of NFSv3 WebNFS is the zero length one, as of
+// RFC2054 in: https://www.rfc-editor.org/rfc/rfc2054#section-5
+func publicFh(value string) ([]byte, error) {
+	if value == "webnfs" {
+		return []byte{}, nil
+	}
+	fh, err := hex.DecodeString(value)
+	if err != nil || len(fh) > NFS3_FHSIZE {
+		return nil, NewError("Public file handle: expected 'webnfs' or a file handle in hex", 400)
+	}
+	return fh, nil
+}
+
+// the stamp is random unless configured, which makes it hard for admins
+// to correlate what shows up in the server logs with a given share
+func newAuthUnix(machineName string, uid uint32, gid uint32, stamp string) *rpc.AuthUnix {
+	a := rpc.NewAuthUnix(machineName, uid, gid)
+	if n, err := strconv.ParseUint(stamp, 10, 32); err == nil {
+		a.Stamp = uint32(n)
+	}
+	return a
+}
+
+// make sure a misconfigured login form is reported against the field that
+// needs fixing instead of a generic error surfacing later on
+func mountError(err error) error {
+	switch err.Error() {
+	case "MNT3ERR_NOENT", "MNT3ERR_NOTDIR":
+		return NewError("Mount Path: export not found", 404)
+	case "MNT3ERR_ACCES", "MNT3ERR_PERM":
+		return NewError("Mount Path: permission denied", 403)
+	}
+	return err
+}
+
+// the advanced fields are split in groups so the form stays usable. What
+// only makes sense while troubleshooting a server isn't part of it:
+// raw_listing, debug, mount_prog and mount_version are still honoured when
+// set in the connection params
 func (this NfsShare) LoginForm() Form {
 	return Form{
 		Elmnts: []FormElement{
@@ -82,7 +435,7 @@ func (this NfsShare) LoginForm() Form {
 				Name:        "advanced",
 				Type:        "enable",
 				Placeholder: "Advanced",
-				Target:      []string{"nfs_uid", "nfs_gid", "nfs_machinename", "nfs_chroot"},
+				Target:      []string{"nfs_uid", "nfs_gid", "nfs_machinename", "nfs_auth_stamp", "nfs_auth_flavor", "nfs_anon_uid", "nfs_anon_gid", "nfs_squash_root", "nfs_chroot", "nfs_targets", "nfs_public_fh", "nfs_mount_timeout", "nfs_nfs_timeout", "nfs_idle_timeout", "nfs_metadata_timeout", "nfs_data_timeout", "nfs_remount_backoff", "nfs_remount_jitter", "nfs_jukebox_delay", "nfs_jukebox_max", "nfs_slow_op"},
 			},
 			FormElement{
 				Id:          "nfs_uid",
@@ -102,133 +455,682 @@ func (this NfsShare) LoginForm() Form {
 				Type:        "text",
 				Placeholder: "machine name",
 			},
+			FormElement{
+				Id:          "nfs_auth_stamp",
+				Name:        "auth_stamp",
+				Type:        "number",
+				Placeholder: "AUTH_SYS stamp (random by default)",
+			},
+			FormElement{
+				Id:          "nfs_auth_flavor",
+				Name:        "auth_flavor",
+				Type:        "select",
+				Opts:        []string{"", "anonymous"},
+				Description: "anonymous sends AUTH_NULL for servers squashing everyone onto the anonymous user",
+			},
+			FormElement{
+				Id:          "nfs_anon_uid",
+				Name:        "anon_uid",
+				Type:        "number",
+				Placeholder: "anonymous uid (65534 by default)",
+			},
+			FormElement{
+				Id:          "nfs_anon_gid",
+				Name:        "anon_gid",
+				Type:        "number",
+				Placeholder: "anonymous gid (65534 by default)",
+			},
+			FormElement{
+				Id:          "nfs_squash_root",
+				Name:        "squash_root",
+				Type:        "select",
+				Opts:        []string{"", "refuse", "remap"},
+				Description: "What to do when the uid is 0: refuse the connection or remap it onto the default uid",
+			},
 			FormElement{
 				Id:          "nfs_chroot",
 				Name:        "path",
 				Type:        "text",
 				Placeholder: "chroot",
 			},
+			FormElement{
+				Id:          "nfs_targets",
+				Name:        "targets",
+				Type:        "text",
+				Placeholder: "several exports shown as folders, eg: /srv/home,/srv/projects",
+			},
+			FormElement{
+				Id:          "nfs_public_fh",
+				Name:        "public_fh",
+				Type:        "text",
+				Placeholder: "skip MOUNT: 'webnfs' or the root file handle in hex",
+			},
+			FormElement{
+				Id:          "nfs_mount_timeout",
+				Name:        "mount_timeout",
+				Type:        "number",
+				Placeholder: "seconds to get the root handle from mountd",
+			},
+			FormElement{
+				Id:          "nfs_nfs_timeout",
+				Name:        "nfs_timeout",
+				Type:        "number",
+				Placeholder: "seconds to connect to the NFS server once mounted",
+			},
+			FormElement{
+				Id:          "nfs_idle_timeout",
+				Name:        "idle_timeout",
+				Type:        "number",
+				Placeholder: "idle timeout (in seconds)",
+			},
+			FormElement{
+				Id:          "nfs_metadata_timeout",
+				Name:        "metadata_timeout",
+				Type:        "number",
+				Placeholder: "timeout for lookup, getattr, readdir (in seconds)",
+			},
+			FormElement{
+				Id:          "nfs_data_timeout",
+				Name:        "data_timeout",
+				Type:        "number",
+				Placeholder: "timeout for read and write (in seconds)",
+			},
+			FormElement{
+				Id:          "nfs_remount_backoff",
+				Name:        "remount_backoff",
+				Type:        "number",
+				Placeholder: "remount backoff (in ms)",
+			},
+			FormElement{
+				Id:          "nfs_remount_jitter",
+				Name:        "remount_jitter",
+				Type:        "number",
+				Placeholder: "remount jitter (in ms)",
+			},
+			FormElement{
+				Id:          "nfs_jukebox_delay",
+				Name:        "jukebox_delay",
+				Type:        "number",
+				Placeholder: "delay before retrying when the server isn't ready yet (in seconds)",
+			},
+			FormElement{
+				Id:          "nfs_jukebox_max",
+				Name:        "jukebox_max",
+				Type:        "number",
+				Placeholder: "how long to wait for the server to get ready (in seconds)",
+			},
+			FormElement{
+				Id:          "nfs_slow_op",
+				Name:        "slow_op",
+				Type:        "number",
+				Placeholder: "log operations slower than this many milliseconds",
+			},
+			FormElement{
+				Name:        "advanced_listing",
+				Type:        "enable",
+				Placeholder: "Listing options",
+				Target:      []string{"nfs_display_root", "nfs_dot_entries", "nfs_show_types", "nfs_type_labels", "nfs_exclude", "nfs_unstat_entries", "nfs_max_entries", "nfs_list_only", "nfs_no_list", "nfs_size_display", "nfs_filename_charset", "nfs_filename_normalization", "nfs_windows_paths", "nfs_list_cache", "nfs_list_prefetch", "nfs_warm_up", "nfs_root_refresh"},
+			},
+			FormElement{
+				Id:          "nfs_display_root",
+				Name:        "display_root",
+				Type:        "text",
+				Placeholder: "name shown for the root of the export",
+			},
+			FormElement{
+				Id:          "nfs_dot_entries",
+				Name:        "dot_entries",
+				Type:        "boolean",
+				Description: "Include the '.' and '..' entries in listings",
+			},
+			FormElement{
+				Id:          "nfs_show_types",
+				Name:        "show_types",
+				Type:        "text",
+				Placeholder: "other types to list, eg: symlink,fifo,socket,block,char",
+			},
+			FormElement{
+				Id:          "nfs_type_labels",
+				Name:        "type_labels",
+				Type:        "text",
+				Placeholder: "type labels, eg: symlink=link,fifo=pipe",
+			},
+			FormElement{
+				Id:          "nfs_exclude",
+				Name:        "exclude",
+				Type:        "text",
+				Placeholder: "hide from listings, eg: .snapshot, ~$*",
+			},
+			FormElement{
+				Id:          "nfs_unstat_entries",
+				Name:        "unstat_entries",
+				Type:        "select",
+				Opts:        []string{"skip", "show"},
+				Description: "Entries we aren't allowed to get the attributes of are either skipped or shown with their name only",
+			},
+			FormElement{
+				Id:          "nfs_max_entries",
+				Name:        "max_entries",
+				Type:        "number",
+				Placeholder: "stop listing a folder past this many entries",
+			},
+			FormElement{
+				Id:          "nfs_list_only",
+				Name:        "list_only",
+				Type:        "select",
+				Opts:        []string{"", "directories", "files"},
+				Description: "Only show folders or only show files in listings",
+			},
+			FormElement{
+				Id:          "nfs_no_list",
+				Name:        "no_list",
+				Type:        "boolean",
+				Description: "Refuse to list folders, files can only be reached from a path known ahead of time like a shared link",
+			},
+			FormElement{
+				Id:          "nfs_size_display",
+				Name:        "size_display",
+				Type:        "select",
+				Opts:        []string{"apparent", "on_disk"},
+				Description: "on_disk shows the space files take on the server which differs for sparse or compressed files",
+			},
+			FormElement{
+				Id:          "nfs_filename_charset",
+				Name:        "filename_charset",
+				Type:        "text",
+				Placeholder: "filename charset",
+				Description: "Charset used by the server for filenames, eg: ISO-8859-1 or Shift_JIS. Defaults to UTF-8",
+			},
+			FormElement{
+				Id:          "nfs_filename_normalization",
+				Name:        "filename_normalization",
+				Type:        "select",
+				Opts:        []string{"", "nfc", "nfd"},
+				Description: "Unicode normalization of filenames: nfc for most linux servers, nfd for macOS ones",
+			},
+			FormElement{
+				Id:          "nfs_windows_paths",
+				Name:        "windows_paths",
+				Type:        "boolean",
+				Description: "Treat backslashes in paths as separators",
+			},
+			FormElement{
+				Id:          "nfs_list_cache",
+				Name:        "list_cache",
+				Type:        "number",
+				Placeholder: "seconds to keep folder listings around, default: off",
+			},
+			FormElement{
+				Id:          "nfs_list_prefetch",
+				Name:        "list_prefetch",
+				Type:        "boolean",
+				Description: "List subfolders ahead of time so navigating into them is instant",
+			},
+			FormElement{
+				Id:          "nfs_warm_up",
+				Name:        "warm_up",
+				Type:        "boolean",
+				Description: "List the root of the share while logging in so the first browse is instant",
+			},
+			FormElement{
+				Id:          "nfs_root_refresh",
+				Name:        "root_refresh",
+				Type:        "boolean",
+				Description: "Mount again as soon as the root of the export goes stale, for servers handing out a new root handle after a reboot",
+			},
+			FormElement{
+				Name:        "advanced_files",
+				Type:        "enable",
+				Placeholder: "File options",
+				Target:      []string{"nfs_dated_upload", "nfs_on_collision", "nfs_mkdir_parents", "nfs_verify_writes", "nfs_coalesce_writes", "nfs_stall_timeout", "nfs_prefetch", "nfs_chown_uid", "nfs_chown_gid", "nfs_dir_mode", "nfs_zip_errors", "nfs_rm_symlinks", "nfs_rename_fallback", "nfs_no_recursive_delete"},
+			},
+			FormElement{
+				Id:          "nfs_dated_upload",
+				Name:        "dated_upload",
+				Type:        "text",
+				Placeholder: "YYYY/MM/DD",
+				Description: "Sort uploads into date based subdirectories following this pattern",
+			},
+			FormElement{
+				Id:          "nfs_on_collision",
+				Name:        "on_collision",
+				Type:        "select",
+				Opts:        []string{"overwrite", "skip", "rename"},
+				Description: "When uploading over an existing file: overwrite it, skip the upload or save it as 'file (1).txt'",
+			},
+			FormElement{
+				Id:          "nfs_mkdir_parents",
+				Name:        "mkdir_parents",
+				Type:        "boolean",
+				Description: "Create missing folders when uploading to a nested path",
+			},
+			FormElement{
+				Id:          "nfs_verify_writes",
+				Name:        "verify_writes",
+				Type:        "boolean",
+				Description: "Read uploaded files back to make sure they were stored as sent. Uploads take twice as long",
+			},
+			FormElement{
+				Id:          "nfs_coalesce_writes",
+				Name:        "coalesce_writes",
+				Type:        "number",
+				Placeholder: "coalesce small writes (debounce in ms)",
+			},
+			FormElement{
+				Id:          "nfs_stall_timeout",
+				Name:        "stall_timeout",
+				Type:        "number",
+				Placeholder: "abort transfers making no progress for (in seconds)",
+			},
+			FormElement{
+				Id:          "nfs_prefetch",
+				Name:        "prefetch",
+				Type:        "number",
+				Placeholder: "READs kept in flight when downloading, each on its own connection",
+			},
+			FormElement{
+				Id:          "nfs_chown_uid",
+				Name:        "chown_uid",
+				Type:        "number",
+				Placeholder: "owner uid of uploaded files",
+			},
+			FormElement{
+				Id:          "nfs_chown_gid",
+				Name:        "chown_gid",
+				Type:        "number",
+				Placeholder: "owner gid of uploaded files",
+			},
+			FormElement{
+				Id:          "nfs_dir_mode",
+				Name:        "dir_mode",
+				Type:        "text",
+				Placeholder: "mode of new folders, default: 0775. eg: 2775 to inherit the group",
+			},
+			FormElement{
+				Id:          "nfs_zip_errors",
+				Name:        "zip_errors",
+				Type:        "select",
+				Opts:        []string{"skip", "abort"},
+				Description: "When downloading a folder, skip the files we can't read or abort the whole archive",
+			},
+			FormElement{
+				Id:          "nfs_rm_symlinks",
+				Name:        "rm_symlinks",
+				Type:        "select",
+				Opts:        []string{"link", "target"},
+				Description: "Deleting a symlink removes the link only, or what it points to within the share as well",
+			},
+			FormElement{
+				Id:          "nfs_rename_fallback",
+				Name:        "rename_fallback",
+				Type:        "select",
+				Opts:        []string{"", "copy"},
+				Description: "For servers that don't support RENAME, move files by copying them and deleting the original",
+			},
+			FormElement{
+				Id:          "nfs_no_recursive_delete",
+				Name:        "no_recursive_delete",
+				Type:        "boolean",
+				Description: "Only delete folders that are already empty, their content has to be deleted first",
+			},
 		},
 	}
 }
 
 func (this NfsShare) Meta(path string) Metadata {
-	f, _, err := this.v.Lookup(strings.TrimSuffix(path, "/"))
+	this.metadataOp()
+	_, fh, err := this.resolve(this.nfsPath(path))
 	if err != nil {
 		return Metadata{}
-	} else if f == nil {
-		return Metadata{}
 	}
-	fattr, ok := f.(*nfs.Fattr)
-	if ok == false {
+	access, err := this.access(fh, ACCESS3_READ|ACCESS3_LOOKUP|ACCESS3_MODIFY|ACCESS3_EXTEND|ACCESS3_DELETE)
+	if err != nil {
 		return Metadata{}
 	}
+	return capabilities(access)
+}
 
-	if fattr == nil { // happen on the root of the share
-		return Metadata{}
-	} else if fattr.UID == this.uid || fattr.GID == this.gid {
-		return Metadata{}
-	}
+// capabilities maps what the server answered to ACCESS on a directory onto
+// what the user can do within that directory
+func capabilities(access uint32) Metadata {
+	canCreate := access&ACCESS3_EXTEND != 0
+	canDelete := access&ACCESS3_DELETE != 0
 	return Metadata{
-		CanSee:             NewBool(false),
-		CanCreateFile:      NewBool(false),
-		CanCreateDirectory: NewBool(false),
-		CanRename:          NewBool(false),
-		CanMove:            NewBool(false),
-		CanUpload:          NewBool(false),
-		CanDelete:          NewBool(false),
-		CanShare:           NewBool(false),
+		CanSee:             NewBool(access&ACCESS3_READ != 0),
+		CanCreateFile:      NewBool(canCreate),
+		CanCreateDirectory: NewBool(canCreate),
+		CanUpload:          NewBool(canCreate),
+		CanRename:          NewBool(canCreate && canDelete),
+		CanMove:            NewBool(canCreate && canDelete),
+		CanDelete:          NewBool(canDelete),
 	}
 }
 
-func (this NfsShare) Ls(path string) ([]os.FileInfo, error) {
+func (this NfsShare) Ls(path string) (_ []os.FileInfo, err error) {
 	defer this.Close()
-	files := make([]os.FileInfo, 0)
+	defer this.wrapError("ls", path, &err)
+	defer this.slowOp("ls", path, time.Now())
+	this.metadataOp()
+	if this.noList {
+		return nil, ErrPermissionDenied
+	}
+	files, err := this.lsCached(path)
+	return onlyKind(files, this.listOnly), err
+}
+
+// LsOnly is Ls restricted to "directories" or "files", eg: for a folder
+// picker that has no use for files
+func (this NfsShare) LsOnly(path string, kind string) (_ []os.FileInfo, err error) {
+	defer this.Close()
+	defer this.wrapError("ls", path, &err)
+	defer this.slowOp("ls", path, time.Now())
+	this.metadataOp()
+	if this.noList {
+		return nil, ErrPermissionDenied
+	}
+	files, err := this.lsCached(path)
+	return onlyKind(files, kind), err
+}
 
-	dirs, err := this.v.ReadDirPlus(path)
+func (this NfsShare) lsCached(path string) ([]os.FileInfo, error) {
+	if files, ok := this.pool.listings.get(this.pool, this.nfsPath(path)); ok {
+		return files, nil
+	}
+	files, truncated, err := this.ls(path)
 	if err != nil {
 		return files, err
+	} else if truncated {
+		Log.Warning("plg_backend_nfs::ls listing of '%s' truncated to %d entries", path, this.maxEntries)
+		return files, nil
+	}
+	if this.listCache > 0 {
+		this.pool.listings.set(this.pool, this.nfsPath(path), files, this.listCache)
+	}
+	if this.listPrefetch {
+		this.prefetchLs(path, files)
+	}
+	return files, nil
+}
+
+// LsLimited is Ls telling whether the listing was cut short by the max
+// entries setting, so the UI can warn about what isn't shown
+func (this NfsShare) LsLimited(path string) (_ []os.FileInfo, truncated bool, err error) {
+	defer this.Close()
+	defer this.wrapError("ls", path, &err)
+	defer this.slowOp("ls", path, time.Now())
+	this.metadataOp()
+	if this.noList {
+		return nil, false, ErrPermissionDenied
+	}
+	files, truncated, err := this.ls(path)
+	return onlyKind(files, this.listOnly), truncated, err
+}
+
+// what's cached is the whole listing, the filter applies on the way out
+func onlyKind(files []os.FileInfo, kind string) []os.FileInfo {
+	if kind != "directories" && kind != "files" {
+		return files
 	}
+	out := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() == (kind == "directories") {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (this NfsShare) ls(path string) ([]os.FileInfo, bool, error) {
+	dirs, truncated, err := this.readDir(this.nfsPath(path), this.maxEntries)
+	if err != nil {
+		return make([]os.FileInfo, 0), false, err
+	}
+	return this.toFiles(path, dirs), truncated, nil
+}
+
+func (this NfsShare) toFiles(path string, dirs []*nfs.EntryPlus) []os.FileInfo {
+	files := make([]os.FileInfo, 0, len(dirs))
 	for _, dir := range dirs {
+		if dir.Attr.IsSet == false && dir.FileName != "." && dir.FileName != ".." {
+			// some servers leave the attributes out of READDIRPLUS, that
+			// doesn't mean the entry isn't there
+			if attr, err := this.entryAttr(this.nfsPath(path), dir); err == nil {
+				dir.Attr.Attr = *attr
+			} else {
+				// typically NFS3ERR_ACCES on something we're not allowed
+				// to look at. It doesn't take the rest of the folder down
+				Log.Debug("plg_backend_nfs::ls missing attributes for '%s' err[%s]", dir.FileName, err.Error())
+				if this.unstatEntries == "show" && this.excluded(this.decodeName(dir.FileName)) == false {
+					files = append(files, File{
+						FName: this.decodeName(dir.FileName),
+						FType: "file",
+						FPath: this.displayPath(path, dir.FileName),
+					})
+				}
+				continue
+			}
+		}
 		if dir.FileName == "." || dir.FileName == ".." {
-			continue
-		} else if dir.Attr.Attr.Type != 1 && dir.Attr.Attr.Type != 2 {
-			// don't show anything else than file and folder
+			if this.dotEntries == false {
+				continue
+			}
+			// the server doesn't always send attributes for ".." at the root
+			// of the export
+			dir.Attr.Attr.Type = nfs.NF3Dir
+		} else if this.hidden(dir) {
+			// by default, nothing else than file and folder and never what
+			// was excluded
 			continue
 		}
-		files = append(files, File{
-			FName: dir.FileName,
-			FType: func() string {
-				if dir.Attr.Attr.Type == 1 {
-					return "file"
-				}
-				return "directory"
-			}(),
-			FSize: int64(dir.Attr.Attr.Filesize),
-			FTime: int64(dir.Attr.Attr.Ctime.Seconds),
+		files = append(files, NfsFileInfo{
+			File: File{
+				FName: this.decodeName(dir.FileName),
+				FType: this.typeToFType(dir.Attr.Attr.Type),
+				FSize: this.size(&dir.Attr.Attr),
+				FTime: int64(dir.Attr.Attr.Ctime.Seconds),
+				FPath: this.displayPath(path, dir.FileName),
+			},
+			Atime: nfsTime(dir.Attr.Attr.Atime),
+			Mtime: nfsTime(dir.Attr.Attr.Mtime),
+			Ctime: nfsTime(dir.Attr.Attr.Ctime),
 		})
 	}
-	return files, nil
+	return files
 }
 
-func (this NfsShare) Cat(path string) (io.ReadCloser, error) {
-	go func() {
-		<-this.ctx.Done()
+// sparse files are read byte for byte, holes included. Skipping holes would
+// require READ_PLUS which only exists from NFSv4.2 onward while this backend
+// speaks v3 through go-nfs-client, hence we stick with plain READ
+func (this NfsShare) Cat(path string) (_ io.ReadCloser, err error) {
+	defer this.wrapError("cat", path, &err)
+	defer this.slowOp("cat", path, time.Now())
+	this.dataOp()
+	if err = this.pool.coalescer.flushNow(this.nfsPath(path)); err != nil {
 		this.Close()
-	}()
-	rc, err := this.v.OpenFile(path, 0777)
+		return nil, err
+	}
+	attr, fh, err := this.resolve(this.nfsPath(path))
+	if err == nil && attr == nil {
+		attr, err = this.getattr(fh)
+	}
 	if err != nil {
+		this.Close()
 		return nil, err
+	} else if attr.Type == nfs.NF3Reg && attr.Filesize == 0 {
+		// some servers don't like a READ on an empty file, there's nothing
+		// to ask them for anyway. The attributes come either from LOOKUP
+		// when the server sent them or from a GETATTR, never made up
+		this.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if this.prefetch > 1 {
+		r, err := this.newPrefetchReader(fh, this.prefetch)
+		if err != nil {
+			this.Close()
+			return nil, err
+		}
+		return r, nil
 	}
-	return rc, nil
+	return this.newCatReader(fh, this.nfsPath(path), attr.Filesize), nil
 }
 
-func (this NfsShare) Mkdir(path string) error {
+// IsDir tells if path is a directory with a single LOOKUP. A symlink isn't
+// followed and is reported as not being a directory
+func (this NfsShare) IsDir(path string) (_ bool, err error) {
 	defer this.Close()
-	_, err := this.v.Mkdir(this.nfsPath(path), 0775)
-	return err
+	defer this.wrapError("isdir", path, &err)
+	this.metadataOp()
+	fattr, _, err := this.resolve(this.nfsPath(path))
+	if os.IsNotExist(err) {
+		return false, ErrNotFound
+	} else if err != nil {
+		return false, err
+	}
+	if fattr == nil { // happen on the root of the share
+		return true, nil
+	}
+	return fattr.Type == nfs.NF3Dir, nil
+}
+
+// Readlink gives the raw target of a symlink, exactly as stored on the server
+func (this NfsShare) Readlink(path string) (_ string, err error) {
+	defer this.Close()
+	defer this.wrapError("readlink", path, &err)
+	this.metadataOp()
+	f, err := this.v.Open(this.nfsPath(path))
+	if err != nil {
+		return "", err
+	}
+	target, err := f.Readlink()
+	if isNfsError(err, nfs.NFS3ErrInval) {
+		return "", NewError("Not a symlink", 400)
+	} else if err != nil {
+		return "", err
+	}
+	return this.decodeName(target), nil
+}
+
+func (this NfsShare) Mkdir(path string) (err error) {
+	defer this.Close()
+	defer this.wrapError("mkdir", path, &err)
+	defer this.slowOp("mkdir", path, time.Now())
+	this.metadataOp()
+	if err = this.checkName(this.nfsPath(path)); err != nil {
+		return err
+	}
+	return this.mkdirAt(this.nfsPath(path))
+}
+
+// creates a single folder with the configured mode. Some servers drop the
+// special bits on creation, in which case we set them again afterward
+func (this NfsShare) mkdirAt(path string) error {
+	defer this.pool.listings.invalidate(path)
+	dir, name := filepath.Split(strings.TrimSuffix(path, "/"))
+	_, dirFh, err := this.resolve(dir)
+	if err != nil {
+		return err
+	}
+	mode := unixMode(this.dirMode)
+	fh, attr, err := this.mkdir(dirFh, name, mode)
+	if err != nil {
+		return err
+	} else if mode&07000 == 0 || len(fh) == 0 {
+		return nil
+	} else if attr != nil && attr.FileMode&07777 == mode {
+		return nil
+	}
+	return this.chmod(fh, this.dirMode)
+}
+
+func (this NfsShare) mkdirAll(path string) error {
+	current := ""
+	for _, chunk := range strings.Split(strings.Trim(path, "/"), "/") {
+		current += "/" + chunk
+		if _, _, err := this.v.Lookup(current); err == nil {
+			continue
+		} else if os.IsNotExist(err) == false {
+			return err
+		} else if err = this.checkName(current); err != nil {
+			return err
+		}
+		if err := this.mkdirAt(current); err != nil && os.IsExist(err) == false {
+			return err
+		}
+	}
+	return nil
 }
 
-func (this NfsShare) Rm(path string) error {
+func (this NfsShare) Rm(path string) (err error) {
 	defer this.Close()
-	if strings.HasSuffix(path, "/") {
-		return this.v.RemoveAll(this.nfsPath(path))
+	defer this.wrapError("rm", path, &err)
+	defer this.slowOp("rm", path, time.Now())
+	this.metadataOp()
+	defer this.pool.listings.invalidate(this.nfsPath(path))
+	dropped := this.pool.coalescer.drop(this.nfsPath(path))
+	attr, _, err := this.resolve(this.nfsPath(path))
+	if os.IsNotExist(err) && dropped {
+		// it never made it to the server
+		return nil
+	} else if err != nil {
+		return err
+	}
+	isLink := attr != nil && attr.Type == nfs.NF3Lnk
+	if this.noRecursive && ((attr != nil && attr.Type == nfs.NF3Dir) || (strings.HasSuffix(path, "/") && isLink == false)) {
+		return this.rmDir(this.nfsPath(path))
+	} else if isLink {
+		// with no_recursive_delete, a folder it points to has to be empty
+		return this.rmSymlink(this.nfsPath(path))
+	} else if strings.HasSuffix(path, "/") {
+		return this.removeAll(this.nfsPath(path))
 	}
-	return this.v.Remove(path)
+	return this.v.Remove(this.nfsPath(path))
 }
 
 // this wasn't implemented in the original lib and considering
 // PR aren't handled by vmware, we did come with the implementation as
 // of RFC1813 in: https://www.rfc-editor.org/rfc/rfc1813#section-3.3.14
-func (this NfsShare) Mv(from string, to string) error {
+func (this NfsShare) Mv(from string, to string) (err error) {
 	defer this.Close()
+	defer this.wrapError("mv", from+" -> "+to, &err)
+	defer this.slowOp("mv", from+" -> "+to, time.Now())
+	this.metadataOp()
+	err = this.rename(this.nfsPath(from), this.nfsPath(to))
+	if isConnLost(err) {
+		return this.renameRetry(this.nfsPath(from), this.nfsPath(to))
+	} else if isNfsError(err, nfs.NFS3ErrNotSupp) {
+		return this.renameUnsupported(this.nfsPath(from), this.nfsPath(to))
+	}
+	return err
+}
 
-	f, fName := filepath.Split(this.nfsPath(from))
-	_, fh, err := this.v.Lookup(f)
+func (this NfsShare) rename(from string, to string) error {
+	defer this.pool.listings.invalidate(from)
+	defer this.pool.listings.invalidate(to)
+	if err := this.checkName(to); err != nil {
+		return err
+	}
+	// the rename replaces whatever was pending at the destination
+	if err := this.pool.coalescer.flushNow(from); err != nil {
+		return err
+	}
+	this.pool.coalescer.drop(to)
+	f, fName := filepath.Split(from)
+	_, fh, err := this.resolve(f)
 	if err != nil {
 		return err
 	}
-	t, tName := filepath.Split(this.nfsPath(to))
-	_, th, err := this.v.Lookup(t)
+	t, tName := filepath.Split(to)
+	_, th, err := this.resolve(t)
 	if err != nil {
 		return err
 	}
 
 	type RenameArgs struct {
-		rpc.Header
 		From nfs.Diropargs3
 		To   nfs.Diropargs3
 	}
 	const RENAME3res = 14
-	res, err := this.v.Call(&RenameArgs{
-		Header: rpc.Header{
-			Rpcvers: 2,
-			Prog:    nfs.Nfs3Prog,
-			Vers:    nfs.Nfs3Vers,
-			Proc:    RENAME3res,
-			Cred:    this.auth,
-			Verf:    rpc.AuthNull,
-		},
+	_, err = this.call(RENAME3res, &RenameArgs{
 		From: nfs.Diropargs3{
 			FH:       fh,
 			Filename: fName,
@@ -238,38 +1140,209 @@ func (this NfsShare) Mv(from string, to string) error {
 			Filename: tName,
 		},
 	})
-	if err != nil {
+	return err
+}
+
+func (this NfsShare) Touch(path string) error {
+	return this.Save(path, strings.NewReader(""))
+}
+
+// CreateExclusive creates an empty file only if there's nothing there
+// already. When several clients race for it, exactly one of them wins and
+// the others get ErrConflict, which makes it suitable for lock files
+func (this NfsShare) CreateExclusive(path string) (err error) {
+	defer this.Close()
+	defer this.wrapError("create", path, &err)
+	this.metadataOp()
+
+	if err = this.checkName(this.nfsPath(path)); err != nil {
 		return err
 	}
-	status, err := xdr.ReadUint32(res)
+	dir, name := filepath.Split(this.nfsPath(path))
+	_, dirFh, err := this.resolve(dir)
 	if err != nil {
 		return err
 	}
-	return nfs.NFS3Error(status)
+	var verf [8]byte
+	if _, err = rand.Read(verf[:]); err != nil {
+		return err
+	}
+	defer this.pool.listings.invalidate(this.nfsPath(path))
+	share := this
+	fh, err := share.createExclusive(dirFh, name, binary.BigEndian.Uint64(verf[:]))
+	if isConnLost(err) {
+		// the verifier is what makes this safe: a server that did create
+		// the file the first time around says so again when it's the same
+		if share, err = this.reconnect(); err != nil {
+			return err
+		}
+		defer share.Close()
+		fh, err = share.createExclusive(dirFh, name, binary.BigEndian.Uint64(verf[:]))
+	}
+	if os.IsExist(err) {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	// the server stores the verifier in the attributes of the file, which
+	// leaves it with a meaningless mode until it's set for real
+	mode := os.FileMode(0644)
+	return share.setattr(fh, (&AttrChanges{Mode: &mode}).sattr())
 }
 
-func (this NfsShare) Touch(path string) error {
-	return this.Save(path, strings.NewReader(""))
+func (this NfsShare) Save(path string, file io.Reader) (err error) {
+	defer this.Close()
+	defer this.wrapError("save", path, &err)
+	defer this.slowOp("save", path, time.Now())
+	this.dataOp()
+	if err = this.checkName(this.nfsPath(path)); err != nil {
+		return err
+	}
+	if this.mkdirParents {
+		// off by default, a typo in the path would otherwise go unnoticed
+		if err = this.mkdirAll(filepath.Dir(this.nfsPath(path))); err != nil {
+			return err
+		}
+	}
+	_, err = this.saveFile(path, file)
+	return err
 }
 
-func (this NfsShare) Save(path string, file io.Reader) error {
-	defer this.Close()
-	w, err := this.v.OpenFile(path, 0644)
+// saveFile gives the path the upload landed at, empty when it was skipped
+func (this NfsShare) saveFile(path string, file io.Reader) (string, error) {
+	file = newStallReader(file, this.stallTimeout)
+	p := this.nfsPath(path)
+	if this.datedLayout != "" {
+		var err error
+		if p, err = this.datedPath(p); err != nil {
+			return "", err
+		}
+	}
+	p, err := this.collision(p)
 	if err != nil {
+		return "", err
+	} else if p == "" {
+		Log.Debug("plg_backend_nfs::save skipped existing '%s'", path)
+		return "", nil
+	} else if this.coalesce > 0 {
+		return p, this.saveCoalesced(p, file)
+	}
+	return p, this.save(p, file)
+}
+
+func (this NfsShare) save(path string, file io.Reader) error {
+	defer this.pool.listings.invalidate(path)
+	w, err := this.openWriter(path, 0644)
+	if err != nil {
+		return err
+	} else if err = this.truncate(w); err != nil {
 		return err
 	}
-	_, err = io.Copy(w, file)
-	w.Close()
-	return err
+	h := sha256.New()
+	if this.verifyWrites {
+		file = io.TeeReader(file, h)
+	}
+	buf := this.pool.buffers.get(int(w.wsize))
+	defer this.pool.buffers.put(buf)
+	if _, err = io.CopyBuffer(w, newCtxReader(this.ctx, newSourceReader(file)), buf); err != nil {
+		// no point committing an incomplete file, and after a timeout the
+		// connection has nothing more to give but the late reply
+		w.release()
+		if ctxErr := this.ctx.Err(); ctxErr != nil {
+			// whatever made it to the server is a truncated copy nobody
+			// will come back to finish. A file that was already there is
+			// left alone, it's not ours to remove
+			if w.created {
+				if rmErr := this.v.Remove(path); rmErr != nil {
+					Log.Warning("plg_backend_nfs::save cleanup '%s' err[%s]", path, rmErr.Error())
+				}
+			}
+			return ctxErr
+		}
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if this.verifyWrites {
+		if err = this.verify(path, h.Sum(nil)); err != nil {
+			if w.created {
+				this.v.Remove(path)
+			}
+			return err
+		}
+	}
+	chown := this.chown
+	if chown.UID.SetIt && this.uid != 0 && this.caps().ChownRestricted {
+		// only root can give a file away on those servers, the group can
+		// still be changed to one we're a member of
+		Log.Debug("plg_backend_nfs::chown uid change restricted on the server")
+		chown.UID = nfs.SetUID{}
+	}
+	if chown.UID.SetIt || chown.GID.SetIt {
+		// a failing chown shouldn't make us lose the upload
+		if err = this.setattr(w.fh, chown); err != nil {
+			Log.Warning("plg_backend_nfs::chown '%s' err[%s]", path, err.Error())
+		}
+	}
+	return nil
+}
+
+// a listing of a huge folder and a quick stat shouldn't share the same
+// timeout. The deadline applies to every RPC made from there on
+func (this NfsShare) metadataOp() {
+	this.v.SetTimeout(this.metadataTimeout)
 }
 
+func (this NfsShare) dataOp() {
+	this.v.SetTimeout(this.dataTimeout)
+}
+
+// connections are pooled, Close only gives back the one we were using and
+// the pool takes care of closing it once it's been idle for long enough
 func (this NfsShare) Close() {
-	this.v.Close()
-	this.mount.Close()
+	this.busy.Lock()
+	defer this.busy.Unlock()
+	this.once.Do(this.conn.release)
 }
 
+// purely cosmetic, the path of every operation stays relative to the root
+// of the export
+func (this NfsShare) displayPath(path string, name string) string {
+	if this.displayRoot == "" {
+		return ""
+	}
+	return "/" + this.displayRoot + "/" + strings.TrimPrefix(path, "/") + this.decodeName(name)
+}
+
+// only the trailing separator filestash puts on folders is removed. Names
+// ending with a dot or a space like "report." or "data " are valid on NFS and
+// must reach the server byte for byte, so no trimming of any other kind
+// should ever happen here.
+// Backslashes are only turned into separators when asked for, on a unix
+// server they're a valid part of a filename
 func (this NfsShare) nfsPath(path string) string {
-	return strings.TrimSuffix(path, "/")
+	if this.windowsPaths {
+		path = strings.ReplaceAll(path, "\\", "/")
+	}
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return this.encodeName(strings.TrimSuffix(path, "/"))
+}
+
+func durationParam(value string, unit time.Duration, defaultValue time.Duration) time.Duration {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return time.Duration(n) * unit
+	}
+	return defaultValue
+}
+
+func intParam(value string, defaultValue int) int {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	return defaultValue
 }
 
 func getUid(hint string) uint32 {
@@ -293,20 +1366,67 @@ func getGid(hint string) uint32 {
 	return DEFAULT_GID
 }
 
+func anonId(hint string) uint32 {
+	if id, err := strconv.Atoi(hint); err == nil {
+		return uint32(id)
+	}
+	return DEFAULT_ANON_ID
+}
+
 var cacheForEtc AppCache
 
+var ETC_PASSWD = "/etc/passwd"
+
+const (
+	ETC_PASSWD_MAX_SIZE  = 4 * 1024 * 1024
+	ETC_PASSWD_MAX_LINES = 50000
+	ETC_PASSWD_TIMEOUT   = 2 * time.Second
+)
+
 func extractFromEtcPasswd(username string) (uint32, uint32, error) {
 	if v := cacheForEtc.Get(map[string]string{"username": username}); v != nil {
 		inCache := v.([]int)
 		return uint32(inCache[0]), uint32(inCache[1]), nil
 	}
-	f, err := os.OpenFile("/etc/passwd", os.O_RDONLY, os.ModePerm)
-	if err != nil {
+	// a fifo or a pathologically large file sitting at that path would
+	// otherwise block the request indefinitely
+	if fi, err := os.Stat(ETC_PASSWD); err != nil {
 		return DEFAULT_UID, DEFAULT_GID, err
+	} else if fi.Mode().IsRegular() == false || fi.Size() > ETC_PASSWD_MAX_SIZE {
+		Log.Warning("plg_backend_nfs::etc_passwd '%s' isn't a reasonable passwd file", ETC_PASSWD)
+		return DEFAULT_UID, DEFAULT_GID, ErrNotValid
+	}
+	type result struct {
+		uid int
+		gid int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		u, g, err := readEtcPasswd(username)
+		done <- result{u, g, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return DEFAULT_UID, DEFAULT_GID, r.err
+		}
+		cacheForEtc.Set(map[string]string{"username": username}, []int{r.uid, r.gid})
+		return uint32(r.uid), uint32(r.gid), nil
+	case <-time.After(ETC_PASSWD_TIMEOUT):
+		Log.Warning("plg_backend_nfs::etc_passwd timeout reading '%s'", ETC_PASSWD)
+		return DEFAULT_UID, DEFAULT_GID, ErrTimeout
+	}
+}
+
+func readEtcPasswd(username string) (int, int, error) {
+	f, err := os.OpenFile(ETC_PASSWD, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		return 0, 0, err
 	}
 	defer f.Close()
-	lines := bufio.NewReader(f)
-	for {
+	lines := bufio.NewReader(io.LimitReader(f, ETC_PASSWD_MAX_SIZE))
+	for i := 0; i < ETC_PASSWD_MAX_LINES; i++ {
 		line, _, err := lines.ReadLine()
 		if err != nil {
 			break
@@ -323,9 +1443,8 @@ func extractFromEtcPasswd(username string) (uint32, uint32, error) {
 			if err != nil {
 				continue
 			}
-			cacheForEtc.Set(map[string]string{"username": username}, []int{u, g})
-			return uint32(u), uint32(g), nil
+			return u, g, nil
 		}
 	}
-	return DEFAULT_UID, DEFAULT_GID, ErrNotFound
+	return 0, 0, ErrNotFound
 }
@@ -0,0 +1,596 @@
+package plg_backend_nfs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// the server takes 2s for some calls, a 1s budget isn't enough while a 5s
+// one is
+func slowServer(t *testing.T, procs ...uint32) *fakeServer {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	srv.setHook(func(c *fakeCall) uint32 {
+		for _, proc := range procs {
+			if c.Prog == 100003 && c.Proc == proc {
+				time.Sleep(2 * time.Second)
+			}
+		}
+		return 0
+	})
+	return srv
+}
+
+func TestMetadataTimeout(t *testing.T) {
+	srv := slowServer(t, NFSPROC3_READDIRPLUS)
+	s := srv.share(t, map[string]string{"metadata_timeout": "1", "data_timeout": "5"})
+	start := time.Now()
+	if _, err := s.Ls("/"); err == nil {
+		t.Fatalf("expected ls to time out")
+	} else if time.Since(start) > 1900*time.Millisecond {
+		t.Fatalf("expected the metadata deadline, took %s", time.Since(start))
+	}
+
+	srv = slowServer(t, NFSPROC3_READDIRPLUS)
+	s = srv.share(t, map[string]string{"metadata_timeout": "5", "data_timeout": "1"})
+	if _, err := s.Ls("/"); err != nil {
+		t.Fatalf("expected ls to go through, got %v", err)
+	}
+}
+
+func TestDataTimeout(t *testing.T) {
+	srv := slowServer(t, NFSPROC3_WRITE)
+	s := srv.share(t, map[string]string{"metadata_timeout": "5", "data_timeout": "1"})
+	start := time.Now()
+	if err := s.Save("/upload.txt", strings.NewReader("content")); err == nil {
+		t.Fatalf("expected the write to time out")
+	} else if time.Since(start) > 1900*time.Millisecond {
+		t.Fatalf("expected the data deadline, took %s", time.Since(start))
+	}
+
+	srv = slowServer(t, NFSPROC3_READ)
+	s = srv.share(t, map[string]string{"metadata_timeout": "1", "data_timeout": "5"})
+	r, err := s.Cat("/file.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || string(b) != "content" {
+		t.Fatalf("expected the read to go through, got '%s' %v", b, err)
+	}
+}
+
+// the reply to what timed out comes in late, it mustn't be taken for the
+// reply to the next call
+func TestTimeoutDropsConnection(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	params := map[string]string{"metadata_timeout": "1", "remount_backoff": "1", "remount_jitter": "1"}
+	var slow sync.Once
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Proc == NFSPROC3_READDIRPLUS {
+			slow.Do(func() { time.Sleep(1500 * time.Millisecond) })
+		}
+		return 0
+	})
+	if _, err := srv.share(t, params).Ls("/"); err == nil {
+		t.Fatalf("expected ls to time out")
+	}
+	for i := 0; i < 3; i++ {
+		files, err := srv.share(t, params).Ls("/")
+		if err != nil {
+			t.Fatalf("ls: %v", err)
+		} else if len(files) != 1 || files[0].Name() != "file.txt" {
+			t.Fatalf("unexpected listing %v", files)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
+
+func TestNewShare(t *testing.T) {
+	s, err := newShare(map[string]string{
+		"hostname":    "nas",
+		"uid":         "1000",
+		"gid":         "1000",
+		"dir_mode":    "2770",
+		"exclude":     ".snapshot, ~$*",
+		"list_cache":  "10",
+		"chown_uid":   "33",
+		"raw_listing": "true",
+	}, &App{Context: context.Background()})
+	if err != nil {
+		t.Fatalf("new share: %v", err)
+	} else if s.host != "nas" || s.uid != 1000 || s.gid != 1000 {
+		t.Fatalf("unexpected identity %+v", s)
+	} else if unixMode(s.dirMode) != 02770 {
+		t.Fatalf("unexpected dir mode %o", s.dirMode)
+	} else if reflect.DeepEqual(s.exclude, []string{".snapshot", "~$*"}) == false {
+		t.Fatalf("unexpected exclude %v", s.exclude)
+	} else if s.listCache != 10*time.Second || s.metadataTimeout != DEFAULT_METADATA_TIMEOUT {
+		t.Fatalf("unexpected durations %s %s", s.listCache, s.metadataTimeout)
+	} else if s.chown.UID.SetIt == false || s.chown.UID.UID != 33 || s.chown.GID.SetIt {
+		t.Fatalf("unexpected chown %+v", s.chown)
+	} else if s.raw == false {
+		t.Fatalf("expected raw_listing to be honoured outside of the form")
+	}
+
+	if _, err = newShare(map[string]string{"hostname": "nas", "filename_charset": "nope"}, &App{Context: context.Background()}); err == nil {
+		t.Fatalf("expected an unknown charset to be refused")
+	}
+}
+
+// appliances running mountd on their own program number
+func TestMountProgOverride(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.Lock()
+	srv.mountProg = 300005
+	srv.mountVers = []uint32{MOUNT_V1}
+	srv.Unlock()
+	if _, err := srv.init(t, nil); err == nil {
+		t.Fatalf("expected the standard program number to fail")
+	}
+	if _, err := srv.init(t, map[string]string{"mount_prog": "300005", "mount_version": "1"}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if _, err := srv.init(t, map[string]string{"mount_prog": "mountd"}); err == nil {
+		t.Fatalf("expected a program number to be required")
+	}
+}
+
+// every advanced field sits behind exactly one of the toggles, the knobs
+// for troubleshooting stay out of the form
+func TestLoginFormGroups(t *testing.T) {
+	form := NfsShare{}.LoginForm()
+	toggles := map[string]string{}
+	ids := []string{}
+	for _, el := range form.Elmnts {
+		if el.Type == "enable" {
+			for _, id := range el.Target {
+				if other, ok := toggles[id]; ok {
+					t.Fatalf("%s is behind both %s and %s", id, other, el.Name)
+				}
+				toggles[id] = el.Name
+			}
+		} else if el.Id != "" {
+			ids = append(ids, el.Id)
+		}
+		switch el.Name {
+		case "raw_listing", "debug", "mount_prog", "mount_version":
+			t.Fatalf("expected %s out of the form", el.Name)
+		}
+	}
+	if len(ids) != len(toggles) {
+		t.Fatalf("expected %d fields behind a toggle, got %d", len(ids), len(toggles))
+	}
+	for _, id := range ids {
+		if _, ok := toggles[id]; ok == false {
+			t.Fatalf("%s isn't behind any toggle", id)
+		}
+	}
+}
+
+// a misconfigured login form is reported against the field to fix
+func TestInitFieldErrors(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		setup  func(srv *fakeServer)
+		params map[string]string
+		field  string
+		status int
+	}{
+		{"unreachable", nil, map[string]string{"hostname": "nowhere.invalid"}, "Hostname:", 502},
+		{"no export", nil, map[string]string{"target": "/nope"}, "Mount Path:", 404},
+		{"mount refused", func(srv *fakeServer) {
+			srv.setHook(func(c *fakeCall) uint32 {
+				if c.Prog == nfs.MountProg && c.Proc == nfs.MountProc3MNT {
+					return nfs.MNT3ErrAcces
+				}
+				return 0
+			})
+		}, nil, "Mount Path:", 403},
+		{"root refused", func(srv *fakeServer) {
+			srv.Lock()
+			srv.root.deny = ACCESS3_READ | ACCESS3_LOOKUP
+			srv.Unlock()
+		}, nil, "Mount Path:", 403},
+	} {
+		srv := newFakeServer(t)
+		if c.setup != nil {
+			c.setup(srv)
+		}
+		_, err := srv.init(t, c.params)
+		e, ok := err.(AppError)
+		if ok == false {
+			t.Fatalf("%s: expected an AppError, got %T %v", c.name, err, err)
+		} else if strings.HasPrefix(e.Error(), c.field) == false || e.Status() != c.status {
+			t.Fatalf("%s: expected '%s' with %d, got '%s' with %d", c.name, c.field, c.status, e.Error(), e.Status())
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	all := uint32(ACCESS3_READ | ACCESS3_LOOKUP | ACCESS3_MODIFY | ACCESS3_EXTEND | ACCESS3_DELETE)
+	for _, c := range []struct {
+		access                         uint32
+		see, create, rename, canDelete bool
+	}{
+		{0, false, false, false, false},
+		{ACCESS3_READ | ACCESS3_LOOKUP, true, false, false, false},
+		{ACCESS3_READ | ACCESS3_EXTEND, true, true, false, false},
+		{ACCESS3_DELETE, false, false, false, true},
+		{all, true, true, true, true},
+	} {
+		meta := capabilities(c.access)
+		if *meta.CanSee != c.see || *meta.CanCreateFile != c.create || *meta.CanCreateDirectory != c.create || *meta.CanUpload != c.create {
+			t.Fatalf("access=%#x unexpected see/create %+v", c.access, meta)
+		} else if *meta.CanRename != c.rename || *meta.CanMove != c.rename || *meta.CanDelete != c.canDelete {
+			t.Fatalf("access=%#x unexpected rename/delete %+v", c.access, meta)
+		}
+	}
+
+	// what the server says is what counts, not the mode bits
+	srv := newFakeServer(t)
+	n := srv.dir("/inbox")
+	srv.Lock()
+	n.mode = 0777
+	n.deny = ACCESS3_EXTEND | ACCESS3_DELETE
+	srv.Unlock()
+	meta := srv.share(t, nil).Meta("/inbox")
+	if *meta.CanSee == false || *meta.CanUpload || *meta.CanDelete {
+		t.Fatalf("expected a read only folder, got %+v", meta)
+	} else if srv.count(NFSPROC3_ACCESS) != 1 {
+		t.Fatalf("expected a single ACCESS, got %d", srv.count(NFSPROC3_ACCESS))
+	}
+}
+
+func TestEtcPasswdGuards(t *testing.T) {
+	passwdFixture(t, "guard-ok:x:2001:3001::/home/ok:/bin/sh\n")
+	if uid, gid, err := extractFromEtcPasswd("guard-ok"); err != nil || uid != 2001 || gid != 3001 {
+		t.Fatalf("expected 2001:3001, got %d:%d %v", uid, gid, err)
+	}
+
+	filler := strings.Repeat("#\n", ETC_PASSWD_MAX_LINES)
+	passwdFixture(t, filler+"guard-lines:x:2002:3002::/home/lines:/bin/sh\n")
+	if uid, gid, err := extractFromEtcPasswd("guard-lines"); err == nil || uid != DEFAULT_UID || gid != DEFAULT_GID {
+		t.Fatalf("expected the line count to trip, got %d:%d %v", uid, gid, err)
+	}
+
+	filler = strings.Repeat("x", ETC_PASSWD_MAX_SIZE) + "\n"
+	passwdFixture(t, filler+"guard-size:x:2003:3003::/home/size:/bin/sh\n")
+	if uid, gid, err := extractFromEtcPasswd("guard-size"); err == nil || uid != DEFAULT_UID || gid != DEFAULT_GID {
+		t.Fatalf("expected the size to trip, got %d:%d %v", uid, gid, err)
+	}
+
+	// nobody ever writes to that fifo, reading it would block forever
+	fifo := filepath.Join(t.TempDir(), "passwd")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	ETC_PASSWD = fifo
+	start := time.Now()
+	if uid, _, err := extractFromEtcPasswd("guard-fifo"); err == nil || uid != DEFAULT_UID {
+		t.Fatalf("expected a fifo to be refused, got %d %v", uid, err)
+	} else if time.Since(start) > time.Second {
+		t.Fatalf("expected the fifo to be refused straight away, took %s", time.Since(start))
+	}
+}
+
+func TestSquashRoot(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.squash = "root"
+	root := map[string]string{"uid": "0", "gid": "0"}
+
+	// what we'd otherwise get: the file belongs to nobody
+	if err := srv.share(t, root).Save("/as-root.txt", strings.NewReader("x")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if n := srv.node("/as-root.txt"); n.uid != DEFAULT_ANON_ID {
+		t.Fatalf("expected the server to squash root, got uid %d", n.uid)
+	}
+
+	root["squash_root"] = "refuse"
+	_, err := srv.init(t, root)
+	if e, ok := err.(AppError); ok == false || e.Status() != 403 || strings.HasPrefix(e.Error(), "uid:") == false {
+		t.Fatalf("expected root to be refused against the uid field, got %v", err)
+	}
+
+	root["squash_root"] = "remap"
+	if err = srv.share(t, root).Save("/remapped.txt", strings.NewReader("x")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if n := srv.node("/remapped.txt"); n.uid != DEFAULT_UID || n.gid != DEFAULT_GID {
+		t.Fatalf("expected the default uid and gid, got %d:%d", n.uid, n.gid)
+	}
+}
+
+func TestIsDir(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	srv.dir("/folder")
+	srv.symlink("/link", "folder")
+	for path, expected := range map[string]bool{
+		"/":         true,
+		"/file.txt": false,
+		"/folder":   true,
+		"/link":     false,
+	} {
+		s := srv.share(t, nil)
+		srv.resetCounts()
+		if isDir, err := s.IsDir(path); err != nil {
+			t.Fatalf("isdir %s: %v", path, err)
+		} else if isDir != expected {
+			t.Fatalf("isdir %s: expected %t", path, expected)
+		} else if n := srv.count(NFSPROC3_GETATTR) + srv.count(NFSPROC3_READDIRPLUS); n != 0 {
+			t.Fatalf("isdir %s: expected LOOKUP to be enough, got %d more calls", path, n)
+		}
+	}
+	if _, err := srv.share(t, nil).IsDir("/missing"); errors.Is(err, ErrNotFound) == false {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDotEntries(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/folder/file.txt", "content")
+	if files, err := srv.share(t, nil).Ls("/folder"); err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if got := fileNames(files); reflect.DeepEqual(got, []string{"file.txt"}) == false {
+		t.Fatalf("expected no dot entries by default, got %v", got)
+	}
+
+	// the server doesn't always send attributes for them
+	for _, noAttr := range []bool{false, true} {
+		srv.Lock()
+		srv.plusNoAttr = noAttr
+		srv.Unlock()
+		files, err := srv.share(t, map[string]string{"dot_entries": "true"}).Ls("/folder")
+		if err != nil {
+			t.Fatalf("ls: %v", err)
+		} else if got := fileNames(files); reflect.DeepEqual(got, []string{".", "..", "file.txt"}) == false {
+			t.Fatalf("expected the dot entries, got %v", got)
+		} else if files[0].IsDir() == false || files[1].IsDir() == false || files[2].IsDir() {
+			t.Fatalf("expected the dot entries as folders, got %v", files)
+		}
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/docs/file.txt", "content")
+	for path, target := range map[string]string{
+		"/docs/relative": "file.txt",
+		"/docs/parent":   "../docs/./file.txt",
+		"/absolute":      "/mnt/elsewhere/file.txt",
+		"/dangling":      "missing",
+	} {
+		srv.symlink(path, target)
+		if got, err := srv.share(t, nil).Readlink(path); err != nil {
+			t.Fatalf("readlink %s: %v", path, err)
+		} else if got != target {
+			t.Fatalf("readlink %s: expected '%s', got '%s'", path, target, got)
+		}
+	}
+	_, err := srv.share(t, nil).Readlink("/docs/file.txt")
+	var e *NfsError
+	if errors.As(err, &e) == false || e.Status() != 400 {
+		t.Fatalf("expected a 400 on a regular file, got %v", err)
+	}
+}
+
+func TestSaveChown(t *testing.T) {
+	srv := newFakeServer(t)
+	params := map[string]string{"uid": "0", "gid": "0", "chown_uid": "2001", "chown_gid": "3001"}
+	if err := srv.share(t, params).Save("/as-alice.txt", strings.NewReader("x")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if n := srv.node("/as-alice.txt"); n.uid != 2001 || n.gid != 3001 {
+		t.Fatalf("expected the file to belong to 2001:3001, got %d:%d", n.uid, n.gid)
+	}
+
+	// with chown_restricted, only root can give a file away
+	params["uid"], params["gid"] = "1000", "1000"
+	if err := srv.share(t, params).Save("/as-bob.txt", strings.NewReader("x")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if n := srv.node("/as-bob.txt"); n.uid != 1000 || n.gid != 3001 {
+		t.Fatalf("expected the file to belong to 1000:3001, got %d:%d", n.uid, n.gid)
+	}
+
+	// a refused chown doesn't cost the upload
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_SETATTR {
+			return nfs.NFS3ErrPerm
+		}
+		return 0
+	})
+	if err := srv.share(t, params).Save("/refused.txt", strings.NewReader("kept")); err != nil {
+		t.Fatalf("expected the upload to go through, got %v", err)
+	} else if got, _ := srv.content("/refused.txt"); got != "kept" {
+		t.Fatalf("unexpected content '%s'", got)
+	}
+}
+
+func TestDisplayRoot(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/top.txt", "top")
+	srv.file("/docs/a.txt", "a")
+	s := srv.share(t, map[string]string{"display_root": "/Team Data/"})
+
+	for path, expected := range map[string]string{"/": "/Team Data/top.txt", "/docs/": "/Team Data/docs/a.txt"} {
+		files, err := s.Ls(path)
+		if err != nil {
+			t.Fatalf("ls %s: %v", path, err)
+		}
+		for _, f := range files {
+			if f.Name() == "docs" {
+				continue
+			} else if got := f.(NfsFileInfo).FPath; got != expected {
+				t.Fatalf("expected '%s', got '%s'", expected, got)
+			}
+		}
+	}
+	// the label is for display only
+	r, err := s.Cat("/docs/a.txt")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	r.Close()
+	if _, err = s.Cat("/Team Data/docs/a.txt"); errors.Is(err, os.ErrNotExist) == false {
+		t.Fatalf("expected the label not to be a real path, got %v", err)
+	}
+}
+
+func TestSaveMkdirParents(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.dir("/uploads")
+	err := srv.share(t, nil).Save("/uploads/2024/05/photo.jpg", strings.NewReader("jpg"))
+	if errors.Is(err, os.ErrNotExist) == false {
+		t.Fatalf("expected the missing folders to be reported, got %v", err)
+	} else if names := srv.names("/uploads"); len(names) != 0 {
+		t.Fatalf("expected nothing created, got %v", names)
+	}
+
+	params := map[string]string{"mkdir_parents": "true", "dir_mode": "0750"}
+	if err = srv.share(t, params).Save("/uploads/2024/05/photo.jpg", strings.NewReader("jpg")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if got, _ := srv.content("/uploads/2024/05/photo.jpg"); got != "jpg" {
+		t.Fatalf("unexpected content '%s'", got)
+	} else if mode := srv.node("/uploads/2024").mode; mode != 0750 {
+		t.Fatalf("expected the folders created with dir_mode, got %o", mode)
+	}
+	// a file in the way isn't a folder
+	if err = srv.share(t, params).Save("/uploads/2024/05/photo.jpg/other.jpg", strings.NewReader("jpg")); err == nil {
+		t.Fatalf("expected a file in the path to be refused")
+	}
+}
+
+func TestPublicFh(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/hello.txt", "hello")
+	srv.Lock()
+	srv.mountVers = nil // mountd is off
+	srv.Unlock()
+	if _, err := srv.init(t, nil); err == nil {
+		t.Fatalf("expected the mount to fail without mountd")
+	}
+	for _, fh := range []string{"webnfs", hex.EncodeToString(srv.fh(srv.root))} {
+		srv.resetCounts()
+		s, err := srv.init(t, map[string]string{"public_fh": fh})
+		if err != nil {
+			t.Fatalf("public_fh=%s: %v", fh, err)
+		} else if srv.count(NFSPROC3_GETATTR) == 0 {
+			t.Fatalf("public_fh=%s: expected a GETATTR of the root", fh)
+		} else if srv.countProg(nfs.MountProg, nfs.MountProc3MNT) != 0 {
+			t.Fatalf("public_fh=%s: expected mountd to be left alone", fh)
+		} else if files, err := s.Ls("/"); err != nil || len(files) != 1 {
+			t.Fatalf("public_fh=%s: unexpected listing %v %v", fh, files, err)
+		}
+	}
+	for _, fh := range []string{"not hex", strings.Repeat("ab", NFS3_FHSIZE+1)} {
+		if _, err := srv.init(t, map[string]string{"public_fh": fh}); err == nil {
+			t.Fatalf("expected '%s' to be refused", fh)
+		}
+	}
+}
+
+func TestCreateExclusive(t *testing.T) {
+	srv := newFakeServer(t)
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		won  int
+		errs []error
+	)
+	for i := 0; i < 8; i++ {
+		s := srv.share(t, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.CreateExclusive("/app.lock")
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil {
+				won += 1
+			} else if errors.Is(err, ErrConflict) == false {
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) != 0 {
+		t.Fatalf("expected the losers to get a conflict, got %v", errs)
+	} else if won != 1 {
+		t.Fatalf("expected exactly one creator to win, got %d", won)
+	} else if srv.count(NFSPROC3_CREATE) != 8 {
+		t.Fatalf("expected every creator to go through CREATE, got %d", srv.count(NFSPROC3_CREATE))
+	}
+}
+
+func TestExportNotADirectory(t *testing.T) {
+	srv := newFakeServer(t)
+	root := srv.export("/backup.tar")
+	srv.Lock()
+	root.typ = nfs.NF3Reg
+	srv.Unlock()
+	_, err := srv.init(t, map[string]string{"target": "/backup.tar"})
+	var e AppError
+	if errors.As(err, &e) == false || e.Status() != 400 || strings.Contains(err.Error(), "export is not a directory") == false {
+		t.Fatalf("expected a descriptive error, got %v", err)
+	}
+	for _, c := range ActiveShares() {
+		if c.Host == srv.host && c.Target == "/backup.tar" {
+			t.Fatalf("expected the connection to be closed")
+		}
+	}
+}
+
+// share by link: known paths can be fetched, nothing can be browsed
+func TestNoList(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/shared/report.pdf", "report")
+	srv.dir("/shared/sub")
+	params := map[string]string{"no_list": "true", "warm_up": "true"}
+	s := srv.share(t, params)
+
+	for name, fn := range map[string]func() error{
+		"ls":       func() error { _, err := srv.share(t, params).Ls("/shared/"); return err },
+		"ls only":  func() error { _, err := srv.share(t, params).LsOnly("/shared/", "files"); return err },
+		"limited":  func() error { _, _, err := srv.share(t, params).LsLimited("/shared/"); return err },
+		"page":     func() error { _, _, err := srv.share(t, params).LsPage("/shared/", "", 10); return err },
+		"sorted":   func() error { _, err := srv.share(t, params).LsSorted("/shared/", "name", false); return err },
+		"snapshot": func() error { _, err := srv.share(t, params).Snapshots("/shared/"); return err },
+		"zip":      func() error { return srv.share(t, params).Zip("/shared/", io.Discard) },
+	} {
+		if err := fn(); errors.Is(err, ErrPermissionDenied) == false {
+			t.Fatalf("%s: expected the listing to be refused, got %v", name, err)
+		}
+	}
+	if n := srv.count(NFSPROC3_READDIRPLUS); n != 0 {
+		t.Fatalf("expected nothing listed, got %d READDIRPLUS", n)
+	}
+
+	r, err := s.Cat("/shared/report.pdf")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "report" {
+		t.Fatalf("unexpected content '%s' %v", b, err)
+	}
+	if res := srv.share(t, params).StatMany([]string{"/shared/report.pdf"})["/shared/report.pdf"]; res.Err != nil || res.Info.Size() != 6 {
+		t.Fatalf("expected a direct stat to work, got %+v", res)
+	} else if ok, err := srv.share(t, params).IsDir("/shared/sub"); err != nil || ok == false {
+		t.Fatalf("expected a direct check to work, got %t %v", ok, err)
+	}
+}
@@ -0,0 +1,177 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// NLM is the side protocol NFSv3 relies on for locking, as of:
+// https://pubs.opengroup.org/onlinepubs/9629799/chap10.htm
+const (
+	NLM_PROG = 100021
+	NLM_VERS = 4
+
+	NLM4_LOCK   = 2
+	NLM4_UNLOCK = 4
+
+	NLM4_GRANTED             = 0
+	NLM4_DENIED              = 1
+	NLM4_DENIED_GRACE_PERIOD = 4
+
+	LOCK_RETRY_INTERVAL = 500 * time.Millisecond
+)
+
+// every request of filestash goes to the server as the same lock owner which
+// NLM would happily grant the same lock to again. Keeping track of what we
+// hold makes a second Lock from another request wait like it would from
+// another client
+var (
+	nfsLocks     = map[string]bool{}
+	nfsLocksLock sync.Mutex
+)
+
+type nlm4Lock struct {
+	CallerName string
+	FH         []byte
+	Owner      []byte
+	Svid       int32
+	Offset     uint64
+	Length     uint64 // 0 means up to the end of the file
+}
+
+// Lock takes an exclusive advisory lock on the whole file, waiting up to
+// timeout for whoever holds it to let go. Locks are non blocking on the
+// server side since blocking ones need a callback service we don't have
+func (this NfsShare) Lock(path string, timeout time.Duration) (err error) {
+	defer this.Close()
+	defer this.wrapError("lock", path, &err)
+	this.metadataOp()
+
+	key := this.lockKey(path)
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		nfsLocksLock.Lock()
+		if nfsLocks[key] == false {
+			nfsLocks[key] = true
+			nfsLocksLock.Unlock()
+			break
+		}
+		nfsLocksLock.Unlock()
+		if time.Now().After(deadline) {
+			return ErrConflict
+		}
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+	for {
+		status, err := this.nlm(NLM4_LOCK, fh)
+		if err == nil && status == NLM4_GRANTED {
+			return nil
+		} else if err == nil && status != NLM4_DENIED && status != NLM4_DENIED_GRACE_PERIOD {
+			err = NewError("Lock refused by the server", 409)
+		} else if err == nil && time.Now().After(deadline) {
+			err = ErrConflict
+		}
+		if err != nil {
+			nfsLocksLock.Lock()
+			delete(nfsLocks, key)
+			nfsLocksLock.Unlock()
+			return err
+		}
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+}
+
+func (this NfsShare) Unlock(path string) (err error) {
+	defer this.Close()
+	defer this.wrapError("unlock", path, &err)
+	this.metadataOp()
+
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return err
+	}
+	status, err := this.nlm(NLM4_UNLOCK, fh)
+	if err != nil {
+		return err
+	} else if status != NLM4_GRANTED {
+		return NewError("Unlock refused by the server", 409)
+	}
+	nfsLocksLock.Lock()
+	delete(nfsLocks, this.lockKey(path))
+	nfsLocksLock.Unlock()
+	return nil
+}
+
+func (this NfsShare) lockKey(path string) string {
+	return this.host + ":" + this.params["target"] + ":" + this.nfsPath(path)
+}
+
+func (this NfsShare) nlm(proc uint32, fh []byte) (uint32, error) {
+	type LockArgs struct {
+		rpc.Header
+		Cookie    []byte
+		Block     bool
+		Exclusive bool
+		Lock      nlm4Lock
+		Reclaim   bool
+		State     int32
+	}
+	type UnlockArgs struct {
+		rpc.Header
+		Cookie []byte
+		Lock   nlm4Lock
+	}
+	type Res struct {
+		Cookie []byte
+		Stat   uint32
+	}
+	client, err := dialService(this.host, rpc.Mapping{
+		Prog: NLM_PROG,
+		Vers: NLM_VERS,
+		Prot: rpc.IPProtoTCP,
+	})
+	if err != nil {
+		Log.Debug("plg_backend_nfs::nlm dial error '%s'", err.Error())
+		return 0, ErrNotSupported
+	}
+	defer client.Close()
+	client.SetTimeout(this.metadataTimeout)
+
+	header := rpc.Header{
+		Rpcvers: 2,
+		Prog:    NLM_PROG,
+		Vers:    NLM_VERS,
+		Proc:    proc,
+		Cred:    this.auth,
+		Verf:    rpc.AuthNull,
+	}
+	lock := nlm4Lock{
+		CallerName: this.params["machine_name"],
+		FH:         fh,
+		Owner:      []byte(this.params["machine_name"]),
+		Svid:       int32(os.Getpid()),
+	}
+	var call interface{} = &UnlockArgs{Header: header, Lock: lock}
+	if proc == NLM4_LOCK {
+		call = &LockArgs{Header: header, Exclusive: true, Lock: lock}
+	}
+	res, err := client.Call(call)
+	if err != nil {
+		return 0, err
+	}
+	r := Res{}
+	if err = xdr.Read(res, &r); err != nil {
+		return 0, err
+	}
+	return r.Stat, nil
+}
@@ -0,0 +1,46 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestLock(t *testing.T) {
+	srv := newFakeServer(t)
+	doc := srv.file("/doc.txt", "shared")
+
+	if err := srv.share(t, nil).Lock("/doc.txt", time.Second); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	start := time.Now()
+	if err := srv.share(t, nil).Lock("/doc.txt", 0); errors.Is(err, ErrConflict) == false {
+		t.Fatalf("expected the second lock to be refused, got %v", err)
+	} else if time.Since(start) > time.Second {
+		t.Fatalf("expected no wait past the timeout")
+	}
+	if err := srv.share(t, nil).Unlock("/doc.txt"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	} else if err = srv.share(t, nil).Lock("/doc.txt", 0); err != nil {
+		t.Fatalf("expected the lock to be free again, got %v", err)
+	} else if err = srv.share(t, nil).Unlock("/doc.txt"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+
+	// held by another client
+	srv.Lock()
+	srv.locks[string(srv.fh(doc))] = "someone else"
+	srv.Unlock()
+	if err := srv.share(t, nil).Lock("/doc.txt", 0); errors.Is(err, ErrConflict) == false {
+		t.Fatalf("expected the lock of another client to be honoured, got %v", err)
+	}
+
+	srv.Lock()
+	srv.nlm = false
+	srv.Unlock()
+	if err := srv.share(t, nil).Lock("/doc.txt", 0); errors.Is(err, ErrNotSupported) == false {
+		t.Fatalf("expected locking to be reported as unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// resolve walks a path one component at a time, keeping track of the fsid as
+// it goes. On an export with nested mount points (crossmnt), stepping into a
+// child filesystem changes the fsid and the handles from there on belong to
+// that other filesystem. When one of those comes back as stale, the child
+// filesystem has most likely been remounted under our feet so we walk again
+// from the root instead of giving up
+func (this NfsShare) resolve(path string) (*nfs.Fattr, []byte, error) {
+	fattr, fh, crossed, err := this.walkPath(path)
+	if err != nil && crossed && isNfsError(err, nfs.NFS3ErrStale) {
+		Log.Debug("plg_backend_nfs::resolve stale handle past a mount boundary in '%s'", path)
+		fattr, fh, _, err = this.walkPath(path)
+	} else if err != nil && this.rootRefresh && isNfsError(err, nfs.NFS3ErrStale) {
+		this.staleRoot()
+	}
+	return fattr, fh, err
+}
+
+// without root_refresh, the other connections of the pool only find out
+// the root went stale by hitting it themselves. With it, they're all let go
+// straight away so the next request goes through MOUNT for the new root
+func (this NfsShare) staleRoot() {
+	if _, err := this.getattr(this.rootFh()); isNfsError(err, nfs.NFS3ErrStale) == false {
+		return
+	}
+	this.conn.markStale()
+	if this.pool != nil {
+		this.pool.rebooted("root file handle went stale")
+	}
+}
+
+func (this NfsShare) walkPath(path string) (*nfs.Fattr, []byte, bool, error) {
+	var (
+		fattr   *nfs.Fattr
+		fh      = this.rootFh()
+		fsid    = this.conn.rootFsid
+		crossed = false
+		err     error
+	)
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		err = this.jukebox(func() (err error) {
+			dir := fh
+			fattr, fh, err = this.lookup(dir, name)
+			if os.IsNotExist(err) && this.normalization != "" {
+				fattr, fh, err = this.lookupDenormalized(dir, name, err)
+			}
+			if err == nil && fattr == nil {
+				fattr, err = this.getattr(fh)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, nil, crossed, err
+		}
+		if fattr.FSID != fsid {
+			crossed = true
+			fsid = fattr.FSID
+		}
+	}
+	return fattr, fh, crossed, nil
+}
+
+func (this NfsShare) entryAttr(dir string, entry *nfs.EntryPlus) (*nfs.Fattr, error) {
+	if entry.Handle.IsSet {
+		return this.getattr(entry.Handle.FH)
+	}
+	attr, fh, err := this.resolve(dir + "/" + entry.FileName)
+	if err != nil {
+		return nil, err
+	} else if attr == nil {
+		return this.getattr(fh)
+	}
+	return attr, nil
+}
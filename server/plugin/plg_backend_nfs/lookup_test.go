@@ -0,0 +1,165 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// the first LOOKUP of name comes back stale, as it would right after the
+// filesystem it sits in got remounted on the server
+func staleOnce(srv *fakeServer, name string) {
+	var once sync.Once
+	srv.setHook(func(c *fakeCall) uint32 {
+		args := nfs.Diropargs3{}
+		status := uint32(0)
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_LOOKUP && xdr.Read(bytes.NewReader(c.Args), &args) == nil && args.Filename == name {
+			once.Do(func() { status = nfs.NFS3ErrStale })
+		}
+		return status
+	})
+}
+
+func TestCrossMount(t *testing.T) {
+	srv := newFakeServer(t)
+	mnt := srv.dir("/mnt")
+	srv.Lock()
+	mnt.fsid = 2
+	srv.Unlock()
+	srv.file("/mnt/child/file.txt", "across")
+	staleOnce(srv, "file.txt")
+
+	r, err := srv.share(t, nil).Cat("/mnt/child/file.txt")
+	if err != nil {
+		t.Fatalf("expected the path to be walked again past the mount, got %v", err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "across" {
+		t.Fatalf("unexpected content '%s'", b)
+	}
+
+	// within a single filesystem, a stale handle is just that
+	srv = newFakeServer(t)
+	srv.file("/same/child/file.txt", "same")
+	staleOnce(srv, "file.txt")
+	if _, err = srv.share(t, nil).Cat("/same/child/file.txt"); err == nil {
+		t.Fatalf("expected the stale handle to be reported")
+	}
+}
+
+func TestTrailingDotAndSpace(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/report.", "dot")
+	srv.file("/data ", "space")
+	srv.file("/folder./inner.txt", "inner")
+	s := srv.share(t, nil)
+
+	files, err := s.Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	names := map[string]bool{}
+	for _, f := range files {
+		names[f.Name()] = true
+	}
+	if names["report."] == false || names["data "] == false || names["folder."] == false {
+		t.Fatalf("expected the names as they are, got %v", fileNames(files))
+	}
+	for path, expected := range map[string]string{"/report.": "dot", "/data ": "space", "/folder./inner.txt": "inner"} {
+		r, err := s.Cat(path)
+		if err != nil {
+			t.Fatalf("cat '%s': %v", path, err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		if string(b) != expected {
+			t.Fatalf("cat '%s': unexpected content '%s'", path, b)
+		}
+	}
+	// only the one trailing separator of a folder goes away
+	if files, err = s.Ls("/folder./"); err != nil || len(files) != 1 {
+		t.Fatalf("ls: %v %v", files, err)
+	}
+	for _, path := range []string{"/report.", "/data ", "/folder./"} {
+		if err = s.Rm(path); err != nil {
+			t.Fatalf("rm '%s': %v", path, err)
+		}
+	}
+	if names := srv.names("/"); len(names) != 0 {
+		t.Fatalf("expected everything removed, got %v", names)
+	}
+}
+
+func TestWindowsPaths(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/a/b/c", "nested")
+	srv.file("/a\\b\\c", "backslashes")
+	cat := func(s NfsShare, path string) string {
+		r, err := s.Cat(path)
+		if err != nil {
+			t.Fatalf("cat '%s': %v", path, err)
+		}
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		return string(b)
+	}
+
+	// on unix a backslash is part of the name
+	if got := cat(srv.share(t, nil), "/a\\b\\c"); got != "backslashes" {
+		t.Fatalf("expected the file with backslashes, got '%s'", got)
+	}
+	params := map[string]string{"windows_paths": "true"}
+	for _, path := range []string{"/a\\b\\c", "\\a\\b\\c", "/a//b\\\\c", "//a/b/c"} {
+		if got := cat(srv.share(t, params), path); got != "nested" {
+			t.Fatalf("expected '%s' to lead to /a/b/c, got '%s'", path, got)
+		}
+	}
+}
+
+// servers that don't keep the root handle across reboots: the request that
+// runs into the stale root fails, with root_refresh the rest of the pool is
+// let go along with its connection so the next one gets the new root
+func TestRootRefresh(t *testing.T) {
+	for _, refresh := range []bool{true, false} {
+		srv := newFakeServer(t)
+		srv.file("/docs/a.txt", "a")
+		params := map[string]string{"list_cache": "60", "remount_backoff": "1", "remount_jitter": "1"}
+		if refresh {
+			params["root_refresh"] = "true"
+		}
+		// two connections in the pool
+		first, second := srv.share(t, params), srv.share(t, params)
+		if _, err := first.Ls("/docs/"); err != nil {
+			t.Fatalf("ls: %v", err)
+		}
+		second.Close()
+		first.Close()
+		mounts := srv.countProg(nfs.MountProg, nfs.MountProc3MNT)
+
+		srv.newRoot()
+		srv.file("/docs/b.txt", "b")
+		if _, err := srv.share(t, params).IsDir("/docs"); isNfsError(errors.Unwrap(err), nfs.NFS3ErrStale) == false {
+			t.Fatalf("refresh=%t: expected the old root to be stale, got %v", refresh, err)
+		}
+		if refresh == false {
+			if _, err := srv.share(t, params).IsDir("/docs"); err == nil {
+				t.Fatalf("expected the other connection to still be on the old root")
+			}
+			continue
+		}
+		files, err := srv.share(t, params).Ls("/docs/")
+		if err != nil {
+			t.Fatalf("expected the new root to be used, got %v", err)
+		} else if strings.Join(fileNames(files), ",") != "a.txt,b.txt" {
+			t.Fatalf("expected the cached listing dropped, got %v", fileNames(files))
+		} else if n := srv.countProg(nfs.MountProg, nfs.MountProc3MNT) - mounts; n != 1 {
+			t.Fatalf("expected a single MOUNT for the new root, got %d", n)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const (
+	LIST_PREFETCH_MAX         = 32
+	LIST_PREFETCH_CONCURRENCY = 2
+	LIST_PREFETCH_TTL         = 15 * time.Second
+)
+
+// listings shared by every request made against the same share, keyed by
+// their path on the server
+type lsCache struct {
+	entries map[string]lsEntry
+	sync.Mutex
+}
+
+type lsEntry struct {
+	files      []os.FileInfo
+	expire     time.Time
+	generation uint64
+}
+
+func (this *lsCache) get(pool *nfsPool, path string) ([]os.FileInfo, bool) {
+	this.Lock()
+	defer this.Unlock()
+	e, ok := this.entries[path]
+	if ok == false {
+		return nil, false
+	} else if time.Now().After(e.expire) || e.generation != pool.generation.Load() {
+		delete(this.entries, path)
+		return nil, false
+	}
+	return e.files, true
+}
+
+// invalidate forgets the listing of the folder holding path, and anything
+// under path itself in case it's a folder that was removed or renamed
+func (this *lsCache) invalidate(path string) {
+	path = strings.TrimSuffix(path, "/")
+	parent := filepath.Dir(path)
+	if parent == "/" || parent == "." {
+		parent = ""
+	}
+	this.Lock()
+	defer this.Unlock()
+	for key := range this.entries {
+		if key == parent || key == path || strings.HasPrefix(key, path+"/") {
+			delete(this.entries, key)
+		}
+	}
+}
+
+func (this *lsCache) set(pool *nfsPool, path string, files []os.FileInfo, ttl time.Duration) {
+	this.Lock()
+	defer this.Unlock()
+	if this.entries == nil {
+		this.entries = map[string]lsEntry{}
+	}
+	this.entries[path] = lsEntry{
+		files:      files,
+		expire:     time.Now().Add(ttl),
+		generation: pool.generation.Load(),
+	}
+}
+
+// listings we prefetch are kept as long as the cache is configured to keep
+// them, or just long enough for the user to click through otherwise
+func (this NfsShare) listTTL() time.Duration {
+	if this.listCache > 0 {
+		return this.listCache
+	}
+	return LIST_PREFETCH_TTL
+}
+
+// lists the subfolders of what was just listed in the background, on
+// connections of their own as ours goes back to the pool right away
+func (this NfsShare) prefetchLs(path string, files []os.FileInfo) {
+	dirs := []string{}
+	for _, f := range files {
+		if len(dirs) >= LIST_PREFETCH_MAX {
+			break
+		} else if f.IsDir() == false || f.Name() == "." || f.Name() == ".." {
+			continue
+		}
+		dirs = append(dirs, strings.TrimSuffix(path, "/")+"/"+f.Name()+"/")
+	}
+	if len(dirs) == 0 {
+		return
+	}
+	go func() {
+		queue := make(chan string)
+		var wg sync.WaitGroup
+		for i := 0; i < LIST_PREFETCH_CONCURRENCY && i < len(dirs); i++ {
+			share, err := this.acquire(this.pool, this.params)
+			if err != nil {
+				Log.Debug("plg_backend_nfs::prefetch_ls connection err[%s]", err.Error())
+				break
+			}
+			share.metadataOp()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer share.Close()
+				for p := range queue {
+					if files, truncated, err := share.ls(p); err == nil && truncated == false {
+						share.pool.listings.set(share.pool, share.nfsPath(p), files, share.listTTL())
+					}
+				}
+			}()
+		}
+		for _, p := range dirs {
+			queue <- p
+		}
+		close(queue)
+		wg.Wait()
+	}()
+}
+
+// the connection is already mounted and the root checked by the time we get
+// here, what's left for the first browse to be instant is its listing.
+// Logging in takes a bit longer in exchange
+func (this NfsShare) warmUp() {
+	path := this.nfsPath("/")
+	if _, ok := this.pool.listings.get(this.pool, path); ok {
+		return
+	}
+	this.metadataOp()
+	files, truncated, err := this.ls("/")
+	if err != nil {
+		Log.Debug("plg_backend_nfs::warm_up err[%s]", err.Error())
+		return
+	} else if truncated == false {
+		this.pool.listings.set(this.pool, path, files, this.listTTL())
+	}
+}
@@ -0,0 +1,162 @@
+package plg_backend_nfs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestListPrefetch(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/photos/2023/a.jpg", "a")
+	srv.file("/photos/2024/b.jpg", "b")
+	srv.file("/photos/2024/c.jpg", "c")
+	srv.file("/photos/readme.txt", "readme")
+	params := map[string]string{"list_prefetch": "true"}
+
+	s := srv.share(t, params)
+	srv.resetCounts()
+	if _, err := s.Ls("/photos/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	// one for the folder and one for each of its subfolders, in the background
+	for deadline := time.Now().Add(time.Second); srv.count(NFSPROC3_READDIRPLUS) < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the subfolders to be listed ahead, got %d READDIRPLUS", srv.count(NFSPROC3_READDIRPLUS))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	srv.resetCounts()
+	files, err := srv.share(t, params).Ls("/photos/2024/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 2 {
+		t.Fatalf("unexpected listing %v", fileNames(files))
+	} else if n := srv.count(NFSPROC3_READDIRPLUS); n != 0 {
+		t.Fatalf("expected the listing from the cache, got %d READDIRPLUS", n)
+	}
+
+	// off by default
+	srv.resetCounts()
+	if _, err = srv.share(t, nil).Ls("/photos/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := srv.count(NFSPROC3_READDIRPLUS); n != 1 {
+		t.Fatalf("expected a single READDIRPLUS, got %d", n)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/a.txt", "a")
+	srv.file("/b/c.txt", "c")
+
+	s := srv.share(t, map[string]string{"warm_up": "true"})
+	if n := srv.countProg(nfs.MountProg, nfs.MountProc3MNT); n != 1 {
+		t.Fatalf("expected the root handle out of a single MNT, got %d", n)
+	} else if n = srv.count(NFSPROC3_READDIRPLUS); n != 1 {
+		t.Fatalf("expected the root to be listed during init, got %d READDIRPLUS", n)
+	}
+	srv.resetCounts()
+	files, err := s.Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if strings.Join(fileNames(files), ",") != "a.txt,b" {
+		t.Fatalf("unexpected listing %v", fileNames(files))
+	}
+	srv.Lock()
+	calls := fmt.Sprint(srv.calls)
+	srv.Unlock()
+	if calls != "map[]" {
+		t.Fatalf("expected the first browse without a single call, got %s", calls)
+	}
+
+	// off by default
+	srv = newFakeServer(t)
+	srv.file("/a.txt", "a")
+	srv.share(t, nil)
+	if n := srv.count(NFSPROC3_READDIRPLUS); n != 0 {
+		t.Fatalf("expected nothing listed during init, got %d READDIRPLUS", n)
+	}
+}
+
+func TestListCache(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/docs/a.txt", "a")
+	srv.dir("/docs/sub")
+	params := map[string]string{"list_cache": "60"}
+	ls := func() string {
+		t.Helper()
+		files, err := srv.share(t, params).Ls("/docs/")
+		if err != nil {
+			t.Fatalf("ls: %v", err)
+		}
+		return strings.Join(fileNames(files), ",")
+	}
+
+	if got := ls(); got != "a.txt,sub" {
+		t.Fatalf("unexpected listing %s", got)
+	}
+	// changed behind our back, the cache doesn't know
+	srv.file("/docs/z.txt", "z")
+	srv.resetCounts()
+	if got := ls(); got != "a.txt,sub" {
+		t.Fatalf("expected the listing from the cache, got %s", got)
+	} else if n := srv.count(NFSPROC3_READDIRPLUS); n != 0 {
+		t.Fatalf("expected no READDIRPLUS, got %d", n)
+	}
+
+	// a file shows up on the server before each change, it's in the next
+	// listing only if the change threw the cached one away
+	for i, c := range []struct {
+		op      string
+		fn      func(s NfsShare) error
+		refetch bool
+	}{
+		{"save", func(s NfsShare) error { return s.Save("/docs/b.txt", strings.NewReader("b")) }, true},
+		{"touch", func(s NfsShare) error { return s.Touch("/docs/c.txt") }, true},
+		{"mkdir", func(s NfsShare) error { return s.Mkdir("/docs/new/") }, true},
+		{"rm", func(s NfsShare) error { return s.Rm("/docs/b.txt") }, true},
+		{"mv", func(s NfsShare) error { return s.Mv("/docs/c.txt", "/docs/sub/c.txt") }, true},
+		{"save below", func(s NfsShare) error { return s.Save("/docs/sub/d.txt", strings.NewReader("d")) }, false},
+	} {
+		ls()
+		marker := fmt.Sprintf("marker%d", i)
+		srv.file("/docs/"+marker, "")
+		if err := c.fn(srv.share(t, params)); err != nil {
+			t.Fatalf("%s: %v", c.op, err)
+		} else if got := ls(); strings.Contains(got, marker) != c.refetch {
+			t.Fatalf("%s: unexpected listing %s", c.op, got)
+		}
+	}
+
+	// past the TTL
+	s := srv.share(t, params)
+	ls()
+	s.pool.listings.Lock()
+	for key, e := range s.pool.listings.entries {
+		e.expire = time.Now().Add(-time.Second)
+		s.pool.listings.entries[key] = e
+	}
+	s.pool.listings.Unlock()
+	srv.resetCounts()
+	ls()
+	if n := srv.count(NFSPROC3_READDIRPLUS); n != 1 {
+		t.Fatalf("expected an expired listing to be fetched again, got %d READDIRPLUS", n)
+	}
+
+	// off by default
+	params = nil
+	srv.resetCounts()
+	ls()
+	ls()
+	if n := srv.count(NFSPROC3_READDIRPLUS); n != 2 {
+		t.Fatalf("expected every Ls to list, got %d READDIRPLUS", n)
+	}
+}
@@ -0,0 +1,200 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// some appliances register mountd under a program number of their own and
+// some only speak a given version of it. go-nfs-client has the standard
+// program and v3 baked in, the MNT call is ours to make when either is
+// overridden. The NFS program can't get the same treatment as the library
+// hardcodes it in the header of every call it makes
+type mountd struct {
+	prog uint32
+	vers uint32
+}
+
+const (
+	MOUNT_V1        = 1
+	MOUNT_V3        = 3
+	MOUNT_V1_FHSIZE = 32
+)
+
+func mountdParams(params map[string]string) (mountd, error) {
+	m := mountd{prog: nfs.MountProg, vers: nfs.MountVers}
+	if params["mount_prog"] != "" {
+		n, err := strconv.ParseUint(params["mount_prog"], 10, 32)
+		if err != nil {
+			return m, NewError("Mount program: expected an RPC program number", 400)
+		}
+		m.prog = uint32(n)
+	}
+	switch params["mount_version"] {
+	case "", "3":
+	case "1":
+		m.vers = MOUNT_V1
+	default:
+		return m, NewError("Mount version: expected 1 or 3", 400)
+	}
+	return m, nil
+}
+
+func (this NfsShare) mountFh(m mountd, dirpath string) ([]byte, error) {
+	client, err := this.dialMountd(m)
+	if err != nil {
+		return nil, err
+	}
+	// the mount protocol is only needed to get the root handle, there's no
+	// reason to keep that connection around past this point
+	defer client.Close()
+	return this.mnt(client, m, dirpath)
+}
+
+func (this NfsShare) mnt(client *rpc.Client, m mountd, dirpath string) ([]byte, error) {
+	type MountArgs struct {
+		rpc.Header
+		Dirpath string
+	}
+	res, err := client.Call(&MountArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    m.prog,
+			Vers:    m.vers,
+			Proc:    nfs.MountProc3MNT,
+			Cred:    this.auth,
+			Verf:    rpc.AuthNull,
+		},
+		Dirpath: dirpath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		return nil, err
+	}
+	// same errors as nfs.Mount so they go through mountError the same way.
+	// v1 shares the status codes that matter with v3
+	switch status {
+	case nfs.MNT3Ok:
+		if m.vers == MOUNT_V1 {
+			// a fixed size handle as of RFC1094 in:
+			// https://www.rfc-editor.org/rfc/rfc1094#appendix-A.3
+			fh := make([]byte, MOUNT_V1_FHSIZE)
+			if _, err = io.ReadFull(res, fh); err != nil {
+				return nil, err
+			}
+			return fh, nil
+		}
+		return xdr.ReadOpaque(res)
+	case nfs.MNT3ErrPerm:
+		return nil, errors.New("MNT3ERR_PERM")
+	case nfs.MNT3ErrNoEnt:
+		return nil, errors.New("MNT3ERR_NOENT")
+	case nfs.MNT3ErrAcces:
+		return nil, errors.New("MNT3ERR_ACCES")
+	case nfs.MNT3ErrNotDir:
+		return nil, errors.New("MNT3ERR_NOTDIR")
+	}
+	return nil, fmt.Errorf("unknown mount stat: %d", status)
+}
+
+func (this NfsShare) dialMountd(m mountd) (*rpc.Client, error) {
+	mapping := rpc.Mapping{
+		Prog: m.prog,
+		Vers: m.vers,
+		Prot: rpc.IPProtoTCP,
+	}
+	// the portmapper answers port 0 for what isn't registered, without
+	// asking first that would only show up as a connection refused
+	port, err := getPort(this.host, mapping)
+	if err != nil {
+		Log.Debug("plg_backend_nfs::dial portmapper error '%s'", err.Error())
+		return nil, NewError("Hostname: can't reach the server", 502)
+	} else if port == 0 {
+		if m.prog != nfs.MountProg {
+			return nil, NewError(fmt.Sprintf("Mount program: program %d version %d isn't registered on the server", m.prog, m.vers), 400)
+		}
+		return nil, NewError(fmt.Sprintf("Mount version: the server doesn't speak MOUNT v%d", m.vers), 400)
+	}
+	client, err := dialService(this.host, mapping)
+	if err != nil {
+		Log.Debug("plg_backend_nfs::dial mount prog[%d] vers[%d] error '%s'", m.prog, m.vers, err.Error())
+		return nil, NewError("Hostname: can't reach the server", 502)
+	}
+	client.SetTimeout(this.metadataTimeout)
+	return client, nil
+}
+
+type nfsExport struct {
+	Dir    string
+	Groups []string
+}
+
+// EXPORT as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-5.2.5
+// both the exports and their groups come as linked lists
+func (this NfsShare) exports() ([]nfsExport, error) {
+	m, err := mountdParams(this.params)
+	if err != nil {
+		return nil, err
+	}
+	// the export list of v1 is encoded the same way as the one of v3
+	client, err := this.dialMountd(m)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	res, err := client.Call(&rpc.Header{
+		Rpcvers: 2,
+		Prog:    m.prog,
+		Vers:    m.vers,
+		Proc:    nfs.MountProc3Export,
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readExports(res)
+}
+
+// the names are XDR strings, padded to a multiple of 4 bytes
+func readExports(r io.Reader) ([]nfsExport, error) {
+	exports := []nfsExport{}
+	for {
+		var follows bool
+		if err := xdr.Read(r, &follows); err != nil {
+			return nil, err
+		} else if follows == false {
+			return exports, nil
+		}
+		e := nfsExport{Groups: []string{}}
+		if err := xdr.Read(r, &e.Dir); err != nil {
+			return nil, err
+		}
+		for {
+			if err := xdr.Read(r, &follows); err != nil {
+				return nil, err
+			} else if follows == false {
+				break
+			}
+			var group string
+			if err := xdr.Read(r, &group); err != nil {
+				return nil, err
+			}
+			e.Groups = append(e.Groups, group)
+		}
+		exports = append(exports, e)
+	}
+}
@@ -0,0 +1,75 @@
+package plg_backend_nfs
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestMountVersion(t *testing.T) {
+	for _, c := range []struct {
+		server   []uint32
+		version  string
+		expected string
+	}{
+		{[]uint32{MOUNT_V1}, "1", ""},
+		{[]uint32{MOUNT_V3}, "3", ""},
+		{[]uint32{MOUNT_V3}, "", ""},
+		{[]uint32{MOUNT_V1}, "3", "doesn't speak MOUNT v3"},
+		{[]uint32{MOUNT_V3}, "1", "doesn't speak MOUNT v1"},
+		{[]uint32{MOUNT_V1, MOUNT_V3}, "2", "expected 1 or 3"},
+	} {
+		srv := newFakeServer(t)
+		srv.file("/a.txt", "a")
+		srv.Lock()
+		srv.mountVers = c.server
+		srv.Unlock()
+
+		s, err := srv.init(t, map[string]string{"mount_version": c.version})
+		if c.expected != "" {
+			if err == nil || strings.Contains(err.Error(), c.expected) == false {
+				t.Fatalf("server %v, version %q: expected %q, got %v", c.server, c.version, c.expected, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("server %v, version %q: %v", c.server, c.version, err)
+		}
+		if files, err := s.Ls("/"); err != nil || strings.Join(fileNames(files), ",") != "a.txt" {
+			t.Fatalf("server %v, version %q: unexpected listing %v %v", c.server, c.version, fileNames(files), err)
+		}
+	}
+}
+
+// a slow mountd shouldn't be blamed on the nfs server and the other way round
+func TestPhaseTimeout(t *testing.T) {
+	for _, c := range []struct {
+		slow     uint32
+		params   map[string]string
+		expected string
+	}{
+		{nfs.MountProg, map[string]string{"mount_timeout": "1", "nfs_timeout": "5"}, "Mount timeout"},
+		{nfs.MountProg, map[string]string{"mount_timeout": "5", "nfs_timeout": "1"}, ""},
+		{nfs.Nfs3Prog, map[string]string{"mount_timeout": "5", "nfs_timeout": "1"}, "NFS timeout"},
+		{nfs.Nfs3Prog, map[string]string{"mount_timeout": "1", "nfs_timeout": "5"}, ""},
+	} {
+		srv := newFakeServer(t)
+		srv.file("/a.txt", "a")
+		var once sync.Once
+		slow := c.slow
+		srv.setHook(func(call *fakeCall) uint32 {
+			if call.Prog == slow {
+				once.Do(func() { time.Sleep(1200 * time.Millisecond) })
+			}
+			return 0
+		})
+		_, err := srv.init(t, c.params)
+		if c.expected == "" && err != nil {
+			t.Fatalf("slow %d, %v: %v", c.slow, c.params, err)
+		} else if c.expected != "" && (err == nil || strings.Contains(err.Error(), c.expected) == false) {
+			t.Fatalf("slow %d, %v: expected %q, got %v", c.slow, c.params, c.expected, err)
+		}
+	}
+}
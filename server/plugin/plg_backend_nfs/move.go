@@ -0,0 +1,123 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+const RENAME_FALLBACK_COPY = "copy"
+
+// MvTo moves a file onto another NFS share. RENAME can't span two exports so
+// unless both ends are the same share, the content goes through READ/WRITE
+// and the source is only removed once the copy has been committed. Like any
+// other operation, both shares are done with once it returns
+func (this NfsShare) MvTo(from string, dst NfsShare, to string) (err error) {
+	defer this.Close()
+	defer dst.Close()
+	defer this.wrapError("mv", from+" -> "+dst.host+":"+to, &err)
+	this.dataOp()
+	dst.dataOp()
+
+	if this.pool == dst.pool {
+		return this.rename(this.nfsPath(from), this.nfsPath(to))
+	}
+	src := this.nfsPath(from)
+	if err = this.copyFile(src, dst, dst.nfsPath(to)); err != nil {
+		return err
+	}
+	defer this.pool.listings.invalidate(src)
+	return this.v.Remove(src)
+}
+
+// copyFile copies a regular file through READ/WRITE, dst being the same
+// share as this or another one. Whatever was at the destination is
+// replaced as RENAME would
+func (this NfsShare) copyFile(src string, dst NfsShare, to string) error {
+	if err := this.pool.coalescer.flushNow(src); err != nil {
+		return err
+	}
+	dst.pool.coalescer.drop(to)
+	attr, _, err := this.resolve(src)
+	if err != nil {
+		return err
+	} else if attr != nil && attr.Type != nfs.NF3Reg {
+		return ErrNotValid
+	}
+	f, err := this.v.Open(src)
+	if err != nil {
+		return err
+	}
+	w, err := dst.openWriter(to, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, f)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	// a destination that was longer than the source would keep its tail
+	size := uint64(n)
+	return dst.setattr(w.fh, (&AttrChanges{Size: &size}).sattr())
+}
+
+// some read-mostly appliances answer NFS3ERR_NOTSUPP to RENAME. A copy
+// followed by a delete gets the job done for files, though not atomically:
+// the source only goes away once the copy made it to the server
+func (this NfsShare) renameUnsupported(from string, to string) error {
+	if this.mvFallback != RENAME_FALLBACK_COPY {
+		return ErrNotSupported
+	}
+	Log.Debug("plg_backend_nfs::mv rename not supported, copying '%s' instead", from)
+	if err := this.copyFile(from, this, to); err != nil {
+		return err
+	}
+	defer this.pool.listings.invalidate(from)
+	return this.v.Remove(from)
+}
+
+// MvNoClobber is Mv refusing to replace an existing destination. NFSv3 has
+// no such thing as an exclusive RENAME so there's a window between our check
+// and the rename, the second lookup keeps it as short as we can
+func (this NfsShare) MvNoClobber(from string, to string) (err error) {
+	defer this.Close()
+	defer this.wrapError("mv", from+" -> "+to, &err)
+	this.metadataOp()
+
+	src := this.nfsPath(from)
+	dst := this.nfsPath(to)
+	if this.caps().CaseInsensitive && strings.EqualFold(src, dst) {
+		// only the case changes, what we'd find at the destination is the
+		// source itself
+		return this.rename(src, dst)
+	}
+	exists := func() (bool, error) {
+		_, _, err := this.resolve(dst)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if ok, err := exists(); err != nil {
+		return err
+	} else if ok {
+		return ErrConflict
+	}
+	if _, _, err = this.resolve(src); err != nil {
+		return err
+	}
+	if ok, err := exists(); err != nil {
+		return err
+	} else if ok {
+		return ErrConflict
+	}
+	return this.rename(src, dst)
+}
@@ -0,0 +1,103 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestMvTo(t *testing.T) {
+	a, b := newFakeServer(t), newFakeServer(t)
+	a.file("/report.txt", "new report")
+	b.file("/archive/report.txt", "a much longer old report")
+
+	if err := a.share(t, nil).MvTo("/report.txt", b.share(t, nil), "/archive/report.txt"); err != nil {
+		t.Fatalf("mv: %v", err)
+	} else if names := a.names("/"); len(names) != 0 {
+		t.Fatalf("expected the source to be removed, got %v", names)
+	} else if got, _ := b.content("/archive/report.txt"); got != "new report" {
+		t.Fatalf("expected the destination replaced, got '%s'", got)
+	} else if a.count(NFSPROC3_RENAME)+b.count(NFSPROC3_RENAME) != 0 {
+		t.Fatalf("expected no RENAME across two servers")
+	}
+
+	// nothing goes away unless the copy made it
+	a.file("/draft.txt", "draft")
+	b.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_WRITE {
+			return nfs.NFS3ErrNoSpc
+		}
+		return 0
+	})
+	if err := a.share(t, nil).MvTo("/draft.txt", b.share(t, nil), "/archive/draft.txt"); err == nil {
+		t.Fatalf("expected the failed copy to be reported")
+	} else if got, _ := a.content("/draft.txt"); got != "draft" {
+		t.Fatalf("expected the source to be kept, got '%s'", got)
+	}
+}
+
+func TestMvNoClobber(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/draft.txt", "draft")
+	srv.file("/final.txt", "final")
+
+	if err := srv.share(t, nil).MvNoClobber("/draft.txt", "/final.txt"); errors.Is(err, ErrConflict) == false {
+		t.Fatalf("expected the occupied name to be refused, got %v", err)
+	} else if n := srv.count(NFSPROC3_RENAME); n != 0 {
+		t.Fatalf("expected no RENAME, got %d", n)
+	} else if got, _ := srv.content("/final.txt"); got != "final" {
+		t.Fatalf("expected the destination untouched, got '%s'", got)
+	}
+	if err := srv.share(t, nil).MvNoClobber("/draft.txt", "/final-2.txt"); err != nil {
+		t.Fatalf("mv: %v", err)
+	} else if got, _ := srv.content("/final-2.txt"); got != "draft" {
+		t.Fatalf("unexpected content '%s'", got)
+	} else if names := srv.names("/"); reflect.DeepEqual(names, []string{"final.txt", "final-2.txt"}) == false {
+		t.Fatalf("unexpected listing %v", names)
+	}
+	if err := srv.share(t, nil).MvNoClobber("/missing.txt", "/other.txt"); errors.Is(err, os.ErrNotExist) == false {
+		t.Fatalf("expected a missing source to be reported, got %v", err)
+	}
+}
+
+func TestMvRenameNotSupp(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/inbox/report.txt", "quarterly report")
+	srv.dir("/archive")
+	srv.dir("/inbox/old")
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_RENAME {
+			return nfs.NFS3ErrNotSupp
+		}
+		return 0
+	})
+
+	if err := srv.share(t, nil).Mv("/inbox/report.txt", "/archive/report.txt"); errors.Is(err, ErrNotSupported) == false {
+		t.Fatalf("expected ErrNotSupported without the fallback, got %v", err)
+	} else if _, ok := srv.content("/inbox/report.txt"); ok == false {
+		t.Fatalf("expected the source left alone")
+	}
+
+	params := map[string]string{"rename_fallback": "copy"}
+	if err := srv.share(t, params).Mv("/inbox/report.txt", "/archive/report.txt"); err != nil {
+		t.Fatalf("mv: %v", err)
+	} else if _, ok := srv.content("/inbox/report.txt"); ok {
+		t.Fatalf("expected the source removed once copied")
+	} else if got, _ := srv.content("/archive/report.txt"); got != "quarterly report" {
+		t.Fatalf("expected the file copied over, got '%s'", got)
+	} else if srv.count(NFSPROC3_WRITE) == 0 || srv.count(NFSPROC3_REMOVE) != 1 {
+		t.Fatalf("expected a copy then a delete, got %d WRITE %d REMOVE", srv.count(NFSPROC3_WRITE), srv.count(NFSPROC3_REMOVE))
+	}
+
+	// there's no copying a folder through READ/WRITE
+	if err := srv.share(t, params).Mv("/inbox/old", "/archive/old"); err == nil {
+		t.Fatalf("expected a folder move to fail")
+	} else if srv.node("/inbox/old") == nil {
+		t.Fatalf("expected the folder left alone")
+	}
+}
@@ -0,0 +1,175 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// NfsMulti shows several exports of the same server as the folders of a
+// virtual root, named after the last component of their path. Every export
+// is a share of its own with its own pool, an operation goes to the share
+// the first component of its path points to. Moving from one export to
+// another goes through MvTo as RENAME can't span two exports
+type NfsMulti struct {
+	shares map[string]NfsShare
+	names  []string
+}
+
+func (this NfsShare) initMulti(params map[string]string, app *App) (IBackend, error) {
+	multi := NfsMulti{shares: map[string]NfsShare{}}
+	for _, target := range strings.Split(params["targets"], ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		name := filepath.Base(target)
+		if _, ok := multi.shares[name]; ok || name == "/" || name == "." {
+			multi.Close()
+			return nil, NewError("Targets: two exports would show up as '"+name+"'", 400)
+		}
+		p := make(map[string]string, len(params))
+		for k, v := range params {
+			p[k] = v
+		}
+		delete(p, "targets")
+		p["target"] = target
+		backend, err := NfsShare{}.Init(p, app)
+		if err != nil {
+			multi.Close()
+			return nil, err
+		}
+		multi.shares[name] = backend.(NfsShare)
+		multi.names = append(multi.names, name)
+	}
+	if len(multi.names) == 0 {
+		return nil, NewError("Targets: expected a list of exports", 400)
+	}
+	sort.Strings(multi.names)
+	return multi, nil
+}
+
+func (this NfsMulti) Init(params map[string]string, app *App) (IBackend, error) {
+	return NfsShare{}.Init(params, app)
+}
+
+func (this NfsMulti) LoginForm() Form {
+	return NfsShare{}.LoginForm()
+}
+
+// Close gives back the connection of every export, not only the one the
+// request went to
+func (this NfsMulti) Close() {
+	for _, share := range this.shares {
+		share.Close()
+	}
+}
+
+func (this NfsMulti) route(path string) (NfsShare, string, error) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	share, ok := this.shares[name]
+	if ok == false {
+		return share, "", ErrNotFound
+	}
+	return share, "/" + rest, nil
+}
+
+// the virtual root and the folders standing for the exports can't be
+// changed, only what's inside of them
+func (this NfsMulti) routeWrite(path string) (NfsShare, string, error) {
+	share, p, err := this.route(path)
+	if err == nil && p == "/" {
+		return share, p, ErrNotAllowed
+	}
+	return share, p, err
+}
+
+func (this NfsMulti) Ls(path string) ([]os.FileInfo, error) {
+	defer this.Close()
+	if strings.Trim(path, "/") == "" {
+		// every export is set up from the same params, no_list included
+		if this.shares[this.names[0]].noList {
+			return nil, ErrPermissionDenied
+		}
+		files := make([]os.FileInfo, 0, len(this.names))
+		for _, name := range this.names {
+			files = append(files, File{FName: name, FType: "directory"})
+		}
+		return files, nil
+	}
+	share, p, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return share.Ls(p)
+}
+
+func (this NfsMulti) Cat(path string) (io.ReadCloser, error) {
+	share, p, err := this.route(path)
+	// the reader holds on to the connection of its export until it's
+	// closed, the other exports are done with
+	for _, s := range this.shares {
+		if s.pool != share.pool {
+			s.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return share.Cat(p)
+}
+
+func (this NfsMulti) Mkdir(path string) error {
+	defer this.Close()
+	share, p, err := this.routeWrite(path)
+	if err != nil {
+		return err
+	}
+	return share.Mkdir(p)
+}
+
+func (this NfsMulti) Rm(path string) error {
+	defer this.Close()
+	share, p, err := this.routeWrite(path)
+	if err != nil {
+		return err
+	}
+	return share.Rm(p)
+}
+
+func (this NfsMulti) Mv(from string, to string) error {
+	defer this.Close()
+	src, f, err := this.routeWrite(from)
+	if err != nil {
+		return err
+	}
+	dst, t, err := this.routeWrite(to)
+	if err != nil {
+		return err
+	} else if src.pool == dst.pool {
+		return src.Mv(f, t)
+	}
+	return src.MvTo(f, dst, t)
+}
+
+func (this NfsMulti) Save(path string, file io.Reader) error {
+	defer this.Close()
+	share, p, err := this.routeWrite(path)
+	if err != nil {
+		return err
+	}
+	return share.Save(p, file)
+}
+
+func (this NfsMulti) Touch(path string) error {
+	defer this.Close()
+	share, p, err := this.routeWrite(path)
+	if err != nil {
+		return err
+	}
+	return share.Touch(p)
+}
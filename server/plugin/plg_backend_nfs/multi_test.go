@@ -0,0 +1,69 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestMultiExports(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/a.txt", "from the first export")
+	photos := srv.export("/srv/photos")
+	srv.Lock()
+	srv.newNode(photos, "b.txt", nfs.NF3Reg, 0644).data = []byte("from the second export")
+	srv.Unlock()
+
+	backend, err := srv.init(t, map[string]string{"targets": "/export, /srv/photos"})
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	s, ok := backend.(NfsMulti)
+	if ok == false {
+		t.Fatalf("expected a virtual tree, got %T", backend)
+	}
+	// every export gives its connection back, not only the one used
+	released := func(step string) {
+		for _, c := range ActiveShares() {
+			if c.Host == srv.host && c.InUse {
+				t.Fatalf("%s: expected %s to be released", step, c.Target)
+			}
+		}
+	}
+	for path, expected := range map[string]string{
+		"/export/a.txt": "from the first export",
+		"/photos/b.txt": "from the second export",
+	} {
+		r, err := s.Cat(path)
+		if err != nil {
+			t.Fatalf("cat %s: %v", path, err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != expected {
+			t.Fatalf("cat %s: got '%s' %v", path, b, err)
+		}
+		released("cat " + path)
+	}
+	if files, err := s.Ls("/"); err != nil || strings.Join(fileNames(files), ",") != "export,photos" {
+		t.Fatalf("unexpected root %v %v", fileNames(files), err)
+	} else if files[0].IsDir() == false || files[1].IsDir() == false {
+		t.Fatalf("expected the exports to show up as folders")
+	}
+	if files, err := s.Ls("/photos/"); err != nil || strings.Join(fileNames(files), ",") != "b.txt" {
+		t.Fatalf("unexpected listing %v %v", fileNames(files), err)
+	}
+	if _, err := s.Cat("/nowhere/a.txt"); err == nil {
+		t.Fatalf("expected an unknown export to be refused")
+	}
+	released("ls")
+
+	srv.Lock()
+	mnts := strings.Join(srv.mnts, ",")
+	srv.Unlock()
+	if mnts != "/export,/srv/photos" && mnts != "/srv/photos,/export" {
+		t.Fatalf("expected both exports mounted, got %v", mnts)
+	}
+}
@@ -0,0 +1,86 @@
+package plg_backend_nfs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+var ErrCursorExpired = NewError("Directory has changed, the listing needs to start over", 409)
+
+// LsPage gives up to size entries of a folder starting from cursor along
+// with the cursor of the next page, an empty cursor being both the start
+// and the end of the listing. The cursor is the READDIRPLUS cookie and
+// cookie verifier, which makes it good for as long as the server says so:
+// once the folder changed in a way the server can't continue from, the
+// whole listing has to start over. Entries that aren't shown, like the
+// excluded ones, still count toward the page size so a page can come back
+// with less than that before the end
+func (this NfsShare) LsPage(path string, cursor string, size int) (_ []os.FileInfo, next string, err error) {
+	defer this.Close()
+	defer this.wrapError("ls", path, &err)
+	defer this.slowOp("ls", path, time.Now())
+	this.metadataOp()
+
+	if this.noList {
+		return nil, "", ErrPermissionDenied
+	} else if size <= 0 {
+		return nil, "", ErrNotValid
+	}
+	cookie, cookieVerf, err := parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return nil, "", err
+	}
+	entries := []*nfs.EntryPlus{}
+	for {
+		var (
+			page []*nfs.EntryPlus
+			eof  bool
+		)
+		err = this.jukebox(func() (err error) {
+			page, cookie, cookieVerf, eof, err = this.readdirplus(fh, cookie, cookieVerf)
+			return err
+		})
+		if isNfsError(err, nfs.NFS3ErrBadCookie) || isNfsError(err, nfs.NFS3ErrNotSync) {
+			return nil, "", ErrCursorExpired
+		} else if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, page...)
+		if len(entries) > size || (len(entries) == size && eof == false) {
+			// each entry has a cookie of its own, the next page starts
+			// right after the last one we give out
+			entries = entries[:size]
+			return this.toFiles(path, entries), formatCursor(entries[size-1].Cookie, cookieVerf), nil
+		} else if eof {
+			return this.toFiles(path, entries), "", nil
+		}
+	}
+}
+
+func formatCursor(cookie uint64, cookieVerf uint64) string {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[:8], cookie)
+	binary.BigEndian.PutUint64(b[8:], cookieVerf)
+	return hex.EncodeToString(b)
+}
+
+func parseCursor(cursor string) (uint64, uint64, error) {
+	if cursor == "" {
+		return 0, 0, nil
+	}
+	b, err := hex.DecodeString(cursor)
+	if err != nil || len(b) != 16 {
+		return 0, 0, NewError("Cursor: not a cursor from a previous page", 400)
+	}
+	return binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:]), nil
+}
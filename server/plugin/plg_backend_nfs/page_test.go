@@ -0,0 +1,70 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestLsPage(t *testing.T) {
+	srv := newFakeServer(t)
+	for i := 0; i < 30; i++ {
+		srv.file(fmt.Sprintf("/big/%02d.txt", i), "")
+	}
+	srv.Lock()
+	srv.page = 5
+	srv.Unlock()
+
+	// "." and ".." count toward the first page without being shown
+	seen := map[string]bool{}
+	cursor := ""
+	for i, expected := range []int{9, 11, 10} {
+		files, next, err := srv.share(t, nil).LsPage("/big/", cursor, 11)
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		} else if len(files) != expected {
+			t.Fatalf("page %d: expected %d entries, got %v", i, expected, fileNames(files))
+		} else if (next == "") != (i == 2) {
+			t.Fatalf("page %d: unexpected cursor '%s'", i, next)
+		}
+		for _, name := range fileNames(files) {
+			if seen[name] {
+				t.Fatalf("page %d: %s seen twice", i, name)
+			}
+			seen[name] = true
+		}
+		cursor = next
+	}
+	if len(seen) != 30 {
+		names := []string{}
+		for name := range seen {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t.Fatalf("expected every file once, got %v", names)
+	}
+
+	// the folder changed in a way the server can't carry on from
+	_, cursor, err := srv.share(t, nil).LsPage("/big/", "", 11)
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	srv.Lock()
+	srv.cookieVerf += 1
+	srv.Unlock()
+	if _, _, err = srv.share(t, nil).LsPage("/big/", cursor, 11); errors.Is(err, ErrCursorExpired) == false {
+		t.Fatalf("expected the listing to start over, got %v", err)
+	} else if files, _, err := srv.share(t, nil).LsPage("/big/", "", 11); err != nil || len(files) != 9 {
+		t.Fatalf("expected a fresh start to work, got %v %v", fileNames(files), err)
+	}
+
+	for _, c := range []struct {
+		cursor string
+		size   int
+	}{{"", 0}, {"nothex", 11}, {"abcd", 11}} {
+		if _, _, err = srv.share(t, nil).LsPage("/big/", c.cursor, c.size); err == nil {
+			t.Fatalf("expected cursor '%s' of size %d to be refused", c.cursor, c.size)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package plg_backend_nfs
+
+import (
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+var ErrNameTooLong = NewError("Filename is too long for this server", 400)
+
+type pathconf struct {
+	Attr            nfs.PostOpAttr
+	LinkMax         uint32
+	NameMax         uint32
+	NoTrunc         bool
+	ChownRestricted bool
+	CaseInsensitive bool
+	CasePreserving  bool
+}
+
+// PATHCONF as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.20
+func (this NfsShare) pathconf(fh []byte) (*pathconf, error) {
+	type PathconfArgs struct {
+		FH []byte
+	}
+	const PATHCONF3res = 20
+	p := pathconf{}
+	err := this.callRes(PATHCONF3res, &PathconfArgs{
+		FH: fh,
+	}, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// NfsCapabilities is what the server told us about how it handles names
+// and ownership through PATHCONF. Known is false when the server didn't
+// answer it, in which case the other fields are mere defaults
+type NfsCapabilities struct {
+	Known           bool
+	NameMax         uint32
+	NoTrunc         bool
+	ChownRestricted bool
+	CaseInsensitive bool
+	CasePreserving  bool
+}
+
+func (this NfsShare) Capabilities() NfsCapabilities {
+	defer this.Close()
+	return this.caps()
+}
+
+func (this NfsShare) caps() NfsCapabilities {
+	if this.conn == nil || this.conn.pathconf == nil {
+		// what a regular unix server does
+		return NfsCapabilities{NoTrunc: true, ChownRestricted: true, CasePreserving: true}
+	}
+	p := this.conn.pathconf
+	return NfsCapabilities{
+		Known:           true,
+		NameMax:         p.NameMax,
+		NoTrunc:         p.NoTrunc,
+		ChownRestricted: p.ChownRestricted,
+		CaseInsensitive: p.CaseInsensitive,
+		CasePreserving:  p.CasePreserving,
+	}
+}
+
+// rejects a name the server would refuse with NFS3ERR_NAMETOOLONG before
+// anything is sent its way. Path is expected to be already encoded as the
+// limit applies to what goes over the wire. Servers without no_trunc would
+// accept it and silently cut the name short, which isn't any better
+func (this NfsShare) checkName(path string) error {
+	if c := this.caps(); c.NameMax > 0 && len(filepath.Base(path)) > int(c.NameMax) {
+		return ErrNameTooLong
+	}
+	return nil
+}
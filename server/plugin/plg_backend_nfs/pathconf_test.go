@@ -0,0 +1,60 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNameTooLong(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/short.txt", "short")
+	srv.Lock()
+	srv.pathconf.NameMax = 16
+	srv.Unlock()
+	s := srv.share(t, nil)
+	srv.resetCounts()
+
+	long := strings.Repeat("x", 13) + ".txt"
+	for op, fn := range map[string]func() error{
+		"save":  func() error { return s.Save("/"+long, strings.NewReader("long")) },
+		"mkdir": func() error { return s.Mkdir("/" + long + "/") },
+		"touch": func() error { return s.Touch("/" + long) },
+		"mv":    func() error { return s.Mv("/short.txt", "/"+long) },
+		// the limit is in bytes, not in characters
+		"utf8": func() error { return s.Save("/"+strings.Repeat("é", 9), strings.NewReader("long")) },
+	} {
+		if err := fn(); errors.Is(err, ErrNameTooLong) == false {
+			t.Fatalf("%s: expected the name to be refused, got %v", op, err)
+		}
+	}
+	for _, proc := range []uint32{NFSPROC3_CREATE, NFSPROC3_MKDIR, NFSPROC3_RENAME} {
+		if n := srv.count(proc); n != 0 {
+			t.Fatalf("expected nothing sent to the server, got %d calls to %d", n, proc)
+		}
+	}
+	if err := s.Save("/"+long[1:], strings.NewReader("fits")); err != nil {
+		t.Fatalf("expected a name right at the limit to go through, got %v", err)
+	}
+}
+
+func TestPathconf(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.Lock()
+	srv.pathconf = &pathconf{NameMax: 128, NoTrunc: false, ChownRestricted: false, CaseInsensitive: true, CasePreserving: true}
+	srv.Unlock()
+	expected := NfsCapabilities{Known: true, NameMax: 128, CaseInsensitive: true, CasePreserving: true}
+	if c := srv.share(t, nil).Capabilities(); c != expected {
+		t.Fatalf("expected %+v, got %+v", expected, c)
+	}
+
+	// without an answer, we assume a regular unix server
+	srv = newFakeServer(t)
+	srv.Lock()
+	srv.pathconf = nil
+	srv.Unlock()
+	expected = NfsCapabilities{NoTrunc: true, ChownRestricted: true, CasePreserving: true}
+	if c := srv.share(t, nil).Capabilities(); c != expected {
+		t.Fatalf("expected %+v, got %+v", expected, c)
+	}
+}
@@ -0,0 +1,115 @@
+package plg_backend_nfs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+)
+
+// connecting goes through two phases that can each be slow on their own:
+// getting the root handle out of mountd, then connecting to the NFS server
+// itself. The lib dials without any timeout so there's no cancelling
+// either of them, past the deadline we stop waiting and whatever it ends
+// up with is let go with abandon
+func inTime(timeout time.Duration, fn func() error, abandon func()) error {
+	if timeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		go func() {
+			if err := <-done; err == nil {
+				abandon()
+			}
+		}()
+		return ErrTimeout
+	}
+}
+
+// mountPhase gives the root handle of the export. With the standard mountd
+// the MOUNT connection is kept along with the share as go-nfs-client does
+func (this NfsShare) mountPhase(m mountd, target string) (*nfs.Mount, []byte, error) {
+	mount, fh, err := this.mountExport(m, target)
+	if err != nil && err.Error() == "MNT3ERR_NOENT" {
+		// some servers advertise their exports with a trailing slash or
+		// space MNT wants to see as is
+		match, suggestion := this.nearExport(target)
+		if match != "" && match != target {
+			Log.Debug("plg_backend_nfs::init mounting '%s' as advertised by the server", match)
+			mount, fh, err = this.mountExport(m, match)
+		} else if suggestion != "" {
+			return nil, nil, NewError(fmt.Sprintf("Mount Path: export not found, did you mean '%s'?", suggestion), 404)
+		}
+	}
+	if err != nil {
+		return nil, nil, mountError(err)
+	}
+	return mount, fh, nil
+}
+
+func (this NfsShare) mountExport(m mountd, target string) (*nfs.Mount, []byte, error) {
+	if m.prog != nfs.MountProg || m.vers != nfs.MountVers {
+		fh, err := this.mountFh(m, target)
+		return nil, fh, err
+	}
+	mount, err := dialMount(this.host)
+	if err != nil {
+		Log.Debug("plg_backend_nfs::init dial mount error '%s'", err.Error())
+		// the lib dials port 0 for a version that isn't registered, which
+		// is no reason to blame the hostname
+		mapping := rpc.Mapping{Prog: m.prog, Vers: m.vers, Prot: rpc.IPProtoTCP}
+		if port, err := getPort(this.host, mapping); err == nil && port == 0 {
+			return nil, nil, NewError(fmt.Sprintf("Mount version: the server doesn't speak MOUNT v%d", m.vers), 400)
+		}
+		return nil, nil, NewError("Hostname: can't reach the server", 502)
+	}
+	fh, err := this.mnt(mount.Client, m, target)
+	if err != nil {
+		mount.Close()
+		return nil, nil, err
+	}
+	return mount, fh, nil
+}
+
+// nearExport looks for target in the export list once the stray spaces
+// and slashes are out of the way on both sides. What comes back is the
+// export exactly as the server has it, or one only differing by case to
+// point the user at
+func (this NfsShare) nearExport(target string) (match string, suggestion string) {
+	exports, err := this.exports()
+	if err != nil {
+		Log.Debug("plg_backend_nfs::init export list err[%s]", err.Error())
+		return "", ""
+	}
+	want := normalizeExport(target)
+	for _, e := range exports {
+		if normalizeExport(e.Dir) == want {
+			return e.Dir, ""
+		} else if strings.EqualFold(normalizeExport(e.Dir), want) {
+			suggestion = normalizeExport(e.Dir)
+		}
+	}
+	return "", suggestion
+}
+
+func normalizeExport(path string) string {
+	path = strings.TrimSpace(path)
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
@@ -0,0 +1,325 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+var NfsCache AppCache
+
+const (
+	DEFAULT_IDLE_TIMEOUT   = 60 * time.Second
+	DEFAULT_REMOUNT_BASE   = 500 * time.Millisecond
+	DEFAULT_REMOUNT_JITTER = 2 * time.Second
+	MAX_REMOUNT_DELAY      = 30 * time.Second
+
+	// consecutive stale handles after which we consider the server rebooted
+	STALE_BURST = 3
+)
+
+// the rpc client from the original lib can't be shared by concurrent
+// requests as replies would be read by whoever comes first. A pool holds as
+// many connections as there are concurrent requests for the same share, each
+// of them used by a single request at a time
+type nfsPool struct {
+	host      string
+	target    string
+	conns     []*nfsConn
+	coalescer *coalescer
+	stale     int // consecutive remounts caused by stale file handles
+	verf      uint64
+	hasVerf   bool
+	root      []byte
+	listings  lsCache
+	buffers   bufferPool
+	sync.Mutex
+
+	// bumped whenever the server looks like it has rebooted, anything we
+	// keep around about the share is only good for the generation it was
+	// fetched in
+	generation atomic.Uint64
+
+	// counters are touched with a connection locked, they can't rely on the
+	// pool lock without risking a deadlock with get
+	hits           atomic.Uint64
+	misses         atomic.Uint64
+	staleEvictions atomic.Uint64
+	idleEvictions  atomic.Uint64
+}
+
+type nfsConn struct {
+	mount    *nfs.Mount
+	v        *nfs.Target
+	pool     *nfsPool
+	rootFsid uint64
+	root     []byte
+	pathconf *pathconf
+	idle     time.Duration
+	timer    *time.Timer
+	created  time.Time
+	lastUsed time.Time
+	inUse    bool
+	closed   bool
+	evicted  bool
+	stale    bool
+	sync.Mutex
+}
+
+func (this *nfsPool) get() *nfsConn {
+	this.Lock()
+	defer this.Unlock()
+	conns := this.conns[:0]
+	var conn *nfsConn
+	for _, c := range this.conns {
+		if c.isClosed() {
+			continue
+		}
+		conns = append(conns, c)
+		if conn == nil && c.acquire() {
+			conn = c
+		}
+	}
+	this.conns = conns
+	if conn == nil {
+		this.misses.Add(1)
+	} else {
+		this.hits.Add(1)
+	}
+	return conn
+}
+
+func (this *nfsPool) add(conn *nfsConn) {
+	this.Lock()
+	conn.pool = this
+	this.conns = append(this.conns, conn)
+	this.stale = 0
+	// a few servers hand out a new root handle after a reboot, whatever
+	// the other connections and the caches got from the old one is gone
+	changed := this.root != nil && bytes.Equal(this.root, conn.root) == false
+	this.root = conn.root
+	this.Unlock()
+	if changed {
+		this.rebooted("root file handle changed")
+	}
+}
+
+// after a server reboot, every session hits a stale handle at about the same
+// time. The jitter spreads their remounts so the recovering server doesn't
+// get hammered all at once
+func (this *nfsPool) remountDelay(base time.Duration, jitter time.Duration) time.Duration {
+	this.Lock()
+	attempt := this.stale
+	this.Unlock()
+	if attempt == 0 {
+		return 0
+	}
+	d := MAX_REMOUNT_DELAY
+	if attempt < 16 {
+		d = base << uint(attempt-1)
+	}
+	if d > MAX_REMOUNT_DELAY {
+		d = MAX_REMOUNT_DELAY
+	}
+	if jitter > 0 {
+		remountRandLock.Lock()
+		d += time.Duration(remountRand.Int63n(int64(jitter)))
+		remountRandLock.Unlock()
+	}
+	return d
+}
+
+var (
+	remountRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	remountRandLock sync.Mutex
+)
+
+// NFSv3 has no boot verifier as such but the write verifier is meant to
+// change when the server reboots, as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.7
+func (this *nfsPool) observeVerifier(verf uint64) {
+	this.Lock()
+	changed := this.hasVerf && this.verf != verf
+	this.verf = verf
+	this.hasVerf = true
+	this.Unlock()
+	if changed {
+		this.rebooted("write verifier changed")
+	}
+}
+
+func (this *nfsPool) rebooted(reason string) {
+	Log.Warning("plg_backend_nfs::reboot server %s:%s looks like it rebooted: %s", this.host, this.target, reason)
+	this.generation.Add(1)
+	// idle connections were left hanging by the reboot
+	this.Lock()
+	for _, c := range this.conns {
+		c.Lock()
+		if c.inUse == false {
+			c.close()
+		}
+		c.Unlock()
+	}
+	this.Unlock()
+}
+
+func (this *nfsPool) evict() {
+	this.Lock()
+	defer this.Unlock()
+	for _, c := range this.conns {
+		c.Lock()
+		c.evicted = true
+		if c.inUse == false {
+			c.close()
+		}
+		c.Unlock()
+	}
+}
+
+func (this *nfsConn) acquire() bool {
+	this.Lock()
+	defer this.Unlock()
+	if this.closed || this.evicted || this.stale || this.inUse {
+		return false
+	}
+	this.inUse = true
+	this.lastUsed = time.Now()
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+	return true
+}
+
+func (this *nfsConn) release() {
+	this.Lock()
+	defer this.Unlock()
+	this.inUse = false
+	this.lastUsed = time.Now()
+	if this.evicted || this.stale {
+		if this.stale && this.closed == false && this.pool != nil {
+			this.pool.staleEvictions.Add(1)
+		}
+		this.close()
+		return
+	}
+	// firewalls are known to silently drop connections that have been idle
+	// for a while, we'd rather close it ourselves and dial again when needed
+	this.timer = time.AfterFunc(this.idle, func() {
+		this.Lock()
+		defer this.Unlock()
+		if this.inUse == false && this.closed == false {
+			if this.pool != nil {
+				this.pool.idleEvictions.Add(1)
+			}
+			this.close()
+		}
+	})
+}
+
+func (this *nfsConn) markStale() {
+	this.Lock()
+	already := this.stale
+	this.stale = true
+	this.Unlock()
+	if already == false && this.pool != nil {
+		this.pool.Lock()
+		this.pool.stale += 1
+		burst := this.pool.stale == STALE_BURST
+		this.pool.Unlock()
+		if burst {
+			this.pool.rebooted("burst of stale file handles")
+		}
+	}
+}
+
+func (this *nfsConn) isClosed() bool {
+	this.Lock()
+	defer this.Unlock()
+	return this.closed
+}
+
+func (this *nfsConn) close() {
+	if this.closed {
+		return
+	}
+	this.closed = true
+	this.v.Close()
+	if this.mount != nil {
+		// connections made from a public file handle never went through MOUNT
+		this.mount.Close()
+	}
+}
+
+type NfsConnStatus struct {
+	Host     string
+	Target   string
+	Created  time.Time
+	LastUsed time.Time
+	InUse    bool
+	Stale    bool
+}
+
+// ActiveShares is a snapshot of the connections currently held open, meant
+// for admin and debug tooling
+func ActiveShares() []NfsConnStatus {
+	list := []NfsConnStatus{}
+	for _, item := range NfsCache.Cache.Items() {
+		pool, ok := item.Object.(*nfsPool)
+		if ok == false {
+			continue
+		}
+		pool.Lock()
+		for _, c := range pool.conns {
+			c.Lock()
+			if c.closed == false {
+				list = append(list, NfsConnStatus{
+					Host:     pool.host,
+					Target:   pool.target,
+					Created:  c.created,
+					LastUsed: c.lastUsed,
+					InUse:    c.inUse,
+					Stale:    c.stale,
+				})
+			}
+			c.Unlock()
+		}
+		pool.Unlock()
+	}
+	return list
+}
+
+type NfsPoolStats struct {
+	Host           string
+	Target         string
+	Hits           uint64 // requests served by an already open connection
+	Misses         uint64 // requests that had to dial a new one
+	StaleEvictions uint64
+	IdleEvictions  uint64
+}
+
+// PoolStats helps tuning the idle timeout: many misses alongside many idle
+// evictions means connections get closed right before being needed again
+func PoolStats() []NfsPoolStats {
+	list := []NfsPoolStats{}
+	for _, item := range NfsCache.Cache.Items() {
+		pool, ok := item.Object.(*nfsPool)
+		if ok == false {
+			continue
+		}
+		list = append(list, NfsPoolStats{
+			Host:           pool.host,
+			Target:         pool.target,
+			Hits:           pool.hits.Load(),
+			Misses:         pool.misses.Load(),
+			StaleEvictions: pool.staleEvictions.Load(),
+			IdleEvictions:  pool.idleEvictions.Load(),
+		})
+	}
+	return list
+}
@@ -0,0 +1,163 @@
+package plg_backend_nfs
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	params := map[string]string{"idle_timeout": "1"}
+	s := srv.share(t, params)
+	if _, err := s.Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	mounts := srv.countProg(nfs.MountProg, nfs.MountProc3MNT)
+
+	time.Sleep(1300 * time.Millisecond)
+	if n := s.pool.idleEvictions.Load(); n != 1 {
+		t.Fatalf("expected the idle connection to be closed, got %d evictions", n)
+	}
+	files, err := srv.share(t, params).Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 1 {
+		t.Fatalf("unexpected listing %v", files)
+	} else if srv.countProg(nfs.MountProg, nfs.MountProc3MNT) != mounts+1 {
+		t.Fatalf("expected the next request to dial again")
+	}
+}
+
+func TestRemountDelay(t *testing.T) {
+	previous := remountRand
+	remountRand = rand.New(rand.NewSource(42))
+	t.Cleanup(func() { remountRand = previous })
+
+	base, jitter := 100*time.Millisecond, 50*time.Millisecond
+	if d := (&nfsPool{}).remountDelay(base, jitter); d != 0 {
+		t.Fatalf("expected no delay before the first stale handle, got %s", d)
+	}
+	seen := map[time.Duration]bool{}
+	for attempt := 1; attempt <= 20; attempt++ {
+		min := MAX_REMOUNT_DELAY
+		if attempt < 10 {
+			min = base << uint(attempt-1)
+		}
+		for i := 0; i < 50; i++ {
+			d := (&nfsPool{stale: attempt}).remountDelay(base, jitter)
+			if d < min || d >= min+jitter {
+				t.Fatalf("attempt %d: expected a delay in [%s, %s), got %s", attempt, min, min+jitter, d)
+			}
+			seen[d-min] = true
+		}
+	}
+	if len(seen) < 10 {
+		t.Fatalf("expected the jitter to spread the delays, got %d distinct values", len(seen))
+	}
+	if d := (&nfsPool{stale: 3}).remountDelay(base, 0); d != 4*base {
+		t.Fatalf("expected no jitter when disabled, got %s", d)
+	}
+}
+
+func TestActiveShares(t *testing.T) {
+	srv := newFakeServer(t)
+	held := func() []NfsConnStatus {
+		list := []NfsConnStatus{}
+		for _, c := range ActiveShares() {
+			if c.Host == srv.host {
+				list = append(list, c)
+			}
+		}
+		return list
+	}
+	s := srv.share(t, map[string]string{"idle_timeout": "1"})
+	if list := held(); len(list) != 1 || list[0].InUse == false || list[0].Target != "/export" {
+		t.Fatalf("expected the new share to be listed as in use, got %+v", list)
+	} else if list[0].Created.IsZero() || list[0].LastUsed.Before(list[0].Created) {
+		t.Fatalf("unexpected times %+v", list[0])
+	}
+	s.Close()
+	if list := held(); len(list) != 1 || list[0].InUse {
+		t.Fatalf("expected the connection back in the pool, got %+v", list)
+	}
+	time.Sleep(1300 * time.Millisecond)
+	if list := held(); len(list) != 0 {
+		t.Fatalf("expected the idle connection to be gone, got %+v", list)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	srv := newFakeServer(t)
+	params := map[string]string{"idle_timeout": "1", "remount_backoff": "1", "remount_jitter": "1"}
+	stats := func() NfsPoolStats {
+		for _, s := range PoolStats() {
+			if s.Host == srv.host {
+				return s
+			}
+		}
+		t.Fatalf("expected the pool in the stats")
+		return NfsPoolStats{}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := srv.share(t, params).Ls("/"); err != nil {
+			t.Fatalf("ls: %v", err)
+		}
+	}
+	if s := stats(); s.Misses != 1 || s.Hits != 1 {
+		t.Fatalf("expected a dial then a reuse, got %+v", s)
+	}
+
+	srv.dropConns()
+	srv.share(t, params).Ls("/")
+	if s := stats(); s.StaleEvictions != 1 {
+		t.Fatalf("expected the lost connection to be evicted, got %+v", s)
+	}
+	if _, err := srv.share(t, params).Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if s := stats(); s.Misses != 2 {
+		t.Fatalf("expected a new connection to be dialed, got %+v", s)
+	}
+
+	time.Sleep(1300 * time.Millisecond)
+	if s := stats(); s.IdleEvictions != 1 {
+		t.Fatalf("expected the idle connection to be evicted, got %+v", s)
+	}
+}
+
+// cached listings can't be trusted once the write verifier says the server
+// went through a reboot
+func TestRebootInvalidatesCache(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/docs/a.txt", "a")
+	srv.dir("/other")
+	params := map[string]string{"list_cache": "60"}
+	ls := func() int {
+		files, err := srv.share(t, params).Ls("/docs/")
+		if err != nil {
+			t.Fatalf("ls: %v", err)
+		}
+		return len(files)
+	}
+	if n := ls(); n != 1 {
+		t.Fatalf("unexpected listing of %d files", n)
+	} else if err := srv.share(t, params).Save("/other/first.txt", strings.NewReader("first")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// changed behind our back, the cache doesn't know
+	srv.file("/docs/b.txt", "b")
+	if n := ls(); n != 1 {
+		t.Fatalf("expected the listing from the cache, got %d files", n)
+	}
+
+	srv.reboot()
+	if err := srv.share(t, params).Save("/other/second.txt", strings.NewReader("second")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if n := ls(); n != 2 {
+		t.Fatalf("expected the cache dropped after the reboot, got %d files", n)
+	}
+}
@@ -0,0 +1,27 @@
+package plg_backend_nfs
+
+import (
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+)
+
+// every service but the portmapper itself is found by asking the portmapper
+// on port 111 of the server for its port, go-nfs-client does it on its own
+// each time it dials. Whatever reaches the server goes through those so
+// tests can swap them for a fake server
+var (
+	getPort     = pmapGetport
+	dialService = nfs.DialService
+	dialMount   = nfs.DialMount
+	newTarget   = nfs.NewTarget
+)
+
+// the portmapper answers port 0 for what isn't registered
+func pmapGetport(host string, mapping rpc.Mapping) (int, error) {
+	pm, err := rpc.DialPortmapper("tcp", host)
+	if err != nil {
+		return 0, err
+	}
+	defer pm.Close()
+	return pm.Getport(mapping)
+}
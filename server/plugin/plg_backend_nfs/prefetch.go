@@ -0,0 +1,200 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// a connection can only have a single call in flight, reading one chunk at a
+// time means paying for the round trip on every chunk. The prefetch reader
+// borrows a few connections from the pool to keep up to window READs in
+// flight ahead of the consumer and hands the chunks back in order
+type prefetchReader struct {
+	share  NfsShare
+	fh     []byte
+	rsize  uint64
+	window uint64
+	chunks map[uint64][]byte // keyed by offset
+	next   uint64            // offset of the next chunk to fetch
+	pos    uint64            // offset of the chunk the consumer waits for
+	buf    []byte
+	cur    []byte // chunk buf is taken from, to give back once consumed
+	eofAt  uint64
+	eof    bool
+	err    error
+	closed bool
+	cond   *sync.Cond
+	wg     sync.WaitGroup
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (this NfsShare) newPrefetchReader(fh []byte, window int) (*prefetchReader, error) {
+	fsinfo, err := this.v.FSInfo()
+	if err != nil {
+		return nil, err
+	}
+	rsize := uint64(fsinfo.RTPref)
+	if rsize == 0 {
+		rsize = 32 * 1024
+	}
+	r := &prefetchReader{
+		share:  this,
+		fh:     fh,
+		rsize:  rsize,
+		window: uint64(window),
+		chunks: map[uint64][]byte{},
+		cond:   sync.NewCond(&sync.Mutex{}),
+		done:   make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.worker(this)
+	for i := 1; i < window; i++ {
+		share, err := this.acquire(this.pool, this.params)
+		if err != nil {
+			Log.Debug("plg_backend_nfs::prefetch extra connection err[%s]", err.Error())
+			break
+		}
+		share.dataOp()
+		r.wg.Add(1)
+		go func() {
+			defer share.Close()
+			r.worker(share)
+		}()
+	}
+	go func() {
+		select {
+		case <-this.ctx.Done():
+			r.Close()
+		case <-r.done:
+		}
+	}()
+	return r, nil
+}
+
+func (this *prefetchReader) worker(share NfsShare) {
+	defer this.wg.Done()
+	for {
+		this.cond.L.Lock()
+		for this.closed == false && this.err == nil && this.eof == false && this.next >= this.pos+this.window*this.rsize {
+			this.cond.Wait()
+		}
+		if this.closed || this.err != nil || (this.eof && this.next >= this.eofAt) {
+			this.cond.L.Unlock()
+			return
+		}
+		offset := this.next
+		this.next += this.rsize
+		this.cond.L.Unlock()
+
+		// the connection of the share we were created from goes back to the
+		// pool as soon as the request is gone, which busy makes wait for us
+		share.busy.Lock()
+		var (
+			data []byte
+			eof  bool
+			err  = share.ctx.Err()
+		)
+		if err == nil {
+			data, eof, err = this.fetch(share, offset)
+		}
+		share.busy.Unlock()
+
+		this.cond.L.Lock()
+		if err != nil && this.err == nil {
+			this.err = err
+		} else if err == nil {
+			this.chunks[offset] = data
+			if eof && (this.eof == false || offset+uint64(len(data)) < this.eofAt) {
+				this.eof = true
+				this.eofAt = offset + uint64(len(data))
+			}
+		}
+		this.cond.Broadcast()
+		this.cond.L.Unlock()
+	}
+}
+
+// servers are allowed to return less than asked for without being at the
+// end of the file, chunks are completed so they all stay rsize long
+func (this *prefetchReader) fetch(share NfsShare, offset uint64) ([]byte, bool, error) {
+	chunk := share.pool.buffers.get(int(this.rsize))[:0]
+	for uint64(len(chunk)) < this.rsize {
+		var (
+			data []byte
+			eof  bool
+		)
+		err := share.jukebox(func() (err error) {
+			data, eof, err = share.read(this.fh, offset+uint64(len(chunk)), uint32(this.rsize-uint64(len(chunk))))
+			return err
+		})
+		if errors.Is(err, io.EOF) {
+			// the connection closing under our feet, not the end of the file
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			share.pool.buffers.put(chunk)
+			return nil, false, err
+		}
+		chunk = append(chunk, data...)
+		if eof || len(data) == 0 {
+			return chunk, true, nil
+		}
+	}
+	return chunk, false, nil
+}
+
+func (this *prefetchReader) Read(p []byte) (int, error) {
+	this.cond.L.Lock()
+	defer this.cond.L.Unlock()
+	for len(this.buf) == 0 {
+		if this.closed {
+			return 0, os.ErrClosed
+		} else if this.eof && this.pos >= this.eofAt {
+			return 0, io.EOF
+		} else if data, ok := this.chunks[this.pos]; ok {
+			delete(this.chunks, this.pos)
+			this.share.pool.buffers.put(this.cur)
+			this.cur = data
+			this.buf = data
+			this.pos += this.rsize
+			this.cond.Broadcast()
+			if len(data) == 0 {
+				return 0, io.EOF
+			}
+			continue
+		} else if this.err != nil {
+			return 0, this.err
+		}
+		this.cond.Wait()
+	}
+	n := copy(p, this.buf)
+	this.buf = this.buf[n:]
+	return n, nil
+}
+
+func (this *prefetchReader) Close() error {
+	this.once.Do(func() {
+		this.cond.L.Lock()
+		this.closed = true
+		this.cond.Broadcast()
+		this.cond.L.Unlock()
+		close(this.done)
+		// workers may be waiting on a READ, their connection can only go
+		// back to the pool once the reply has come through
+		this.wg.Wait()
+		this.cond.L.Lock()
+		this.share.pool.buffers.put(this.cur)
+		for _, data := range this.chunks {
+			this.share.pool.buffers.put(data)
+		}
+		this.cur, this.buf, this.chunks = nil, nil, map[uint64][]byte{}
+		this.cond.L.Unlock()
+		this.share.Close()
+	})
+	return nil
+}
@@ -0,0 +1,114 @@
+package plg_backend_nfs
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// the timeouts of the rpc client are reset on every call, a transfer only
+// fails on the NFS side when a single READ or WRITE hangs. What they can't
+// catch is the other end of the transfer going quiet: a browser that stops
+// sending the upload or stops reading the download would hold on to the
+// connection forever. Those fail once they haven't made any progress for
+// the configured amount of time, however long the transfer has been going
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	buf     []byte
+	results chan stallResult
+	pending bool
+}
+
+type stallResult struct {
+	n   int
+	err error
+}
+
+func newStallReader(r io.Reader, timeout time.Duration) io.Reader {
+	if timeout <= 0 {
+		return r
+	}
+	return &stallReader{
+		r:       r,
+		timeout: timeout,
+		results: make(chan stallResult, 1),
+	}
+}
+
+func (this *stallReader) Read(p []byte) (int, error) {
+	if this.pending == false {
+		// the read happens on a buffer of our own so that a read completing
+		// after we gave up doesn't write into memory the caller has moved on
+		// with
+		if len(this.buf) < len(p) {
+			this.buf = make([]byte, len(p))
+		}
+		buf := this.buf[:len(p)]
+		this.pending = true
+		go func() {
+			n, err := this.r.Read(buf)
+			this.results <- stallResult{n, err}
+		}()
+	}
+	select {
+	case res := <-this.results:
+		this.pending = false
+		copy(p, this.buf[:res.n])
+		return res.n, res.err
+	case <-time.After(this.timeout):
+		Log.Debug("plg_backend_nfs::stall no progress in %s", this.timeout)
+		return 0, ErrTimeout
+	}
+}
+
+// a cancelled request should stop an upload before the next WRITE rather
+// than once the whole body went through
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func newCtxReader(ctx context.Context, r io.Reader) io.Reader {
+	return &ctxReader{ctx, r}
+}
+
+func (this *ctxReader) Read(p []byte) (int, error) {
+	if err := this.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return this.r.Read(p)
+}
+
+// the client going away mid upload shows up as an unexpected EOF, same as
+// the server hanging up on us. It's not the connection to the server that
+// got lost so it mustn't end up marked as stale
+type sourceError struct {
+	err error
+}
+
+func (this sourceError) Error() string {
+	return this.err.Error()
+}
+
+func (this sourceError) Unwrap() error {
+	return this.err
+}
+
+type sourceReader struct {
+	r io.Reader
+}
+
+func newSourceReader(r io.Reader) io.Reader {
+	return &sourceReader{r}
+}
+
+func (this *sourceReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = sourceError{err}
+	}
+	return n, err
+}
@@ -0,0 +1,86 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// a client sending a chunk every delay, and then nothing at all past stop
+type trickleReader struct {
+	chunks int
+	delay  time.Duration
+	stop   int
+	quit   chan struct{}
+}
+
+func (this *trickleReader) Read(p []byte) (int, error) {
+	if this.chunks == 0 {
+		return 0, io.EOF
+	} else if this.stop == 0 {
+		<-this.quit
+		return 0, io.ErrUnexpectedEOF
+	}
+	time.Sleep(this.delay)
+	this.chunks -= 1
+	this.stop -= 1
+	n := copy(p, strings.Repeat("x", 1024))
+	return n, nil
+}
+
+func TestStallTimeout(t *testing.T) {
+	srv := newFakeServer(t)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	s := srv.share(t, nil)
+	s.stallTimeout = 200 * time.Millisecond
+	start := time.Now()
+	if err := s.Save("/slow.txt", &trickleReader{chunks: 10, delay: 50 * time.Millisecond, stop: -1}); err != nil {
+		t.Fatalf("expected a slow but steady upload to go through, got %v", err)
+	} else if time.Since(start) < s.stallTimeout {
+		t.Fatalf("expected the upload to take longer than the timeout")
+	} else if got, _ := srv.content("/slow.txt"); len(got) != 10*1024 {
+		t.Fatalf("unexpected size %d", len(got))
+	}
+
+	s = srv.share(t, nil)
+	s.stallTimeout = 200 * time.Millisecond
+	start = time.Now()
+	if err := s.Save("/stalled.txt", &trickleReader{chunks: 10, delay: 10 * time.Millisecond, stop: 2, quit: quit}); errors.Is(err, ErrTimeout) == false {
+		t.Fatalf("expected the stalled upload to time out, got %v", err)
+	} else if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected to give up after the stall timeout, took %s", d)
+	}
+
+	// a download nobody reads anymore gives its connection back
+	srv.file("/big.bin", string(bytes.Repeat([]byte("x"), 256*1024)))
+	s = srv.share(t, nil)
+	s.stallTimeout = 200 * time.Millisecond
+	r, err := s.Cat("/big.bin")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		if _, err = io.ReadFull(r, buf); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if _, err = r.Read(buf); errors.Is(err, ErrTimeout) == false {
+		t.Fatalf("expected the stalled download to be stopped, got %v", err)
+	}
+	for _, c := range ActiveShares() {
+		if c.Host == srv.host && c.InUse {
+			t.Fatalf("expected the connection back in the pool")
+		}
+	}
+}
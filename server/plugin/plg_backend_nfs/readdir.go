@@ -0,0 +1,101 @@
+package plg_backend_nfs
+
+import (
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// the lib reads a directory in one go, however many entries it has. Going
+// page by page ourselves lets us stop once we have enough of them
+func (this NfsShare) readDir(path string, limit int) ([]*nfs.EntryPlus, bool, error) {
+	_, fh, err := this.resolve(path)
+	if err != nil {
+		return nil, false, err
+	}
+	var (
+		entries    = []*nfs.EntryPlus{}
+		counted    int
+		cookie     uint64
+		cookieVerf uint64
+		eof        bool
+	)
+	for eof == false {
+		var (
+			page []*nfs.EntryPlus
+			next uint64
+			verf uint64
+		)
+		err = this.jukebox(func() (err error) {
+			page, next, verf, eof, err = this.readdirplus(fh, cookie, cookieVerf)
+			return err
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		// "." and ".." come with every folder and are rarely shown, they
+		// don't count towards the limit
+		for _, entry := range page {
+			dot := entry.FileName == "." || entry.FileName == ".."
+			if limit > 0 && counted >= limit && dot == false {
+				return entries, true, nil
+			}
+			entries = append(entries, entry)
+			if dot == false {
+				counted += 1
+			}
+		}
+		cookie, cookieVerf = next, verf
+	}
+	return entries, false, nil
+}
+
+// READDIRPLUS as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.17
+// returns a single page of entries along with the cookie to continue from
+func (this NfsShare) readdirplus(fh []byte, cookie uint64, cookieVerf uint64) ([]*nfs.EntryPlus, uint64, uint64, bool, error) {
+	type ReaddirplusArgs struct {
+		FH         []byte
+		Cookie     uint64
+		CookieVerf uint64
+		DirCount   uint32
+		MaxCount   uint32
+	}
+	type DirListOK struct {
+		DirAttr    nfs.PostOpAttr
+		CookieVerf uint64
+	}
+	type Entry struct {
+		IsSet bool          `xdr:"union"`
+		Entry nfs.EntryPlus `xdr:"unioncase=1"`
+	}
+	res, err := this.call(nfs.NFSProc3ReadDirPlus, &ReaddirplusArgs{
+		FH:         fh,
+		Cookie:     cookie,
+		CookieVerf: cookieVerf,
+		DirCount:   8 * 1024,
+		MaxCount:   32 * 1024,
+	})
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	ok := DirListOK{}
+	if err = xdr.Read(res, &ok); err != nil {
+		return nil, 0, 0, false, err
+	}
+	entries := []*nfs.EntryPlus{}
+	for {
+		item := Entry{}
+		if err = xdr.Read(res, &item); err != nil {
+			return nil, 0, 0, false, err
+		} else if item.IsSet == false {
+			break
+		}
+		cookie = item.Entry.Cookie
+		entries = append(entries, &item.Entry)
+	}
+	var eof bool
+	if err = xdr.Read(res, &eof); err != nil {
+		return nil, 0, 0, false, err
+	}
+	return entries, cookie, ok.CookieVerf, eof, nil
+}
@@ -0,0 +1,44 @@
+package plg_backend_nfs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMaxEntries(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.page = 10
+	for i := 0; i < 50; i++ {
+		srv.file(fmt.Sprintf("/big/%02d.txt", i), "")
+	}
+	for i := 0; i < 25; i++ {
+		srv.file(fmt.Sprintf("/fits/%02d.txt", i), "")
+	}
+	params := map[string]string{"max_entries": "25", "list_cache": "60"}
+
+	s := srv.share(t, params)
+	srv.resetCounts()
+	files, truncated, err := s.LsLimited("/big/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 25 || truncated == false {
+		t.Fatalf("expected 25 entries and the truncation flag, got %d %t", len(files), truncated)
+	} else if n := srv.count(NFSPROC3_READDIRPLUS); n != 3 {
+		t.Fatalf("expected to stop reading past the cap, got %d READDIRPLUS", n)
+	}
+	if files, truncated, err = srv.share(t, params).LsLimited("/fits/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if len(files) != 25 || truncated {
+		t.Fatalf("expected the whole folder right at the cap, got %d %t", len(files), truncated)
+	}
+
+	// a partial listing isn't something to cache
+	for i := 0; i < 2; i++ {
+		if files, err := srv.share(t, params).Ls("/big/"); err != nil || len(files) != 25 {
+			t.Fatalf("ls: %d %v", len(files), err)
+		}
+	}
+	if n := srv.count(NFSPROC3_READDIRPLUS); n != 3+3+3+3 {
+		t.Fatalf("expected every truncated listing to be read again, got %d READDIRPLUS", n)
+	}
+}
@@ -0,0 +1,185 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// how many times a stream picks up where it was after losing its connection
+const CAT_RESUME_MAX = 3
+
+// the connection a Cat streams from belongs to that stream until it's done.
+// When the request goes away, we stop the stream and give the connection back
+// to the pool, without touching the connection itself as another request
+// might pick it up right after.
+// There's no read delegation to honour here: delegations and the CB_RECALL
+// callback channel are NFSv4 only, v3 has no server to client callbacks and
+// gives us no consistency guarantee beyond close to open
+type catReader struct {
+	fh      []byte
+	share   NfsShare
+	path    string
+	size    uint64
+	offset  uint64
+	resumes int
+	err     error
+	done    chan struct{}
+	once    sync.Once
+	stall   *time.Timer
+	mu      sync.Mutex // guards share as it's swapped on resume
+}
+
+func (this NfsShare) newCatReader(fh []byte, path string, size uint64) *catReader {
+	r := &catReader{
+		fh:    fh,
+		share: this,
+		path:  path,
+		size:  size,
+		done:  make(chan struct{}),
+	}
+	if this.stallTimeout > 0 {
+		// a client that stopped reading keeps the connection to itself
+		r.stall = time.AfterFunc(this.stallTimeout, func() {
+			Log.Debug("plg_backend_nfs::stall no progress in %s", this.stallTimeout)
+			r.stop(ErrTimeout)
+		})
+	}
+	go func() {
+		select {
+		case <-this.ctx.Done():
+			r.stop(this.ctx.Err())
+		case <-r.done:
+		}
+	}()
+	return r
+}
+
+func (this *catReader) current() NfsShare {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.share
+}
+
+func (this *catReader) Read(p []byte) (int, error) {
+	for {
+		n, err := this.read(p)
+		if err == nil || this.resumable(err) == false {
+			return n, err
+		}
+		Log.Debug("plg_backend_nfs::cat connection lost at offset %d err[%s]", this.offset, err.Error())
+		if rerr := this.resume(); rerr != nil {
+			Log.Debug("plg_backend_nfs::cat resume err[%s]", rerr.Error())
+			return n, err
+		} else if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// holding busy makes anyone giving the connection back to the pool wait for
+// the READ in flight, so its reply can't land in somebody else's hands
+func (this *catReader) read(p []byte) (int, error) {
+	share := this.current()
+	share.busy.Lock()
+	defer share.busy.Unlock()
+	if this.stall != nil {
+		defer this.stall.Reset(share.stallTimeout)
+	}
+	if this.err != nil {
+		return 0, this.err
+	} else if err := share.ctx.Err(); err != nil {
+		return 0, err
+	}
+	var (
+		data []byte
+		eof  bool
+	)
+	// by handle, a path might not lead to the same name with normalization
+	// on. The server caps the count to what it can send at once
+	err := share.jukebox(func() (err error) {
+		data, eof, err = share.read(this.fh, this.offset, uint32(len(p)))
+		return err
+	})
+	n := copy(p, data)
+	this.offset += uint64(n)
+	if errors.Is(err, io.EOF) {
+		// the connection closing under our feet, the end of the file only
+		// ever comes from the eof of the reply
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && eof {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// the server going away shows up as the connection being reset or closed
+// under our feet. An EOF before the end of the file is that too
+func (this *catReader) resumable(err error) bool {
+	if this.resumes >= CAT_RESUME_MAX {
+		return false
+	} else if err == io.EOF {
+		return this.offset < this.size
+	}
+	return isConnLost(err)
+}
+
+// the file is looked up again on a fresh connection and read from where
+// we were, the broken connection is marked stale so the pool gets rid of it
+func (this *catReader) resume() error {
+	this.resumes += 1
+	old := this.current()
+	old.conn.markStale()
+	share, err := old.acquire(old.pool, old.params)
+	if err != nil {
+		return err
+	}
+	share.dataOp()
+	_, fh, err := share.resolve(this.path)
+	if err != nil {
+		share.Close()
+		return err
+	}
+	old.busy.Lock()
+	if this.err != nil {
+		// stopped while we were at it
+		old.busy.Unlock()
+		share.Close()
+		return this.err
+	}
+	this.mu.Lock()
+	this.share = share
+	this.fh = fh
+	this.mu.Unlock()
+	old.busy.Unlock()
+	old.Close()
+	return nil
+}
+
+// there's nothing to commit on a file we've only read from, so unlike
+// nfs.File.Close we don't send anything to the server
+func (this *catReader) Close() error {
+	this.stop(os.ErrClosed)
+	return nil
+}
+
+func (this *catReader) stop(err error) {
+	share := this.current()
+	share.busy.Lock()
+	if this.err == nil {
+		this.err = err
+	}
+	share.busy.Unlock()
+	this.once.Do(func() {
+		if this.stall != nil {
+			this.stall.Stop()
+		}
+		close(this.done)
+		this.current().Close()
+	})
+}
@@ -0,0 +1,295 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// holes come back as zeros through plain READ, there's no READ_PLUS on v3
+func TestCatSparseFile(t *testing.T) {
+	srv := newFakeServer(t)
+	content := append(make([]byte, 256*1024), []byte("tail")...)
+	n := srv.file("/disk.img", string(content))
+	srv.Lock()
+	n.used = 4096
+	srv.Unlock()
+
+	r, err := srv.share(t, nil).Cat("/disk.img")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil {
+		t.Fatalf("read: %v", err)
+	} else if bytes.Equal(b, content) == false {
+		t.Fatalf("expected %d bytes ending with the tail, got %d", len(content), len(b))
+	} else if srv.count(NFSPROC3_READ) == 0 {
+		t.Fatalf("expected the content to go through READ")
+	}
+}
+
+// without delegations nothing is cached between two reads, a change made
+// on the server in between shows up straight away
+func TestCatSeesServerChanges(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.file("/notes.txt", "first")
+	read := func() string {
+		r, err := srv.share(t, nil).Cat("/notes.txt")
+		if err != nil {
+			t.Fatalf("cat: %v", err)
+		}
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		return string(b)
+	}
+	if got := read(); got != "first" {
+		t.Fatalf("unexpected content '%s'", got)
+	}
+	srv.Lock()
+	n.data = []byte("second")
+	srv.Unlock()
+	if got := read(); got != "second" {
+		t.Fatalf("expected the change on the server, got '%s'", got)
+	}
+}
+
+// cancelling a request stops its own stream, the connection goes back to the
+// pool for whoever comes next
+func TestCatCancel(t *testing.T) {
+	srv := newFakeServer(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 32*1024)
+	srv.file("/big.bin", string(content))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := srv.initCtx(ctx, nil)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	ra, err := a.(NfsShare).Cat("/big.bin")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	rb, err := srv.share(t, nil).Cat("/big.bin")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	if _, err = io.ReadFull(ra, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	} else if _, err = io.ReadFull(rb, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	cancel()
+	if _, err = io.ReadAll(ra); errors.Is(err, context.Canceled) == false {
+		t.Fatalf("expected the cancelled stream to stop, got %v", err)
+	}
+	rest, err := io.ReadAll(rb)
+	if err != nil {
+		t.Fatalf("expected the other stream to carry on, got %v", err)
+	} else if bytes.Equal(append(buf, rest...), content) == false {
+		t.Fatalf("unexpected content of %d bytes", len(buf)+len(rest))
+	}
+	ra.Close()
+	rb.Close()
+
+	for _, c := range ActiveShares() {
+		if c.Host == srv.host && (c.Stale || c.InUse) {
+			t.Fatalf("expected the connections back in the pool, got %+v", c)
+		}
+	}
+	mounts := srv.countProg(nfs.MountProg, nfs.MountProc3MNT)
+	if _, err = srv.share(t, nil).Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if srv.countProg(nfs.MountProg, nfs.MountProc3MNT) != mounts {
+		t.Fatalf("expected a pooled connection to be reused")
+	}
+}
+
+// chunks come back in order whatever order the READs complete in, and an
+// early Close gives every borrowed connection back
+func TestCatPrefetch(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.rtpref = 4096
+	content := make([]byte, 100*1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	srv.file("/video.mp4", string(content))
+	params := map[string]string{"prefetch": "4"}
+
+	r, err := srv.share(t, params).Cat("/video.mp4")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	} else if _, ok := r.(*prefetchReader); ok == false {
+		t.Fatalf("expected the prefetch reader, got %T", r)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	} else if bytes.Equal(b, content) == false {
+		t.Fatalf("expected the same %d bytes, got %d", len(content), len(b))
+	}
+
+	r, err = srv.share(t, params).Cat("/video.mp4")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	if _, err = io.ReadFull(r, make([]byte, 5000)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	r.Close()
+	if _, err = r.Read(make([]byte, 10)); err == nil {
+		t.Fatalf("expected a closed reader to refuse reads")
+	}
+
+	// a READ lost with its connection isn't the end of the file
+	var reads atomic.Int32
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READ && reads.Add(1) == 5 {
+			return FAKE_DROP
+		}
+		return 0
+	})
+	r, err = srv.share(t, params).Cat("/video.mp4")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	if b, err = io.ReadAll(r); errors.Is(err, io.ErrUnexpectedEOF) == false {
+		t.Fatalf("expected the stream to fail short of the end, got %d bytes %v", len(b), err)
+	}
+	r.Close()
+	srv.setHook(nil)
+	for _, c := range ActiveShares() {
+		if c.Host == srv.host && c.InUse {
+			t.Fatalf("expected every connection back in the pool")
+		}
+	}
+}
+
+// a millisecond of latency on every READ is enough to show the difference
+func BenchmarkCatLatency(b *testing.B) {
+	srv := newFakeServer(b)
+	srv.rtpref = 32 * 1024
+	srv.file("/video.mp4", string(make([]byte, 4*1024*1024)))
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READ {
+			time.Sleep(time.Millisecond)
+		}
+		return 0
+	})
+	for _, prefetch := range []string{"0", "4", "8"} {
+		b.Run("prefetch="+prefetch, func(b *testing.B) {
+			b.SetBytes(4 * 1024 * 1024)
+			for i := 0; i < b.N; i++ {
+				r, err := srv.share(b, map[string]string{"prefetch": prefetch}).Cat("/video.mp4")
+				if err != nil {
+					b.Fatalf("cat: %v", err)
+				}
+				io.Copy(io.Discard, r)
+				r.Close()
+			}
+		})
+	}
+}
+
+// some servers fail a READ on an empty file, there shouldn't be one
+func TestCatEmptyFile(t *testing.T) {
+	srv := newFakeServer(t)
+	empty := srv.file("/empty.txt", "")
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READ {
+			return nfs.NFS3ErrIO
+		}
+		return 0
+	})
+
+	for _, c := range []struct {
+		params map[string]string
+		noAttr bool
+	}{
+		{nil, false},
+		{nil, true},
+		{map[string]string{"prefetch": "4"}, false},
+	} {
+		srv.Lock()
+		empty.noAttr = c.noAttr
+		srv.Unlock()
+		r, err := srv.share(t, c.params).Cat("/empty.txt")
+		if err != nil {
+			t.Fatalf("%v: cat: %v", c, err)
+		}
+		if n, err := r.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+			t.Fatalf("%v: expected EOF on the first read, got %d %v", c, n, err)
+		}
+		r.Close()
+		if b, err := srv.share(t, c.params).Head("/empty.txt", 512); err != nil || len(b) != 0 {
+			t.Fatalf("%v: unexpected head %q %v", c, b, err)
+		}
+	}
+	if n := srv.count(NFSPROC3_READ); n != 0 {
+		t.Fatalf("expected no READ, got %d", n)
+	}
+}
+
+// the server dropping the connection mid-stream isn't the reader's problem,
+// up to a point
+func TestCatResume(t *testing.T) {
+	srv := newFakeServer(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 32*1024)
+	srv.file("/big.bin", string(content))
+	params := map[string]string{"remount_backoff": "1", "remount_jitter": "1"}
+	var reads atomic.Int32
+	// drops the connection on the nth READs, on every one of them for 0
+	drop := func(at ...int32) {
+		reads.Store(0)
+		srv.setHook(func(c *fakeCall) uint32 {
+			if c.Prog != nfs.Nfs3Prog || c.Proc != NFSPROC3_READ {
+				return 0
+			}
+			n := reads.Add(1)
+			for _, i := range at {
+				if i == n || i == 0 {
+					return FAKE_DROP
+				}
+			}
+			return 0
+		})
+	}
+
+	drop(3, 6)
+	r, err := srv.share(t, params).Cat("/big.bin")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("expected the stream to resume, got %v", err)
+	} else if bytes.Equal(got, content) == false {
+		t.Fatalf("unexpected content of %d bytes", len(got))
+	}
+
+	// a server dropping every READ gets given up on, which is no reason to
+	// pretend the file ended there
+	drop(0)
+	r, err = srv.share(t, params).Cat("/big.bin")
+	if err != nil {
+		t.Fatalf("cat: %v", err)
+	}
+	_, err = io.ReadAll(r)
+	r.Close()
+	if errors.Is(err, io.ErrUnexpectedEOF) == false {
+		t.Fatalf("expected the stream to fail short of the end, got %v", err)
+	} else if n := reads.Load(); n != CAT_RESUME_MAX+1 {
+		t.Fatalf("expected %d attempts, got %d", CAT_RESUME_MAX+1, n)
+	}
+}
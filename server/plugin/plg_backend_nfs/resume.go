@@ -0,0 +1,73 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// ResumeOffset is how much of an interrupted upload has already made it to
+// the server, that's where the client should pick things up from
+func (this NfsShare) ResumeOffset(path string) (_ int64, err error) {
+	defer this.Close()
+	defer this.wrapError("save", path, &err)
+	this.metadataOp()
+	attr, _, err := this.resolve(partialPath(this.nfsPath(path)))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return int64(attr.Filesize), nil
+}
+
+// SaveResumable writes the upload in a partial file next to its destination,
+// starting from offset. The partial file is only renamed into place once it
+// has reached the total size, until then a failed upload can be resumed by
+// calling it again from where ResumeOffset says we got to. It returns the
+// amount of data committed so far
+func (this NfsShare) SaveResumable(path string, file io.Reader, offset int64, total int64) (_ int64, err error) {
+	defer this.Close()
+	defer this.wrapError("save", path, &err)
+	this.dataOp()
+
+	final := this.nfsPath(path)
+	partial := partialPath(final)
+	w, err := this.openWriter(partial, 0644)
+	if err != nil {
+		return 0, err
+	}
+	attr, err := this.getattr(w.fh)
+	if err != nil {
+		return 0, err
+	} else if int64(attr.Filesize) != offset {
+		// the client and the server disagree on what has been received
+		return int64(attr.Filesize), ErrConflict
+	}
+	w.offset = uint64(offset)
+	_, err = io.Copy(w, io.LimitReader(newSourceReader(file), total-offset))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return offset, err
+	} else if int64(w.offset) < total {
+		return int64(w.offset), nil
+	}
+	if err = this.rename(partial, final); err != nil {
+		return int64(w.offset), err
+	}
+	if this.chown.UID.SetIt || this.chown.GID.SetIt {
+		if err = this.setattr(w.fh, this.chown); err != nil {
+			Log.Warning("plg_backend_nfs::chown '%s' err[%s]", final, err.Error())
+		}
+	}
+	return total, nil
+}
+
+func partialPath(path string) string {
+	dir, name := filepath.Split(path)
+	return dir + ".filestash-partial-" + name
+}
@@ -0,0 +1,66 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// the client going away halfway through
+type brokenReader struct {
+	r io.Reader
+	n int
+}
+
+func (this *brokenReader) Read(p []byte) (int, error) {
+	if this.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	} else if len(p) > this.n {
+		p = p[:this.n]
+	}
+	n, err := this.r.Read(p)
+	this.n -= n
+	return n, err
+}
+
+func TestSaveResumable(t *testing.T) {
+	srv := newFakeServer(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 20*1024)
+	total := int64(len(content))
+
+	_, err := srv.share(t, nil).SaveResumable("/upload.bin", &brokenReader{bytes.NewReader(content), 100 * 1000}, 0, total)
+	if err == nil {
+		t.Fatalf("expected the interrupted upload to fail")
+	} else if names := srv.names("/"); len(names) != 1 || names[0] != ".filestash-partial-upload.bin" {
+		t.Fatalf("expected only the partial file, got %v", names)
+	}
+	// the client went away, the connection to the server is fine
+	mounts := srv.countProg(nfs.MountProg, nfs.MountProc3MNT)
+	offset, err := srv.share(t, nil).ResumeOffset("/upload.bin")
+	if err != nil {
+		t.Fatalf("offset: %v", err)
+	} else if srv.countProg(nfs.MountProg, nfs.MountProc3MNT) != mounts {
+		t.Fatalf("expected the connection to stay in the pool")
+	} else if offset == 0 || offset > 100*1000 {
+		t.Fatalf("unexpected offset %d", offset)
+	}
+
+	if _, err = srv.share(t, nil).SaveResumable("/upload.bin", bytes.NewReader(content), 0, total); errors.Is(err, ErrConflict) == false {
+		t.Fatalf("expected a conflict starting over from 0, got %v", err)
+	}
+	n, err := srv.share(t, nil).SaveResumable("/upload.bin", bytes.NewReader(content[offset:]), offset, total)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	} else if n != total {
+		t.Fatalf("expected %d bytes committed, got %d", total, n)
+	} else if got, _ := srv.content("/upload.bin"); got != string(content) {
+		t.Fatalf("expected the file to match, got %d bytes", len(got))
+	} else if names := srv.names("/"); len(names) != 1 {
+		t.Fatalf("expected the partial file renamed into place, got %v", names)
+	}
+}
@@ -0,0 +1,65 @@
+package plg_backend_nfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// a connection dropping after a request went out leaves us not knowing if
+// the server got to it. Sending it again is fine for what's idempotent, it
+// isn't for CREATE or RENAME: the second attempt of something that did go
+// through fails with NFS3ERR_EXIST or NFS3ERR_NOENT while what we asked for
+// did happen. Servers have a duplicate request cache for that but it's keyed
+// by connection and xid which a retry on a fresh connection doesn't share,
+// those retries are made safe by checking what they find instead
+func isConnLost(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// the request running out of time passes for a net.Error too, the
+		// connection has nothing to do with it
+		return false
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, net.ErrClosed)
+}
+
+// reconnect gives a share on a fresh connection, the current one being
+// marked as stale so it leaves the pool once released. It's up to the
+// caller to Close what it gets
+func (this NfsShare) reconnect() (NfsShare, error) {
+	this.conn.markStale()
+	share, err := this.acquire(this.pool, this.params)
+	if err != nil {
+		return share, err
+	}
+	share.metadataOp()
+	return share, nil
+}
+
+// a RENAME we don't know the fate of is done when the source is gone and
+// the destination is there
+func (this NfsShare) renameRetry(from string, to string) error {
+	share, err := this.reconnect()
+	if err != nil {
+		return err
+	}
+	defer share.Close()
+	err = share.rename(from, to)
+	if os.IsNotExist(err) {
+		if _, _, ferr := share.resolve(from); os.IsNotExist(ferr) {
+			if _, _, terr := share.resolve(to); terr == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
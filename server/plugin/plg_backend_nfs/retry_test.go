@@ -0,0 +1,57 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// the server carried out the first attempt, only its reply went missing
+func TestLostReply(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/docs/a.txt", "a")
+	params := map[string]string{"remount_backoff": "1", "remount_jitter": "1"}
+	loseOnce := func(proc uint32) {
+		var lost atomic.Bool
+		srv.setHook(func(c *fakeCall) uint32 {
+			if c.Prog == nfs.Nfs3Prog && c.Proc == proc && lost.CompareAndSwap(false, true) {
+				return FAKE_LOST
+			}
+			return 0
+		})
+	}
+
+	loseOnce(NFSPROC3_CREATE)
+	srv.resetCounts()
+	if err := srv.share(t, params).CreateExclusive("/docs/lock"); err != nil {
+		t.Fatalf("expected the retry to find its own file, got %v", err)
+	} else if n := srv.count(NFSPROC3_CREATE); n != 2 {
+		t.Fatalf("expected the CREATE sent again, got %d", n)
+	} else if n := srv.node("/docs/lock"); n == nil || n.mode != 0644 {
+		t.Fatalf("expected the file created with its mode set, got %+v", n)
+	}
+	// somebody else's file is still a conflict
+	srv.setHook(nil)
+	if err := srv.share(t, params).CreateExclusive("/docs/lock"); errors.Is(err, ErrConflict) == false {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	loseOnce(NFSPROC3_RENAME)
+	srv.resetCounts()
+	if err := srv.share(t, params).Mv("/docs/a.txt", "/docs/b.txt"); err != nil {
+		t.Fatalf("expected the retry to find the rename done, got %v", err)
+	} else if n := srv.count(NFSPROC3_RENAME); n != 2 {
+		t.Fatalf("expected the RENAME sent again, got %d", n)
+	} else if got, _ := srv.content("/docs/b.txt"); got != "a" || srv.node("/docs/a.txt") != nil {
+		t.Fatalf("unexpected folder %v", srv.names("/docs"))
+	}
+	// a source that was never there isn't mistaken for a done rename
+	srv.setHook(nil)
+	if err := srv.share(t, params).Mv("/docs/missing.txt", "/docs/b.txt"); err == nil {
+		t.Fatalf("expected a missing source to fail")
+	}
+}
@@ -0,0 +1,60 @@
+package plg_backend_nfs
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+var ErrDirectoryNotEmpty = NewError("Directory is not empty", 409)
+
+const (
+	RM_SYMLINK_LINK   = "link"
+	RM_SYMLINK_TARGET = "target"
+)
+
+// LOOKUP doesn't follow symlinks, removing one takes away the link and
+// nothing else, removeAll included as it never goes through them. Removing
+// the target instead is opt-in, it only happens when the link points
+// somewhere within the share and the chroot, the link goes along with it
+func (this NfsShare) rmSymlink(path string) error {
+	if this.rmSymlinks != RM_SYMLINK_TARGET {
+		return this.v.Remove(path)
+	}
+	target, err := this.linkTarget(path)
+	if err != nil {
+		return err
+	}
+	attr, _, err := this.resolve(target)
+	if err != nil {
+		return err
+	}
+	defer this.pool.listings.invalidate(target)
+	switch attr.Type {
+	case nfs.NF3Dir:
+		if this.noRecursive {
+			err = this.rmDir(target)
+		} else {
+			err = this.removeAll(target)
+		}
+	case nfs.NF3Lnk:
+		// we don't chase chains of links
+		return NewError("Symlink points to another symlink", 400)
+	default:
+		err = this.v.Remove(target)
+	}
+	if err != nil {
+		return err
+	}
+	return this.v.Remove(path)
+}
+
+// RMDIR leaves it to the server to refuse a folder that still has content,
+// nothing goes away unless it was empty already
+func (this NfsShare) rmDir(path string) error {
+	err := this.v.RmDir(path)
+	if nfs.IsNotEmptyError(err) {
+		return ErrDirectoryNotEmpty
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRmSymlink(t *testing.T) {
+	setup := func() *fakeServer {
+		srv := newFakeServer(t)
+		srv.file("/docs/report.pdf", "report")
+		srv.symlink("/docs/link.pdf", "report.pdf")
+		srv.symlink("/docs/absolute.pdf", "/docs/report.pdf")
+		srv.symlink("/docs/chain.pdf", "link.pdf")
+		return srv
+	}
+
+	// the link and nothing else by default
+	srv := setup()
+	if err := srv.share(t, nil).Rm("/docs/link.pdf"); err != nil {
+		t.Fatalf("rm: %v", err)
+	} else if srv.node("/docs/link.pdf") != nil {
+		t.Fatalf("expected the link to be gone")
+	} else if got, _ := srv.content("/docs/report.pdf"); got != "report" {
+		t.Fatalf("expected the target to be left alone")
+	}
+
+	// the target along with the link when asked for
+	srv = setup()
+	params := map[string]string{"rm_symlinks": "target"}
+	if err := srv.share(t, params).Rm("/docs/link.pdf"); err != nil {
+		t.Fatalf("rm: %v", err)
+	} else if srv.node("/docs/link.pdf") != nil || srv.node("/docs/report.pdf") != nil {
+		t.Fatalf("expected both the link and its target gone, got %v", srv.names("/docs"))
+	}
+
+	// not through an absolute link, nor a chain of them
+	srv = setup()
+	for _, path := range []string{"/docs/absolute.pdf", "/docs/chain.pdf"} {
+		if err := srv.share(t, params).Rm(path); err == nil {
+			t.Fatalf("%s: expected the removal to be refused", path)
+		} else if srv.node(path) == nil || srv.node("/docs/report.pdf") == nil {
+			t.Fatalf("%s: expected nothing removed, got %v", path, srv.names("/docs"))
+		}
+	}
+}
+
+// removing a folder takes away the links it holds, never what they point to
+func TestRmAllSymlinkedFolder(t *testing.T) {
+	for _, option := range []string{"link", "target"} {
+		srv := newFakeServer(t)
+		srv.file("/keep/important.txt", "important")
+		srv.file("/trash/old.txt", "old")
+		srv.symlink("/trash/keep", "../keep")
+
+		if err := srv.share(t, map[string]string{"rm_symlinks": option}).Rm("/trash/"); err != nil {
+			t.Fatalf("%s: rm: %v", option, err)
+		} else if srv.node("/trash") != nil {
+			t.Fatalf("%s: expected the folder to be gone", option)
+		} else if got, _ := srv.content("/keep/important.txt"); got != "important" {
+			t.Fatalf("%s: expected the linked folder to be left alone, got %v", option, srv.names("/keep"))
+		}
+	}
+}
+
+func TestRmNoRecursive(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/projects/alpha/notes.txt", "notes")
+	srv.dir("/projects/empty")
+	s := srv.share(t, map[string]string{"no_recursive_delete": "true"})
+
+	for _, path := range []string{"/projects/alpha/", "/projects/alpha", "/projects/"} {
+		if err := s.Rm(path); errors.Is(err, ErrDirectoryNotEmpty) == false {
+			t.Fatalf("%s: expected ErrDirectoryNotEmpty, got %v", path, err)
+		} else if _, ok := srv.content("/projects/alpha/notes.txt"); ok == false {
+			t.Fatalf("%s: expected the content left alone", path)
+		}
+	}
+	if err := s.Rm("/projects/empty/"); err != nil {
+		t.Fatalf("rm empty: %v", err)
+	} else if srv.node("/projects/empty") != nil {
+		t.Fatalf("expected the empty folder removed")
+	}
+	if err := s.Rm("/projects/alpha/notes.txt"); err != nil {
+		t.Fatalf("rm file: %v", err)
+	} else if err := s.Rm("/projects/alpha/"); err != nil {
+		t.Fatalf("rm once emptied: %v", err)
+	}
+
+	// recursive by default
+	srv.file("/projects/beta/notes.txt", "notes")
+	if err := srv.share(t, nil).Rm("/projects/"); err != nil {
+		t.Fatalf("rm: %v", err)
+	} else if srv.node("/projects") != nil {
+		t.Fatalf("expected the whole tree removed")
+	}
+}
@@ -0,0 +1,308 @@
+package plg_backend_nfs
+
+import (
+	"io"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// call sends an NFSv3 procedure under our credential, args being encoded
+// right after the RPC header. Errors from the server come back as what
+// nfs.NFS3Error makes of them, the reply is positioned past the status
+func (this NfsShare) call(proc uint32, args interface{}) (io.ReadSeeker, error) {
+	return nfsCall(this.v, this.auth, proc, args)
+}
+
+// nfsCall is call for what doesn't have a share at hand, like the writer
+func nfsCall(v *nfs.Target, auth rpc.Auth, proc uint32, args interface{}) (io.ReadSeeker, error) {
+	type Call struct {
+		rpc.Header
+		Args interface{}
+	}
+	res, err := v.Call(&Call{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfs.Nfs3Prog,
+			Vers:    nfs.Nfs3Vers,
+			Proc:    proc,
+			Cred:    auth,
+			Verf:    rpc.AuthNull,
+		},
+		Args: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		return nil, err
+	} else if err = nfs.NFS3Error(status); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// callRes is call decoding the reply into reply
+func (this NfsShare) callRes(proc uint32, args interface{}, reply interface{}) error {
+	res, err := this.call(proc, args)
+	if err != nil {
+		return err
+	}
+	return xdr.Read(res, reply)
+}
+
+// GETATTR isn't exposed by the original lib, implementation as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.1
+func (this NfsShare) getattr(fh []byte) (*nfs.Fattr, error) {
+	type GetattrArgs struct {
+		FH []byte
+	}
+	const GETATTR3res = 1
+	fattr := nfs.Fattr{}
+	err := this.callRes(GETATTR3res, &GetattrArgs{
+		FH: fh,
+	}, &fattr)
+	if err != nil {
+		return nil, err
+	}
+	return &fattr, nil
+}
+
+func (this NfsShare) rootFh() []byte {
+	_, fh, _ := this.v.Lookup("/")
+	return fh
+}
+
+const (
+	ACCESS3_READ    = 0x0001
+	ACCESS3_LOOKUP  = 0x0002
+	ACCESS3_MODIFY  = 0x0004
+	ACCESS3_EXTEND  = 0x0008
+	ACCESS3_DELETE  = 0x0010
+	ACCESS3_EXECUTE = 0x0020
+)
+
+// ACCESS asks the server what the credential is allowed to do, which takes
+// into account ACLs and squashing that we can't infer from the mode bits.
+// Implementation as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.4
+func (this NfsShare) access(fh []byte, mask uint32) (uint32, error) {
+	type AccessArgs struct {
+		FH     []byte
+		Access uint32
+	}
+	type AccessRes struct {
+		Attr   nfs.PostOpAttr
+		Access uint32
+	}
+	const ACCESS3res = 4
+	accessres := AccessRes{}
+	err := this.callRes(ACCESS3res, &AccessArgs{
+		FH:     fh,
+		Access: mask,
+	}, &accessres)
+	if err != nil {
+		return 0, err
+	}
+	return accessres.Access, nil
+}
+
+// SETATTR as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.2
+func (this NfsShare) setattr(fh []byte, attr nfs.Sattr3) error {
+	type SattrGuard3 struct {
+		Check bool         `xdr:"union"`
+		Ctime nfs.NFS3Time `xdr:"unioncase=1"`
+	}
+	type SetattrArgs struct {
+		FH    []byte
+		Attr  nfs.Sattr3
+		Guard SattrGuard3
+	}
+	const SETATTR3res = 2
+	_, err := this.call(SETATTR3res, &SetattrArgs{
+		FH:   fh,
+		Attr: attr,
+	})
+	return err
+}
+
+// LOOKUP of a single component, as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.3
+func (this NfsShare) lookup(fh []byte, name string) (*nfs.Fattr, []byte, error) {
+	type LookupArgs struct {
+		What nfs.Diropargs3
+	}
+	type LookupRes struct {
+		FH      []byte
+		Attr    nfs.PostOpAttr
+		DirAttr nfs.PostOpAttr
+	}
+	lookupres := LookupRes{}
+	err := this.callRes(nfs.NFSProc3Lookup, &LookupArgs{
+		What: nfs.Diropargs3{
+			FH:       fh,
+			Filename: name,
+		},
+	}, &lookupres)
+	if err != nil {
+		return nil, nil, err
+	} else if err = checkFh(lookupres.FH); err != nil {
+		return nil, nil, err
+	}
+	if lookupres.Attr.IsSet == false {
+		// the attributes are optional in the reply, a zeroed Fattr would
+		// pass for an empty regular file
+		return nil, lookupres.FH, nil
+	}
+	return &lookupres.Attr.Attr, lookupres.FH, nil
+}
+
+// file handles are variable length opaque of up to NFS3_FHSIZE bytes, as of
+// RFC1813 in: https://www.rfc-editor.org/rfc/rfc1813#section-2.5
+// Some servers hand out short ones, others use all 64 bytes. They have to
+// stay a []byte in every args struct so XDR writes the length prefix and the
+// padding, a fixed size array would be sent without the length and get
+// rejected as garbage by the server
+const NFS3_FHSIZE = 64
+
+func checkFh(fh []byte) error {
+	if len(fh) == 0 || len(fh) > NFS3_FHSIZE {
+		Log.Debug("plg_backend_nfs::checkFh invalid handle of %d bytes", len(fh))
+		return NewError("Invalid file handle", 502)
+	}
+	return nil
+}
+
+type fsstat struct {
+	Attr     nfs.PostOpAttr
+	TBytes   uint64
+	FBytes   uint64
+	ABytes   uint64
+	TFiles   uint64
+	FFiles   uint64
+	AFiles   uint64
+	Invarsec uint32
+}
+
+// FSSTAT as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.18
+func (this NfsShare) fsstat(fh []byte) (*fsstat, error) {
+	type FsstatArgs struct {
+		FH []byte
+	}
+	const FSSTAT3res = 18
+	s := fsstat{}
+	err := this.callRes(FSSTAT3res, &FsstatArgs{
+		FH: fh,
+	}, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+const (
+	CREATE_UNCHECKED = 0
+	CREATE_GUARDED   = 1
+	CREATE_EXCLUSIVE = 2
+)
+
+// CREATE in EXCLUSIVE mode as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.8
+// the server keeps the verifier with the file, a retransmission carrying the
+// same one succeeds while anybody else gets NFS3ERR_EXIST
+func (this NfsShare) createExclusive(dirFh []byte, name string, verf uint64) ([]byte, error) {
+	type CreateHow struct {
+		Mode uint32 `xdr:"union"`
+		Verf uint64 `xdr:"unioncase=2"`
+	}
+	type CreateArgs struct {
+		Where nfs.Diropargs3
+		How   CreateHow
+	}
+	type CreateRes struct {
+		FH nfs.PostOpFH3
+	}
+	createres := CreateRes{}
+	err := this.callRes(nfs.NFSProc3Create, &CreateArgs{
+		Where: nfs.Diropargs3{
+			FH:       dirFh,
+			Filename: name,
+		},
+		How: CreateHow{
+			Mode: CREATE_EXCLUSIVE,
+			Verf: verf,
+		},
+	}, &createres)
+	if err != nil {
+		return nil, err
+	} else if createres.FH.IsSet == false {
+		_, fh, err := this.lookup(dirFh, name)
+		return fh, err
+	}
+	return createres.FH.FH, nil
+}
+
+// READ by file handle as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.6
+// the reader of the original lib keeps its handle to itself
+func (this NfsShare) read(fh []byte, offset uint64, count uint32) ([]byte, bool, error) {
+	type ReadArgs struct {
+		FH     []byte
+		Offset uint64
+		Count  uint32
+	}
+	type ReadRes struct {
+		Attr  nfs.PostOpAttr
+		Count uint32
+		EOF   bool
+		Data  []byte
+	}
+	readres := ReadRes{}
+	err := this.callRes(nfs.NFSProc3Read, &ReadArgs{
+		FH:     fh,
+		Offset: offset,
+		Count:  count,
+	}, &readres)
+	if err != nil {
+		return nil, false, err
+	}
+	return readres.Data, readres.EOF, nil
+}
+
+// MKDIR as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.9
+// the one from the lib drops the setuid, setgid and sticky bits of the mode
+func (this NfsShare) mkdir(dirFh []byte, name string, mode uint32) ([]byte, *nfs.Fattr, error) {
+	type MkdirArgs struct {
+		Where nfs.Diropargs3
+		Attrs nfs.Sattr3
+	}
+	type MkdirRes struct {
+		FH     nfs.PostOpFH3
+		Attr   nfs.PostOpAttr
+		DirWcc nfs.WccData
+	}
+	r := MkdirRes{}
+	err := this.callRes(nfs.NFSProc3Mkdir, &MkdirArgs{
+		Where: nfs.Diropargs3{
+			FH:       dirFh,
+			Filename: name,
+		},
+		Attrs: nfs.Sattr3{
+			Mode: nfs.SetMode{SetIt: true, Mode: mode},
+		},
+	}, &r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Attr.IsSet {
+		return r.FH.FH, &r.Attr.Attr, nil
+	}
+	return r.FH.FH, nil, nil
+}
@@ -0,0 +1,95 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// 10 bytes needs padding on the wire, 64 is as big as it gets
+func TestHandleLengths(t *testing.T) {
+	for _, size := range []int{10, NFS3_FHSIZE} {
+		srv := newFakeServer(t)
+		srv.Lock()
+		srv.fhSize = size
+		srv.Unlock()
+		srv.file("/docs/a.txt", "a")
+		s := srv.share(t, nil)
+
+		if err := s.Mkdir("/docs/sub/"); err != nil {
+			t.Fatalf("%d bytes handle, mkdir: %v", size, err)
+		} else if err = s.Save("/docs/sub/b.txt", strings.NewReader("b")); err != nil {
+			t.Fatalf("%d bytes handle, save: %v", size, err)
+		} else if err = s.Mv("/docs/a.txt", "/docs/sub/c.txt"); err != nil {
+			t.Fatalf("%d bytes handle, mv: %v", size, err)
+		} else if err = s.Rm("/docs/sub/b.txt"); err != nil {
+			t.Fatalf("%d bytes handle, rm: %v", size, err)
+		}
+		r, err := s.Cat("/docs/sub/c.txt")
+		if err != nil {
+			t.Fatalf("%d bytes handle, cat: %v", size, err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		if string(b) != "a" {
+			t.Fatalf("%d bytes handle, unexpected content '%s'", size, b)
+		} else if names := srv.names("/docs/sub"); len(names) != 1 || names[0] != "c.txt" {
+			t.Fatalf("%d bytes handle, unexpected listing %v", size, names)
+		}
+	}
+}
+
+// the status comes out of every reply before anything else, what follows
+// is only decoded on NFS3_OK
+func TestCall(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.file("/a.txt", "hello")
+	fh := srv.fh(n)
+	type GetattrArgs struct {
+		FH []byte
+	}
+	s := srv.share(t, nil)
+
+	attr := nfs.Fattr{}
+	if err := s.callRes(NFSPROC3_GETATTR, &GetattrArgs{fh}, &attr); err != nil {
+		t.Fatalf("getattr: %v", err)
+	} else if attr.Type != nfs.NF3Reg || attr.Filesize != 5 {
+		t.Fatalf("unexpected attributes %+v", attr)
+	}
+
+	// more than the server sent
+	tooMuch := struct {
+		Attr  nfs.Fattr
+		Extra uint64
+	}{}
+	if err := s.callRes(NFSPROC3_GETATTR, &GetattrArgs{fh}, &tooMuch); err == nil {
+		t.Fatalf("expected a short reply to fail")
+	}
+
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_GETATTR {
+			return nfs.NFS3ErrStale
+		}
+		return 0
+	})
+	attr = nfs.Fattr{}
+	if err := s.callRes(NFSPROC3_GETATTR, &GetattrArgs{fh}, &attr); isNfsError(err, nfs.NFS3ErrStale) == false {
+		t.Fatalf("expected NFS3ERR_STALE, got %v", err)
+	} else if attr.Type != 0 {
+		t.Fatalf("expected nothing decoded out of an error, got %+v", attr)
+	}
+
+	// the lib reads the reply of what the server doesn't know as an error
+	if _, err := s.call(99, &GetattrArgs{fh}); err == nil {
+		t.Fatalf("expected an unknown procedure to fail")
+	}
+
+	srv.setHook(func(c *fakeCall) uint32 {
+		return FAKE_DROP
+	})
+	if _, err := s.call(NFSPROC3_GETATTR, &GetattrArgs{fh}); isConnLost(err) == false {
+		t.Fatalf("expected the lost connection to show, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package plg_backend_nfs
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// sec_label4 as of RFC7862 in:
+// https://www.rfc-editor.org/rfc/rfc7862#section-12.2.4
+type NfsSecLabel struct {
+	LFS  uint32 // label format specifier, 0 is reserved for private use
+	PI   uint32 // policy identifier
+	Data []byte
+}
+
+// labeled NFS is an attribute of NFSv4.2 and v3 has nothing that carries
+// it, the labels of files are whatever the server defaults them to. Both
+// reading and setting them are reported as unsupported on this backend
+func (this NfsShare) GetSecLabel(path string) (_ *NfsSecLabel, err error) {
+	defer this.Close()
+	defer this.wrapError("seclabel", path, &err)
+	return nil, ErrNotSupported
+}
+
+func (this NfsShare) SetSecLabel(path string, label NfsSecLabel) (err error) {
+	defer this.Close()
+	defer this.wrapError("seclabel", path, &err)
+	return ErrNotSupported
+}
@@ -0,0 +1,22 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestSecLabelNotSupported(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	if _, err := srv.share(t, nil).GetSecLabel("/file.txt"); errors.Is(err, ErrNotSupported) == false {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+	label := NfsSecLabel{LFS: 1, PI: 0, Data: []byte("system_u:object_r:public_content_t:s0")}
+	if err := srv.share(t, nil).SetSecLabel("/file.txt", label); errors.Is(err, ErrNotSupported) == false {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	} else if got, _ := srv.content("/file.txt"); got != "content" {
+		t.Fatalf("expected the file to be left alone, got '%s'", got)
+	}
+}
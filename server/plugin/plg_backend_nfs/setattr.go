@@ -0,0 +1,72 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// AttrChanges is what SetAttr should change on a file, a nil field is left
+// as it is on the server
+type AttrChanges struct {
+	Mode  *os.FileMode
+	UID   *uint32
+	GID   *uint32
+	Size  *uint64
+	Atime *time.Time
+	Mtime *time.Time
+}
+
+// SetAttr applies all the changes in a single SETATTR so a chmod + touch
+// costs one round trip instead of one per attribute. The server applies
+// them together or not at all
+func (this NfsShare) SetAttr(path string, changes *AttrChanges) (err error) {
+	defer this.Close()
+	defer this.wrapError("setattr", path, &err)
+	this.metadataOp()
+
+	if changes == nil {
+		return nil
+	}
+	p := this.nfsPath(path)
+	defer this.pool.listings.invalidate(p)
+	_, fh, err := this.resolve(p)
+	if err != nil {
+		return err
+	}
+	return this.setattr(fh, changes.sattr())
+}
+
+func (this *AttrChanges) sattr() nfs.Sattr3 {
+	attr := nfs.Sattr3{}
+	if this.Mode != nil {
+		attr.Mode = nfs.SetMode{SetIt: true, Mode: unixMode(*this.Mode)}
+	}
+	if this.UID != nil {
+		attr.UID = nfs.SetUID{SetIt: true, UID: *this.UID}
+	}
+	if this.GID != nil {
+		attr.GID = nfs.SetUID{SetIt: true, UID: *this.GID}
+	}
+	if this.Size != nil {
+		attr.Size = nfs.SetSize{SetIt: true, Size: *this.Size}
+	}
+	if this.Atime != nil {
+		attr.Atime = setTime(*this.Atime)
+	}
+	if this.Mtime != nil {
+		attr.Mtime = setTime(*this.Mtime)
+	}
+	return attr
+}
+
+func setTime(t time.Time) nfs.SetTime {
+	return nfs.SetTime{
+		SetIt: nfs.SetToClientTime,
+		Time: nfs.NFS3Time{
+			Seconds:  uint32(t.Unix()),
+			Nseconds: uint32(t.Nanosecond()),
+		},
+	}
+}
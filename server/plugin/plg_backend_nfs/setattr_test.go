@@ -0,0 +1,56 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSetAttr(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.file("/docs/a.txt", "hello world")
+	srv.Lock()
+	n.uid, n.gid = 1000, 1000
+	srv.Unlock()
+
+	mode := os.FileMode(0600)
+	mtime := time.Date(2020, 2, 29, 12, 30, 0, 500, time.UTC)
+	srv.resetCounts()
+	if err := srv.share(t, nil).SetAttr("/docs/a.txt", &AttrChanges{Mode: &mode, Mtime: &mtime}); err != nil {
+		t.Fatalf("setattr: %v", err)
+	} else if c := srv.count(NFSPROC3_SETATTR); c != 1 {
+		t.Fatalf("expected a single SETATTR, got %d", c)
+	}
+	srv.Lock()
+	got := *n
+	srv.Unlock()
+	if got.mode != 0600 {
+		t.Fatalf("expected mode 600, got %o", got.mode)
+	} else if got.mtime.Seconds != uint32(mtime.Unix()) || got.mtime.Nseconds != 500 {
+		t.Fatalf("unexpected mtime %+v", got.mtime)
+	} else if got.uid != 1000 || got.gid != 1000 || string(got.data) != "hello world" {
+		t.Fatalf("expected what wasn't asked for left alone, got %d:%d '%s'", got.uid, got.gid, got.data)
+	}
+
+	size, gid := uint64(5), uint32(2000)
+	atime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := srv.share(t, nil).SetAttr("/docs/a.txt", &AttrChanges{Size: &size, GID: &gid, Atime: &atime}); err != nil {
+		t.Fatalf("setattr: %v", err)
+	}
+	srv.Lock()
+	got = *n
+	srv.Unlock()
+	if string(got.data) != "hello" || got.gid != 2000 || got.atime.Seconds != uint32(atime.Unix()) {
+		t.Fatalf("unexpected attributes '%s' gid %d atime %+v", got.data, got.gid, got.atime)
+	} else if got.mode != 0600 || got.uid != 1000 {
+		t.Fatalf("expected the earlier changes to stay, got %o %d", got.mode, got.uid)
+	}
+
+	// nothing to change, nothing sent
+	srv.resetCounts()
+	if err := srv.share(t, nil).SetAttr("/docs/a.txt", nil); err != nil {
+		t.Fatalf("setattr: %v", err)
+	} else if c := srv.count(NFSPROC3_SETATTR); c != 0 {
+		t.Fatalf("expected no SETATTR, got %d", c)
+	}
+}
@@ -0,0 +1,36 @@
+package plg_backend_nfs
+
+import (
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// unlike the blocks of stat(2), the used attribute of NFSv3 is already in
+// bytes as of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-2.6
+// so there's no block size to multiply it with
+func (this NfsShare) size(attr *nfs.Fattr) int64 {
+	if this.sizeOnDisk {
+		return int64(attr.Used)
+	}
+	return int64(attr.Filesize)
+}
+
+// Sizes gives both the apparent size of a file and the space it takes on the
+// server, which differ for sparse files or on compressed filesystems
+func (this NfsShare) Sizes(path string) (apparent int64, onDisk int64, err error) {
+	defer this.Close()
+	defer this.wrapError("stat", path, &err)
+	this.metadataOp()
+	if err = this.pool.coalescer.flushNow(this.nfsPath(path)); err != nil {
+		return 0, 0, err
+	}
+	attr, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return 0, 0, err
+	} else if attr == nil {
+		if attr, err = this.getattr(fh); err != nil {
+			return 0, 0, err
+		}
+	}
+	return int64(attr.Filesize), int64(attr.Used), nil
+}
@@ -0,0 +1,34 @@
+package plg_backend_nfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSizeOnDisk(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.file("/disk.img", strings.Repeat("\x00", 1024*1024))
+	srv.Lock()
+	n.used = 8192
+	srv.Unlock()
+
+	apparent, onDisk, err := srv.share(t, nil).Sizes("/disk.img")
+	if err != nil {
+		t.Fatalf("sizes: %v", err)
+	} else if apparent != 1024*1024 || onDisk != 8192 {
+		t.Fatalf("unexpected sizes %d and %d", apparent, onDisk)
+	}
+	for display, expected := range map[string]int64{"": 1024 * 1024, "on_disk": 8192} {
+		s := srv.share(t, map[string]string{"size_display": display})
+		files, err := s.Ls("/")
+		if err != nil {
+			t.Fatalf("ls: %v", err)
+		} else if len(files) != 1 || files[0].Size() != expected {
+			t.Fatalf("size_display=%s: expected %d in the listing, got %v", display, expected, files)
+		}
+		results := srv.share(t, map[string]string{"size_display": display}).StatMany([]string{"/disk.img"})
+		if r := results["/disk.img"]; r.Err != nil || r.Info.Size() != expected {
+			t.Fatalf("size_display=%s: expected %d from stat, got %+v", display, expected, r)
+		}
+	}
+}
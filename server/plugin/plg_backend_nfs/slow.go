@@ -0,0 +1,18 @@
+package plg_backend_nfs
+
+import (
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// cheaper than metrics and straight to the point when a share is said to
+// be slow: anything above the configured budget ends up in the logs
+func (this NfsShare) slowOp(op string, path string, start time.Time) {
+	if this.slowThreshold <= 0 {
+		return
+	}
+	if d := time.Since(start); d > this.slowThreshold {
+		Log.Warning("plg_backend_nfs::slow op=%s path=%s host=%s duration=%s", op, path, this.host, d.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,70 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// Log writes to stdout, so we borrow it for the duration of fn
+func captureLog(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	Log.SetVisibility("WARNING")
+	defer func() {
+		Log.Enable(false)
+		os.Stdout = stdout
+	}()
+	Log.Enable(true)
+	fn()
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestSlowOp(t *testing.T) {
+	srv := newFakeServer(t)
+	slow := srv.dir("/slow")
+	srv.file("/slow/a.txt", "a")
+	srv.file("/fast/b.txt", "b")
+	s := srv.share(t, map[string]string{"slow_op": "20"})
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_READDIRPLUS {
+			srv.Lock()
+			n, _ := srv.handleNode(c.fh())
+			srv.Unlock()
+			if n == slow {
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+		return 0
+	})
+
+	out := captureLog(t, func() {
+		if _, err := s.Ls("/fast/"); err != nil {
+			t.Errorf("ls: %v", err)
+		}
+		if _, err := s.Ls("/slow/"); err != nil {
+			t.Errorf("ls: %v", err)
+		}
+	})
+	if strings.Count(out, "plg_backend_nfs::slow") != 1 {
+		t.Fatalf("expected a single warning, got %q", out)
+	}
+	for _, field := range []string{"op=ls", "path=/slow/", "host=" + srv.host, "duration="} {
+		if strings.Contains(out, field) == false {
+			t.Fatalf("expected %s in %q", field, out)
+		}
+	}
+}
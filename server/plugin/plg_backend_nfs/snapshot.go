@@ -0,0 +1,80 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// NetApp and a few others expose snapshots through a ".snapshot" pseudo
+// directory present in every folder of the export. It doesn't show up in
+// READDIR but LOOKUP finds it, each of its subfolders being the content of
+// that same folder at the time the snapshot was taken, read only and on a
+// filesystem of its own
+const SNAPSHOT_DIR = ".snapshot"
+
+// Snapshots lists the snapshots available for the folder holding path, the
+// most recent first
+func (this NfsShare) Snapshots(path string) (_ []os.FileInfo, err error) {
+	defer this.Close()
+	defer this.wrapError("snapshots", path, &err)
+	this.metadataOp()
+	if this.noList {
+		return nil, ErrPermissionDenied
+	}
+
+	dir := snapshotDir(path)
+	files, _, err := this.ls(dir)
+	if os.IsNotExist(err) {
+		return nil, NewError("No snapshot available on this share", 404)
+	} else if err != nil {
+		return nil, err
+	}
+	snapshots := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() == false || f.Name() == "." || f.Name() == ".." {
+			continue
+		}
+		snapshots = append(snapshots, f)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].ModTime().After(snapshots[j].ModTime())
+	})
+	return snapshots, nil
+}
+
+// SnapshotPath is where the copy of path lives in a given snapshot, what it
+// gives can go straight to Ls, Cat or Stat
+func (this NfsShare) SnapshotPath(path string, snapshot string) (string, error) {
+	if snapshot == "" || strings.Contains(snapshot, "/") || snapshot == "." || snapshot == ".." {
+		return "", ErrNotValid
+	} else if isInSnapshot(path) {
+		return "", NewError("Path is already in a snapshot", 400)
+	}
+	p := snapshotDir(path) + snapshot + "/"
+	if strings.HasSuffix(path, "/") == false {
+		p += filepath.Base(path)
+	}
+	return p, nil
+}
+
+// the snapshot folder of a directory is right in it, the one of a file is
+// next to it
+func snapshotDir(path string) string {
+	if strings.HasSuffix(path, "/") == false {
+		path = filepath.Dir(path) + "/"
+	}
+	return strings.TrimSuffix(path, "/") + "/" + SNAPSHOT_DIR + "/"
+}
+
+func isInSnapshot(path string) bool {
+	for _, name := range strings.Split(path, "/") {
+		if name == SNAPSHOT_DIR {
+			return true
+		}
+	}
+	return false
+}
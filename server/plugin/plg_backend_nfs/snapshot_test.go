@@ -0,0 +1,69 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestSnapshots(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/data/report.txt", "v3")
+	srv.file("/data/.snapshot/daily.2024-01-01/report.txt", "v1")
+	srv.file("/data/.snapshot/daily.2024-01-02/report.txt", "v2")
+	srv.file("/data/.snapshot/daily.2024-01-02/old.txt", "gone since")
+	for i, name := range []string{"daily.2024-01-01", "daily.2024-01-02"} {
+		n := srv.node("/data/.snapshot/" + name)
+		srv.Lock()
+		n.mtime = fakeTime(time.Date(2024, 1, i+1, 0, 0, 0, 0, time.UTC))
+		n.ctime = n.mtime
+		srv.Unlock()
+	}
+	s := srv.share(t, nil)
+
+	snapshots, err := s.Snapshots("/data/report.txt")
+	if err != nil {
+		t.Fatalf("snapshots: %v", err)
+	} else if got := fileNames(snapshots); reflect.DeepEqual(got, []string{"daily.2024-01-02", "daily.2024-01-01"}) == false {
+		t.Fatalf("expected the most recent first, got %v", got)
+	}
+
+	p, err := s.SnapshotPath("/data/report.txt", "daily.2024-01-01")
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	r, err := s.Cat(p)
+	if err != nil {
+		t.Fatalf("cat %s: %v", p, err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "v1" {
+		t.Fatalf("expected the old version, got '%s'", b)
+	}
+	if p, err = s.SnapshotPath("/data/", "daily.2024-01-02"); err != nil {
+		t.Fatalf("path: %v", err)
+	} else if files, err := s.Ls(p); err != nil {
+		t.Fatalf("ls %s: %v", p, err)
+	} else if len(files) != 2 {
+		t.Fatalf("expected the folder as it was, got %v", fileNames(files))
+	}
+
+	for _, snapshot := range []string{"", "..", "a/b"} {
+		if _, err = s.SnapshotPath("/data/report.txt", snapshot); errors.Is(err, ErrNotValid) == false {
+			t.Fatalf("expected '%s' to be refused, got %v", snapshot, err)
+		}
+	}
+	if _, err = s.SnapshotPath("/data/.snapshot/daily.2024-01-01/report.txt", "daily.2024-01-02"); err == nil {
+		t.Fatalf("expected a snapshot of a snapshot to be refused")
+	}
+	srv.dir("/plain")
+	var e AppError
+	if _, err = s.Snapshots("/plain/"); errors.As(err, &e) == false || e.Status() != 404 {
+		t.Fatalf("expected no snapshot to be a not found, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// LsSorted lists a directory ordered by name, size or mtime. NFS has no
+// notion of server side sort: READDIRPLUS returns entries in whatever order
+// the server likes, so the sort is applied on the accumulated entries once
+// the listing is complete
+func (this NfsShare) LsSorted(path string, key string, desc bool) (_ []os.FileInfo, err error) {
+	defer this.Close()
+	defer this.wrapError("ls", path, &err)
+	defer this.slowOp("ls", path, time.Now())
+	this.metadataOp()
+	if this.noList {
+		return nil, ErrPermissionDenied
+	}
+	files, err := this.lsCached(path)
+	if err != nil {
+		return files, err
+	}
+	files = onlyKind(files, this.listOnly)
+	var less func(a, b os.FileInfo) bool
+	switch key {
+	case "name", "":
+		less = func(a, b os.FileInfo) bool {
+			return strings.ToLower(a.Name()) < strings.ToLower(b.Name())
+		}
+	case "size":
+		less = func(a, b os.FileInfo) bool {
+			return a.Size() < b.Size()
+		}
+	case "mtime":
+		less = func(a, b os.FileInfo) bool {
+			return mtime(a).Before(mtime(b))
+		}
+	default:
+		return nil, ErrNotValid
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if desc {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+	return files, nil
+}
+
+// the time shown in a listing is the ctime, sorting on it would put a file
+// that was just chmod'ed on top. The mtime comes along with the entries of
+// the listing, cached or not
+func mtime(f os.FileInfo) time.Time {
+	if info, ok := f.(NfsFileInfo); ok {
+		return info.Mtime
+	}
+	return f.ModTime()
+}
@@ -0,0 +1,83 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// names, sizes and mtimes each give a different order. The ctime goes the
+// other way around from the mtime as it would after a chmod
+func sortServer(t *testing.T) *fakeServer {
+	srv := newFakeServer(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, f := range []struct {
+		name    string
+		content string
+		mtime   time.Duration
+	}{
+		{"b.txt", "a", 2 * time.Hour},
+		{"C.txt", "ccc", 1 * time.Hour},
+		{"a.txt", "bb", 3 * time.Hour},
+	} {
+		n := srv.file("/"+f.name, f.content)
+		srv.Lock()
+		n.mtime = fakeTime(base.Add(f.mtime))
+		n.ctime = fakeTime(base.Add(time.Duration(10-i) * time.Hour))
+		srv.Unlock()
+	}
+	return srv
+}
+
+func fileNames(files []os.FileInfo) []string {
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		out = append(out, f.Name())
+	}
+	return out
+}
+
+func TestLsSorted(t *testing.T) {
+	srv := sortServer(t)
+	for _, c := range []struct {
+		key      string
+		desc     bool
+		expected []string
+	}{
+		{"", false, []string{"a.txt", "b.txt", "C.txt"}},
+		{"name", false, []string{"a.txt", "b.txt", "C.txt"}},
+		{"name", true, []string{"C.txt", "b.txt", "a.txt"}},
+		{"size", false, []string{"b.txt", "a.txt", "C.txt"}},
+		{"size", true, []string{"C.txt", "a.txt", "b.txt"}},
+		{"mtime", false, []string{"C.txt", "b.txt", "a.txt"}},
+		{"mtime", true, []string{"a.txt", "b.txt", "C.txt"}},
+	} {
+		files, err := srv.share(t, nil).LsSorted("/", c.key, c.desc)
+		if err != nil {
+			t.Fatalf("ls %s: %v", c.key, err)
+		} else if got := fileNames(files); reflect.DeepEqual(got, c.expected) == false {
+			t.Fatalf("key=%s desc=%t expected %v, got %v", c.key, c.desc, c.expected, got)
+		}
+	}
+	if _, err := srv.share(t, nil).LsSorted("/", "owner", false); err == nil {
+		t.Fatalf("expected an unknown key to be refused")
+	}
+}
+
+func TestLsSortedFromCache(t *testing.T) {
+	srv := sortServer(t)
+	params := map[string]string{"list_cache": "60"}
+	if _, err := srv.share(t, params).Ls("/"); err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	srv.resetCounts()
+	files, err := srv.share(t, params).LsSorted("/", "mtime", false)
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	} else if got := fileNames(files); reflect.DeepEqual(got, []string{"C.txt", "b.txt", "a.txt"}) == false {
+		t.Fatalf("unexpected order %v", got)
+	} else if n := srv.count(NFSPROC3_READDIRPLUS); n != 0 {
+		t.Fatalf("expected the cached listing to be enough, got %d READDIRPLUS", n)
+	}
+}
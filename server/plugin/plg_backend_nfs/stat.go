@@ -0,0 +1,104 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// each worker needs a connection of its own as they can't be shared
+const STAT_MANY_CONCURRENCY = 4
+
+type StatResult struct {
+	Info os.FileInfo
+	Err  error
+}
+
+// StatMany gets the attributes of a set of paths, spreading the lookups over
+// a few connections from the pool instead of going through them one by one
+func (this NfsShare) StatMany(paths []string) map[string]StatResult {
+	defer this.Close()
+	this.metadataOp()
+
+	results := make(map[string]StatResult, len(paths))
+	queue := make(chan string)
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	worker := func(share NfsShare) {
+		defer wg.Done()
+		for path := range queue {
+			info, err := share.stat(path)
+			lock.Lock()
+			results[path] = StatResult{info, err}
+			lock.Unlock()
+		}
+	}
+	wg.Add(1)
+	go worker(this)
+	for i := 1; i < STAT_MANY_CONCURRENCY && i < len(paths); i++ {
+		share, err := this.acquire(this.pool, this.params)
+		if err != nil {
+			Log.Debug("plg_backend_nfs::statmany extra connection err[%s]", err.Error())
+			break
+		}
+		share.metadataOp()
+		wg.Add(1)
+		go func() {
+			defer share.Close()
+			worker(share)
+		}()
+	}
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func (this NfsShare) stat(path string) (_ os.FileInfo, err error) {
+	defer this.wrapError("stat", path, &err)
+	if err = this.pool.coalescer.flushNow(this.nfsPath(path)); err != nil {
+		return nil, err
+	}
+	attr, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return nil, err
+	} else if attr == nil {
+		if attr, err = this.getattr(fh); err != nil {
+			return nil, err
+		}
+	}
+	return NfsFileInfo{
+		File: File{
+			FName: filepath.Base(path),
+			FType: this.typeToFType(attr.Type),
+			FSize: this.size(attr),
+			FTime: int64(attr.Ctime.Seconds),
+		},
+		Atime: nfsTime(attr.Atime),
+		Mtime: nfsTime(attr.Mtime),
+		Ctime: nfsTime(attr.Ctime),
+	}, nil
+}
+
+// NfsFileInfo is what stat and ls give, the time shown in the UI is the ctime
+// while backup tools need to tell apart a change of content (mtime) from
+// a change of metadata (ctime). All three come from the same attributes
+type NfsFileInfo struct {
+	File
+	Atime time.Time `json:"atime"`
+	Mtime time.Time `json:"mtime"`
+	Ctime time.Time `json:"ctime"`
+}
+
+func nfsTime(t nfs.NFS3Time) time.Time {
+	return time.Unix(int64(t.Seconds), int64(t.Nseconds))
+}
@@ -0,0 +1,86 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestStatMany(t *testing.T) {
+	srv := newFakeServer(t)
+	paths := []string{}
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("/dir/file-%02d.txt", i)
+		if i%4 == 0 {
+			path = fmt.Sprintf("/dir/missing-%02d.txt", i)
+		} else {
+			srv.file(path, fmt.Sprintf("%d", i))
+		}
+		paths = append(paths, path)
+	}
+	paths = append(paths, "/dir")
+	s := srv.share(t, nil)
+	mounts := srv.countProg(nfs.MountProg, nfs.MountProc3MNT)
+
+	results := s.StatMany(paths)
+	if len(results) != len(paths) {
+		t.Fatalf("expected a result per path, got %d", len(results))
+	}
+	for i, path := range paths[:20] {
+		r := results[path]
+		if i%4 == 0 {
+			if errors.Is(r.Err, os.ErrNotExist) == false {
+				t.Fatalf("%s: expected not found, got %v", path, r.Err)
+			}
+		} else if r.Err != nil {
+			t.Fatalf("%s: %v", path, r.Err)
+		} else if r.Info.Size() != int64(len(fmt.Sprintf("%d", i))) || r.Info.IsDir() {
+			t.Fatalf("%s: unexpected info %+v", path, r.Info)
+		}
+	}
+	if r := results["/dir"]; r.Err != nil || r.Info.IsDir() == false {
+		t.Fatalf("expected a folder, got %+v", r)
+	}
+	if n := srv.countProg(nfs.MountProg, nfs.MountProc3MNT) - mounts; n == 0 || n > STAT_MANY_CONCURRENCY-1 {
+		t.Fatalf("expected up to %d extra connections, got %d", STAT_MANY_CONCURRENCY-1, n)
+	}
+}
+
+func TestStatTimes(t *testing.T) {
+	srv := newFakeServer(t)
+	n := srv.file("/backup/db.dump", "dump")
+	srv.Lock()
+	n.atime = nfs.NFS3Time{Seconds: 1700000300, Nseconds: 3}
+	n.mtime = nfs.NFS3Time{Seconds: 1700000100, Nseconds: 1}
+	n.ctime = nfs.NFS3Time{Seconds: 1700000200, Nseconds: 2}
+	srv.Unlock()
+	check := func(step string) {
+		r := srv.share(t, nil).StatMany([]string{"/backup/db.dump"})["/backup/db.dump"]
+		if r.Err != nil {
+			t.Fatalf("%s: %v", step, r.Err)
+		}
+		info, ok := r.Info.(NfsFileInfo)
+		if ok == false {
+			t.Fatalf("%s: unexpected info %T", step, r.Info)
+		} else if info.Atime.Equal(time.Unix(1700000300, 3)) == false ||
+			info.Mtime.Equal(time.Unix(1700000100, 1)) == false ||
+			info.Ctime.Equal(time.Unix(1700000200, 2)) == false {
+			t.Fatalf("%s: unexpected times atime=%v mtime=%v ctime=%v", step, info.Atime, info.Mtime, info.Ctime)
+		}
+	}
+	check("lookup")
+
+	// without attributes on the LOOKUP reply, all three come from one GETATTR
+	srv.Lock()
+	n.noAttr = true
+	srv.Unlock()
+	srv.resetCounts()
+	check("getattr")
+	if n := srv.count(NFSPROC3_GETATTR); n != 1 {
+		t.Fatalf("expected a single GETATTR, got %d", n)
+	}
+}
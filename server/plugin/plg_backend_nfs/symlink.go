@@ -0,0 +1,36 @@
+package plg_backend_nfs
+
+import (
+	"path/filepath"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// linkTarget gives where the symlink at path points to. LOOKUP never
+// follows links so the server won't stop us from stepping out of the share
+// or out of the chroot, this has to be checked on our side before going
+// anywhere near the target. An absolute target is from the point of view
+// of the server, which might have nothing to do with where the share is
+func (this NfsShare) linkTarget(path string) (string, error) {
+	f, err := this.v.Open(path)
+	if err != nil {
+		return "", err
+	}
+	target, err := f.Readlink()
+	if err != nil {
+		return "", err
+	} else if filepath.IsAbs(target) {
+		return "", ErrPermissionDenied
+	}
+	target = filepath.Join(filepath.Dir(path), target)
+	if target == "/" || strings.HasPrefix(target, "/") == false || strings.HasPrefix(target, "/..") {
+		return "", ErrPermissionDenied
+	}
+	chroot := strings.TrimSuffix(this.nfsPath("/"+this.params["path"]), "/")
+	if chroot != "" && target != chroot && strings.HasPrefix(target, chroot+"/") == false {
+		Log.Debug("plg_backend_nfs::symlink '%s' points outside of the chroot", path)
+		return "", ErrPermissionDenied
+	}
+	return target, nil
+}
@@ -0,0 +1,45 @@
+package plg_backend_nfs
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestSymlinkOutsideChroot(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/secret.txt", "secret")
+	srv.file("/jail/docs/inside.txt", "inside")
+	srv.symlink("/jail/docs/in", "inside.txt")
+	srv.symlink("/jail/docs/escape", "../../secret.txt")
+	srv.symlink("/jail/docs/above", "../../../../secret.txt")
+	srv.symlink("/jail/docs/absolute", "/secret.txt")
+	params := map[string]string{"path": "/jail/", "rm_symlinks": "target"}
+
+	for _, path := range []string{"/jail/docs/escape", "/jail/docs/above", "/jail/docs/absolute"} {
+		if err := srv.share(t, params).Rm(path); errors.Is(err, ErrPermissionDenied) == false {
+			t.Fatalf("%s: expected the target to be out of reach, got %v", path, err)
+		} else if got, _ := srv.content("/secret.txt"); got != "secret" {
+			t.Fatalf("%s: expected the file outside the chroot left alone", path)
+		} else if srv.node(path) == nil {
+			t.Fatalf("%s: expected the link left alone", path)
+		}
+		// Cat goes through LOOKUP which never follows a link, READ on the
+		// link itself is refused by the server
+		if r, err := srv.share(t, params).Cat(path); err == nil {
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err == nil || string(b) == "secret" {
+				t.Fatalf("%s: expected the link not to be followed, got '%s' %v", path, b, err)
+			}
+		}
+	}
+
+	if err := srv.share(t, params).Rm("/jail/docs/in"); err != nil {
+		t.Fatalf("rm: %v", err)
+	} else if srv.node("/jail/docs/in") != nil || srv.node("/jail/docs/inside.txt") != nil {
+		t.Fatalf("expected a target within the chroot to go, got %v", srv.names("/jail/docs"))
+	}
+}
@@ -0,0 +1,33 @@
+package plg_backend_nfs
+
+// Sync makes sure what was saved to path has landed on stable storage,
+// including what may still be sitting in the write coalescing buffer. It
+// lets callers streaming data through several Save checkpoint without
+// having to wait for the debounce
+func (this NfsShare) Sync(path string) (err error) {
+	defer this.Close()
+	defer this.wrapError("sync", path, &err)
+	this.dataOp()
+
+	if err = this.pool.coalescer.flushNow(this.nfsPath(path)); err != nil {
+		return err
+	}
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return err
+	}
+	w := &nfsWriter{
+		v:       this.v,
+		auth:    this.auth,
+		fh:      fh,
+		jukebox: this.jukebox,
+		observe: this.pool.observeVerifier,
+	}
+	return this.jukebox(func() error {
+		verf, err := w.commitRPC()
+		if err == nil {
+			w.observe(verf)
+		}
+		return err
+	})
+}
@@ -0,0 +1,75 @@
+package plg_backend_nfs
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+var DEFAULT_FTYPES = map[uint32]string{
+	nfs.NF3Reg:  "file",
+	nfs.NF3Dir:  "directory",
+	nfs.NF3Blk:  "block",
+	nfs.NF3Chr:  "char",
+	nfs.NF3Lnk:  "symlink",
+	nfs.NF3Sock: "socket",
+	nfs.NF3FIFO: "fifo",
+}
+
+// the frontend relies on "file" and "directory", anything else is a matter
+// of taste and can be relabelled with a list like "symlink=link,fifo=pipe"
+func ftypes(overrides string) (map[uint32]string, error) {
+	labels := make(map[uint32]string, len(DEFAULT_FTYPES))
+	for t, label := range DEFAULT_FTYPES {
+		labels[t] = label
+	}
+	for _, o := range strings.Split(overrides, ",") {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		t, ok := nfsType(strings.TrimSpace(kv[0]))
+		if ok == false {
+			continue
+		} else if t == nfs.NF3Reg || t == nfs.NF3Dir {
+			return nil, NewError("Type labels: file and directory can't be relabelled", 400)
+		}
+		labels[t] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+func (this NfsShare) typeToFType(t uint32) string {
+	if label, ok := this.ftypes[t]; ok {
+		return label
+	} else if label, ok := DEFAULT_FTYPES[t]; ok {
+		return label
+	}
+	return "type_" + strconv.Itoa(int(t))
+}
+
+// types are referred to by their default label or by their code
+func nfsType(name string) (uint32, bool) {
+	for t, label := range DEFAULT_FTYPES {
+		if label == name {
+			return t, true
+		}
+	}
+	if t, err := strconv.Atoi(name); err == nil {
+		return uint32(t), true
+	}
+	return 0, false
+}
+
+func showTypes(extra string) map[uint32]bool {
+	types := map[uint32]bool{nfs.NF3Reg: true, nfs.NF3Dir: true}
+	for _, name := range strings.Split(extra, ",") {
+		if t, ok := nfsType(strings.TrimSpace(name)); ok {
+			types[t] = true
+		}
+	}
+	return types
+}
@@ -0,0 +1,226 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// every kind of entry a server can send back, plus a type it shouldn't
+func typesServer(t *testing.T) *fakeServer {
+	srv := newFakeServer(t)
+	srv.file("/file.txt", "content")
+	srv.dir("/folder")
+	srv.symlink("/link", "file.txt")
+	srv.add("/disk", nfs.NF3Blk, 0660)
+	srv.add("/pipe", nfs.NF3FIFO, 0644)
+	srv.add("/socket", nfs.NF3Sock, 0755)
+	srv.add("/weird", 9, 0644)
+	return srv
+}
+
+func listing(t *testing.T, s NfsShare, path string) []string {
+	files, err := s.Ls(path)
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	out := []string{}
+	for _, f := range files {
+		out = append(out, f.Name()+":"+fileType(f))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func fileType(f os.FileInfo) string {
+	if info, ok := f.(NfsFileInfo); ok {
+		return info.FType
+	}
+	return f.(File).FType
+}
+
+func TestRawListing(t *testing.T) {
+	srv := typesServer(t)
+	if got := listing(t, srv.share(t, nil), "/"); reflect.DeepEqual(got, []string{"file.txt:file", "folder:directory"}) == false {
+		t.Fatalf("expected only files and folders by default, got %v", got)
+	}
+	expected := []string{"disk:block", "file.txt:file", "folder:directory", "link:symlink", "pipe:fifo", "socket:socket", "weird:type_9"}
+	if got := listing(t, srv.share(t, map[string]string{"raw_listing": "true"}), "/"); reflect.DeepEqual(got, expected) == false {
+		t.Fatalf("expected every entry in raw mode, got %v", got)
+	}
+}
+
+func TestShowTypes(t *testing.T) {
+	srv := typesServer(t)
+	s := srv.share(t, map[string]string{"show_types": "fifo, socket"})
+	expected := []string{"file.txt:file", "folder:directory", "pipe:fifo", "socket:socket"}
+	if got := listing(t, s, "/"); reflect.DeepEqual(got, expected) == false {
+		t.Fatalf("expected the extra types listed, got %v", got)
+	}
+	s = srv.share(t, map[string]string{"show_types": "fifo,9,nonsense", "type_labels": "fifo=pipe"})
+	expected = []string{"file.txt:file", "folder:directory", "pipe:pipe", "weird:type_9"}
+	if got := listing(t, s, "/"); reflect.DeepEqual(got, expected) == false {
+		t.Fatalf("expected types by code and relabelled, got %v", got)
+	}
+	if _, err := srv.init(t, map[string]string{"type_labels": "directory=folder"}); err == nil {
+		t.Fatalf("expected relabelling a folder to be refused")
+	}
+}
+
+// entries coming without their attributes still get listed with the right
+// type and size, a GETATTR each fills the gaps
+func TestLsMissingAttributes(t *testing.T) {
+	srv := newFakeServer(t)
+	for i, name := range []string{"a.txt", "b.txt", "c.txt", "d"} {
+		var n *fakeNode
+		if name == "d" {
+			n = srv.dir("/" + name)
+		} else {
+			n = srv.file("/"+name, name)
+		}
+		if i%2 == 1 {
+			srv.Lock()
+			n.noAttr = true
+			srv.Unlock()
+		}
+	}
+	s := srv.share(t, nil)
+	srv.resetCounts()
+	files, err := s.Ls("/")
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	got := []string{}
+	for _, f := range files {
+		got = append(got, f.Name()+":"+fileType(f)+":"+strconv.Itoa(int(f.Size())))
+		if f.ModTime().IsZero() || f.ModTime().Unix() == 0 {
+			t.Fatalf("expected a time for %s", f.Name())
+		}
+	}
+	sort.Strings(got)
+	if expected := []string{"a.txt:file:5", "b.txt:file:5", "c.txt:file:5", "d:directory:4096"}; reflect.DeepEqual(got, expected) == false {
+		t.Fatalf("expected every entry, got %v", got)
+	} else if n := srv.count(NFSPROC3_GETATTR); n != 2 {
+		t.Fatalf("expected a GETATTR for each entry missing its attributes, got %d", n)
+	}
+}
+
+// an entry we can't stat doesn't take the rest of the folder down, it's
+// skipped or shown by name only
+func TestLsUnstatEntries(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/shared/a.txt", "a")
+	srv.dir("/shared/b")
+	private := srv.file("/shared/private.txt", "secret")
+	srv.Lock()
+	private.noAttr = true
+	srv.Unlock()
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_GETATTR && bytes.Equal(c.fh(), srv.fh(private)) {
+			return nfs.NFS3ErrAcces
+		}
+		return 0
+	})
+
+	for _, c := range []struct {
+		option   string
+		expected []string
+	}{
+		{"", []string{"a.txt:file:1", "b:directory:4096"}},
+		{"skip", []string{"a.txt:file:1", "b:directory:4096"}},
+		{"show", []string{"a.txt:file:1", "b:directory:4096", "private.txt:file:0"}},
+	} {
+		files, err := srv.share(t, map[string]string{"unstat_entries": c.option}).Ls("/shared/")
+		if err != nil {
+			t.Fatalf("%q: ls: %v", c.option, err)
+		}
+		got := []string{}
+		for _, f := range files {
+			got = append(got, f.Name()+":"+fileType(f)+":"+strconv.Itoa(int(f.Size())))
+		}
+		sort.Strings(got)
+		if reflect.DeepEqual(got, c.expected) == false {
+			t.Fatalf("%q: expected %v, got %v", c.option, c.expected, got)
+		}
+	}
+}
+
+func TestTypeToFType(t *testing.T) {
+	labels, err := ftypes("symlink=link, 7=pipe,nonsense=x,broken")
+	if err != nil {
+		t.Fatalf("ftypes: %v", err)
+	}
+	s := NfsShare{ftypes: labels}
+	for code, expected := range map[uint32]string{
+		nfs.NF3Reg:  "file",
+		nfs.NF3Dir:  "directory",
+		nfs.NF3Blk:  "block",
+		nfs.NF3Chr:  "char",
+		nfs.NF3Lnk:  "link",
+		nfs.NF3Sock: "socket",
+		nfs.NF3FIFO: "pipe",
+		0:           "type_0",
+		42:          "type_42",
+	} {
+		if got := s.typeToFType(code); got != expected {
+			t.Fatalf("type %d: expected '%s', got '%s'", code, expected, got)
+		}
+	}
+	if got := (NfsShare{}).typeToFType(nfs.NF3Lnk); got != "symlink" {
+		t.Fatalf("expected the default label without overrides, got '%s'", got)
+	}
+	for _, o := range []string{"file=document", "2=folder"} {
+		if _, err = ftypes(o); err == nil {
+			t.Fatalf("expected '%s' to be refused", o)
+		}
+	}
+}
+
+// what the type filter let through is narrowed down to one kind or the other
+func TestListOnly(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/mixed/a.txt", "a")
+	srv.file("/mixed/b.txt", "b")
+	srv.dir("/mixed/photos")
+	srv.dir("/mixed/videos")
+	srv.symlink("/mixed/link", "photos")
+	srv.add("/mixed/pipe", nfs.NF3FIFO, 0644)
+
+	for _, c := range []struct {
+		option   string
+		expected string
+	}{
+		{"", "a.txt,b.txt,photos,videos"},
+		{"both", "a.txt,b.txt,photos,videos"},
+		{"directories", "photos,videos"},
+		{"files", "a.txt,b.txt"},
+	} {
+		s := srv.share(t, map[string]string{"list_only": c.option})
+		files, err := s.Ls("/mixed/")
+		if err != nil {
+			t.Fatalf("%q: ls: %v", c.option, err)
+		}
+		names := fileNames(files)
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != c.expected {
+			t.Fatalf("%q: expected %s, got %s", c.option, c.expected, got)
+		}
+		// and on a per call basis, whatever the share says
+		if files, err = srv.share(t, map[string]string{"list_only": "files"}).LsOnly("/mixed/", c.option); err != nil {
+			t.Fatalf("%q: ls: %v", c.option, err)
+		}
+		names = fileNames(files)
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != c.expected {
+			t.Fatalf("%q: expected %s, got %s", c.option, c.expected, got)
+		}
+	}
+}
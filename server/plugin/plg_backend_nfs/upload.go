@@ -0,0 +1,108 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// same as StatMany, each worker is a connection of its own
+const SAVE_MANY_CONCURRENCY = 4
+
+// SaveMany is Save for a whole set of files, typically a folder dropped
+// onto the page. Going through Save in parallel would have every upload
+// race the others to create the same parent folders, the folders are made
+// once here before any file goes through. Uploads then spread over a few
+// connections of the pool, each file getting its own result: one that
+// fails doesn't stop the others
+func (this NfsShare) SaveMany(files map[string]io.Reader) map[string]error {
+	defer this.Close()
+	this.dataOp()
+
+	results := make(map[string]error, len(files))
+	failed := map[string]error{}
+	dirs := []string{}
+	for path := range files {
+		if dir := filepath.Dir(this.nfsPath(path)); dir != "/" && dir != "." {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	for i, dir := range dirs {
+		if i > 0 && dir == dirs[i-1] {
+			continue
+		}
+		if err := this.mkdirAll(dir); err != nil {
+			Log.Debug("plg_backend_nfs::savemany mkdir '%s' err[%s]", dir, err.Error())
+			failed[dir] = err
+		}
+	}
+
+	type upload struct {
+		path string
+		file io.Reader
+	}
+	queue := make(chan upload)
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	worker := func(share NfsShare) {
+		defer wg.Done()
+		for u := range queue {
+			err := share.saveOne(u.path, u.file)
+			lock.Lock()
+			results[u.path] = err
+			lock.Unlock()
+		}
+	}
+	wg.Add(1)
+	go worker(this)
+	for i := 1; i < SAVE_MANY_CONCURRENCY && i < len(files); i++ {
+		share, err := this.acquire(this.pool, this.params)
+		if err != nil {
+			Log.Debug("plg_backend_nfs::savemany extra connection err[%s]", err.Error())
+			break
+		}
+		share.dataOp()
+		wg.Add(1)
+		go func() {
+			defer share.Close()
+			worker(share)
+		}()
+	}
+	for path, file := range files {
+		if err := parentFailed(failed, this.nfsPath(path)); err != nil {
+			lock.Lock()
+			results[path] = &NfsError{Op: "save", Path: path, Host: this.host, Err: err}
+			lock.Unlock()
+			continue
+		}
+		queue <- upload{path, file}
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func (this NfsShare) saveOne(path string, file io.Reader) (err error) {
+	defer this.wrapError("save", path, &err)
+	if err = this.checkName(this.nfsPath(path)); err != nil {
+		return err
+	}
+	_, err = this.saveFile(path, file)
+	return err
+}
+
+func parentFailed(failed map[string]error, path string) error {
+	for dir, err := range failed {
+		if strings.HasPrefix(path, dir+"/") {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package plg_backend_nfs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+func TestSaveMany(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/blocked", "not a folder")
+	var inFlight, maxInFlight int32
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_WRITE {
+			n := atomic.AddInt32(&inFlight, 1)
+			for m := atomic.LoadInt32(&maxInFlight); n > m; m = atomic.LoadInt32(&maxInFlight) {
+				if atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		}
+		return 0
+	})
+
+	files := map[string]io.Reader{"/blocked/x.txt": strings.NewReader("x")}
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("/new/a/%d.txt", i)
+		if i%2 == 1 {
+			path = fmt.Sprintf("/new/a/b/%d.txt", i)
+		}
+		files[path] = strings.NewReader(path)
+	}
+	results := srv.share(t, nil).SaveMany(files)
+
+	if len(results) != len(files) {
+		t.Fatalf("expected a result per file, got %d of %d", len(results), len(files))
+	}
+	for path, err := range results {
+		if path == "/blocked/x.txt" {
+			if err == nil {
+				t.Fatalf("expected an error under a file")
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if content, _ := srv.content(path); content != path {
+			t.Fatalf("%s: unexpected content %q", path, content)
+		}
+	}
+	if n := srv.count(NFSPROC3_MKDIR); n != 3 {
+		t.Fatalf("expected /new, /new/a and /new/a/b to be made once, got %d MKDIR", n)
+	}
+	if m := atomic.LoadInt32(&maxInFlight); m > SAVE_MANY_CONCURRENCY {
+		t.Fatalf("expected at most %d uploads at once, got %d", SAVE_MANY_CONCURRENCY, m)
+	}
+}
@@ -0,0 +1,43 @@
+package plg_backend_nfs
+
+type NfsDiskUsage struct {
+	Total     uint64
+	Free      uint64
+	Available uint64 // what's left for us, which excludes reserved blocks
+
+	// inode counts, only meaningful when Files is true. Some filesystems
+	// allocate them dynamically and report zeros
+	Files          bool
+	TotalFiles     uint64
+	FreeFiles      uint64
+	AvailableFiles uint64
+}
+
+// DiskUsage reports the space and the inodes left on the filesystem holding
+// path. On some filesystems inodes run out well before the space does
+func (this NfsShare) DiskUsage(path string) (_ NfsDiskUsage, err error) {
+	defer this.Close()
+	defer this.wrapError("df", path, &err)
+	this.metadataOp()
+
+	_, fh, err := this.resolve(this.nfsPath(path))
+	if err != nil {
+		return NfsDiskUsage{}, err
+	}
+	s, err := this.fsstat(fh)
+	if err != nil {
+		return NfsDiskUsage{}, err
+	}
+	usage := NfsDiskUsage{
+		Total:     s.TBytes,
+		Free:      s.FBytes,
+		Available: s.ABytes,
+	}
+	if s.TFiles > 0 && s.FFiles <= s.TFiles {
+		usage.Files = true
+		usage.TotalFiles = s.TFiles
+		usage.FreeFiles = s.FFiles
+		usage.AvailableFiles = s.AFiles
+	}
+	return usage, nil
+}
@@ -0,0 +1,39 @@
+package plg_backend_nfs
+
+import (
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.dir("/data")
+	for _, c := range []struct {
+		stat     fsstat
+		expected NfsDiskUsage
+	}{
+		{
+			fsstat{TBytes: 1 << 30, FBytes: 1 << 29, ABytes: 1 << 28, TFiles: 1000, FFiles: 900, AFiles: 800},
+			NfsDiskUsage{Total: 1 << 30, Free: 1 << 29, Available: 1 << 28, Files: true, TotalFiles: 1000, FreeFiles: 900, AvailableFiles: 800},
+		},
+		{
+			// inodes allocated on the fly
+			fsstat{TBytes: 1 << 30, FBytes: 1 << 29, ABytes: 1 << 29},
+			NfsDiskUsage{Total: 1 << 30, Free: 1 << 29, Available: 1 << 29},
+		},
+		{
+			// more free than there are, nothing to make of it
+			fsstat{TBytes: 1 << 30, FBytes: 1 << 29, ABytes: 1 << 29, TFiles: 10, FFiles: 1 << 40, AFiles: 1 << 40},
+			NfsDiskUsage{Total: 1 << 30, Free: 1 << 29, Available: 1 << 29},
+		},
+	} {
+		srv.Lock()
+		srv.fsstat = c.stat
+		srv.Unlock()
+		usage, err := srv.share(t, nil).DiskUsage("/data")
+		if err != nil {
+			t.Fatalf("df: %v", err)
+		} else if usage != c.expected {
+			t.Fatalf("expected %+v, got %+v", c.expected, usage)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// reads back what the server has stored after COMMIT, any difference with
+// what was sent means the upload can't be trusted
+func (this NfsShare) verify(path string, expected []byte) error {
+	f, err := this.v.Open(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return err
+	}
+	if bytes.Equal(h.Sum(nil), expected) == false {
+		Log.Warning("plg_backend_nfs::verify checksum mismatch on '%s'", path)
+		return NewError("Integrity check failed: the file stored on the server differs from the upload", 500)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package plg_backend_nfs
+
+import (
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+type WalkFunc func(path string, entry *nfs.EntryPlus) error
+
+// Walk goes through the tree rooted at path depth first, calling fn for every
+// entry. Symlinks are reported but never followed. It only sees what Ls
+// would show, an archive or a chmod -R mustn't reach what is hidden
+func (this NfsShare) Walk(path string, fn WalkFunc) error {
+	return this.walk(path, this.hidden, fn)
+}
+
+func (this NfsShare) walk(path string, skip func(entry *nfs.EntryPlus) bool, fn WalkFunc) error {
+	dir := strings.TrimSuffix(path, "/")
+	var entries []*nfs.EntryPlus
+	err := this.jukebox(func() (err error) {
+		entries, err = this.v.ReadDirPlus(dir)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.FileName == "." || entry.FileName == ".." {
+			continue
+		} else if entry.Attr.IsSet == false {
+			// same as in ls, without attributes we can't tell a folder
+			// from a file
+			attr, err := this.entryAttr(dir, entry)
+			if err != nil {
+				Log.Debug("plg_backend_nfs::walk missing attributes for '%s' err[%s]", entry.FileName, err.Error())
+				continue
+			}
+			entry.Attr.Attr = *attr
+			entry.Attr.IsSet = true
+		}
+		if skip(entry) {
+			continue
+		}
+		p := dir + "/" + entry.FileName
+		if err = fn(p, entry); err != nil {
+			return err
+		}
+		if entry.Attr.Attr.Type == nfs.NF3Dir {
+			if err = this.walk(p, skip, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeAll is rm -r. Whatever type it is goes away with its folder but
+// the excluded names are left alone, the folder holding one of them can't
+// be removed and the whole thing ends with ErrDirectoryNotEmpty
+func (this NfsShare) removeAll(path string) error {
+	dirs := []string{strings.TrimSuffix(path, "/")}
+	err := this.walk(path, func(entry *nfs.EntryPlus) bool {
+		return this.excluded(this.decodeName(entry.FileName))
+	}, func(p string, entry *nfs.EntryPlus) error {
+		if entry.Attr.Attr.Type == nfs.NF3Dir {
+			dirs = append(dirs, p)
+			return nil
+		}
+		return this.v.Remove(p)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = this.rmDir(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
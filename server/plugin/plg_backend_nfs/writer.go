@@ -0,0 +1,230 @@
+package plg_backend_nfs
+
+import (
+	"io"
+	"os"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+const (
+	WRITE_UNSTABLE  = 0
+	WRITE_FILE_SYNC = 2
+
+	// amount of unstable data we keep around before forcing a COMMIT
+	COMMIT_THRESHOLD = 8 * 1024 * 1024
+)
+
+// the writer from the original lib does FILE_SYNC writes and discard the
+// write verifier. We do UNSTABLE writes instead and keep the data until
+// COMMIT confirms it has landed on stable storage. As of RFC1813 in:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.21
+// a change of verifier between WRITE and COMMIT means the server has
+// rebooted and lost what was in its cache, in which case we need to send
+// everything again
+type nfsWriter struct {
+	v       *nfs.Target
+	auth    rpc.Auth
+	fh      []byte
+	wsize   uint32
+	offset  uint64
+	pending []pendingWrite
+	size    int
+	verf    uint64
+	hasVerf bool
+	rewrite bool
+	jukebox func(func() error) error
+	observe func(verf uint64)
+	buffers *bufferPool
+	created bool // the file didn't exist before
+}
+
+type pendingWrite struct {
+	offset uint64
+	data   []byte
+}
+
+func (this NfsShare) openWriter(path string, perm os.FileMode) (*nfsWriter, error) {
+	this.pool.listings.invalidate(path)
+	_, fh, err := this.resolve(path)
+	created := false
+	if os.IsNotExist(err) {
+		fh, err = this.v.Create(path, perm)
+		created = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	fsinfo, err := this.v.FSInfo()
+	if err != nil {
+		return nil, err
+	}
+	wsize := fsinfo.WTPref
+	if wsize == 0 {
+		wsize = 32 * 1024
+	}
+	return &nfsWriter{
+		v:       this.v,
+		auth:    this.auth,
+		fh:      fh,
+		wsize:   wsize,
+		jukebox: this.jukebox,
+		observe: this.pool.observeVerifier,
+		buffers: &this.pool.buffers,
+		created: created,
+	}, nil
+}
+
+// an existing file is written over from the start, without truncating it
+// first a shorter content would keep the tail of what was there
+func (this NfsShare) truncate(w *nfsWriter) error {
+	if w.created {
+		return nil
+	}
+	size := uint64(0)
+	return this.setattr(w.fh, (&AttrChanges{Size: &size}).sattr())
+}
+
+func (this *nfsWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if uint32(len(chunk)) > this.wsize {
+			chunk = chunk[:this.wsize]
+		}
+		n, verf, err := this.write(this.offset, chunk, WRITE_UNSTABLE)
+		if err != nil {
+			return written, err
+		}
+		if this.hasVerf && verf != this.verf {
+			this.rewrite = true
+		}
+		this.observe(verf)
+		this.verf = verf
+		this.hasVerf = true
+		data := this.buffers.get(int(n))
+		copy(data, chunk[:n])
+		this.pending = append(this.pending, pendingWrite{
+			offset: this.offset,
+			data:   data,
+		})
+		this.size += int(n)
+		this.offset += uint64(n)
+		written += int(n)
+	}
+	if this.size >= COMMIT_THRESHOLD {
+		if err := this.commit(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (this *nfsWriter) Close() error {
+	err := this.commit()
+	// whatever didn't make it is lost with the writer
+	this.release()
+	return err
+}
+
+func (this *nfsWriter) release() {
+	for _, p := range this.pending {
+		this.buffers.put(p.data)
+	}
+	this.pending = nil
+}
+
+func (this *nfsWriter) commit() error {
+	verf, err := this.commitRPC()
+	if err != nil {
+		return err
+	}
+	this.observe(verf)
+	if this.rewrite || (this.hasVerf && verf != this.verf) {
+		// the server lost our unstable data, FILE_SYNC makes sure we won't
+		// have to go through this again
+		for _, p := range this.pending {
+			for done := 0; done < len(p.data); {
+				n, _, err := this.write(p.offset+uint64(done), p.data[done:], WRITE_FILE_SYNC)
+				if err != nil {
+					return err
+				}
+				done += int(n)
+			}
+		}
+	}
+	this.release()
+	this.size = 0
+	this.rewrite = false
+	this.hasVerf = false
+	return nil
+}
+
+func (this *nfsWriter) write(offset uint64, data []byte, how uint32) (n uint32, verf uint64, err error) {
+	err = this.jukebox(func() error {
+		n, verf, err = this.writeRPC(offset, data, how)
+		return err
+	})
+	return n, verf, err
+}
+
+func (this *nfsWriter) writeRPC(offset uint64, data []byte, how uint32) (uint32, uint64, error) {
+	type WriteArgs struct {
+		FH       []byte
+		Offset   uint64
+		Count    uint32
+		How      uint32
+		Contents []byte
+	}
+	type WriteRes struct {
+		Wcc       nfs.WccData
+		Count     uint32
+		How       uint32
+		WriteVerf uint64
+	}
+	if uint32(len(data)) > this.wsize {
+		data = data[:this.wsize]
+	}
+	res, err := nfsCall(this.v, this.auth, nfs.NFSProc3Write, &WriteArgs{
+		FH:       this.fh,
+		Offset:   offset,
+		Count:    uint32(len(data)),
+		How:      how,
+		Contents: data,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	writeres := WriteRes{}
+	if err = xdr.Read(res, &writeres); err != nil {
+		return 0, 0, err
+	} else if writeres.Count == 0 {
+		return 0, 0, io.ErrShortWrite
+	}
+	return writeres.Count, writeres.WriteVerf, nil
+}
+
+func (this *nfsWriter) commitRPC() (uint64, error) {
+	type CommitArgs struct {
+		FH     []byte
+		Offset uint64
+		Count  uint32
+	}
+	type CommitRes struct {
+		Wcc       nfs.WccData
+		WriteVerf uint64
+	}
+	res, err := nfsCall(this.v, this.auth, nfs.NFSProc3Commit, &CommitArgs{
+		FH: this.fh,
+	})
+	if err != nil {
+		return 0, err
+	}
+	commitres := CommitRes{}
+	if err = xdr.Read(res, &commitres); err != nil {
+		return 0, err
+	}
+	return commitres.WriteVerf, nil
+}
@@ -0,0 +1,161 @@
+package plg_backend_nfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+func TestWriterRewritesOnVerifierChange(t *testing.T) {
+	srv := newFakeServer(t)
+	s := srv.share(t, nil)
+
+	var (
+		lock     sync.Mutex
+		how      = map[uint32]int{}
+		rebooted bool
+	)
+	srv.setHook(func(c *fakeCall) uint32 {
+		lock.Lock()
+		defer lock.Unlock()
+		switch c.Proc {
+		case NFSPROC3_WRITE:
+			args := struct {
+				FH     []byte
+				Offset uint64
+				Count  uint32
+				How    uint32
+			}{}
+			xdr.Read(bytes.NewReader(c.Args), &args)
+			how[args.How] += 1
+		case NFSPROC3_COMMIT:
+			if rebooted == false {
+				// in between the WRITEs and the COMMIT, what was cached is lost
+				rebooted = true
+				srv.reboot()
+			}
+		}
+		return 0
+	})
+
+	content := strings.Repeat("filestash", 1000)
+	if err := s.Save("/file.txt", strings.NewReader(content)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if got, _ := srv.content("/file.txt"); got != content {
+		t.Fatalf("expected the content to be written again, got %d bytes", len(got))
+	} else if how[WRITE_UNSTABLE] == 0 {
+		t.Fatalf("expected UNSTABLE writes first, got %v", how)
+	} else if how[WRITE_FILE_SYNC] == 0 {
+		t.Fatalf("expected FILE_SYNC writes after the verifier changed, got %v", how)
+	}
+}
+
+func TestWriterSameVerifierCommitsOnce(t *testing.T) {
+	srv := newFakeServer(t)
+	s := srv.share(t, nil)
+
+	content := strings.Repeat("filestash", 1000)
+	if err := s.Save("/file.txt", strings.NewReader(content)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if got, _ := srv.content("/file.txt"); got != content {
+		t.Fatalf("unexpected content of %d bytes", len(got))
+	}
+	// a reboot now doesn't lose anything, it's all committed
+	srv.reboot()
+	if got, _ := srv.content("/file.txt"); got != content {
+		t.Fatalf("expected the content to be on stable storage, got %d bytes", len(got))
+	} else if n := srv.count(NFSPROC3_COMMIT); n != 1 {
+		t.Fatalf("expected a single COMMIT, got %d", n)
+	}
+}
+
+func TestVerifyWrites(t *testing.T) {
+	srv := newFakeServer(t)
+	params := map[string]string{"verify_writes": "true"}
+	if err := srv.share(t, params).Save("/sound.txt", strings.NewReader("stored as sent")); err != nil {
+		t.Fatalf("save: %v", err)
+	} else if srv.count(NFSPROC3_READ) == 0 {
+		t.Fatalf("expected the file to be read back")
+	}
+
+	// a bit flipped somewhere between the COMMIT and the disk
+	srv.setHook(func(c *fakeCall) uint32 {
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_COMMIT {
+			srv.Lock()
+			if n, status := srv.handleNode(c.fh()); status == 0 && len(n.data) > 0 {
+				n.data[0] ^= 0x01
+			}
+			srv.Unlock()
+		}
+		return 0
+	})
+	err := srv.share(t, params).Save("/corrupted.txt", strings.NewReader("stored as sent"))
+	var e AppError
+	if errors.As(err, &e) == false || e.Status() != 500 || strings.Contains(err.Error(), "Integrity") == false {
+		t.Fatalf("expected an integrity error, got %v", err)
+	} else if names := srv.names("/"); len(names) != 1 || names[0] != "sound.txt" {
+		t.Fatalf("expected the corrupted upload to be removed, got %v", names)
+	}
+	if err = srv.share(t, nil).Save("/unchecked.txt", strings.NewReader("stored as sent")); err != nil {
+		t.Fatalf("expected the check to be opt-in, got %v", err)
+	}
+}
+
+// a Save running out of time stops at the next chunk, says so and takes the
+// partial file away. One that was there already is left alone
+func TestSaveDeadline(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.file("/docs/existing.txt", "original")
+	chunk := bytes.Repeat([]byte("x"), 64*1024)
+	slow := func(ctx context.Context) io.Reader {
+		first := true
+		return readerFunc(func(p []byte) (int, error) {
+			if first == false {
+				<-ctx.Done()
+			}
+			first = false
+			return copy(p, chunk), nil
+		})
+	}
+
+	for _, path := range []string{"/docs/new.txt", "/docs/existing.txt"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		b, err := srv.initCtx(ctx, nil)
+		if err != nil {
+			cancel()
+			t.Fatalf("init: %v", err)
+		}
+		srv.resetCounts()
+		start := time.Now()
+		err = b.(NfsShare).Save(path, slow(ctx))
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) == false {
+			t.Fatalf("%s: expected the deadline, got %v", path, err)
+		} else if time.Since(start) > time.Second {
+			t.Fatalf("%s: expected a prompt stop, got %s", path, time.Since(start))
+		} else if n := srv.count(NFSPROC3_COMMIT); n != 0 {
+			t.Fatalf("%s: expected nothing committed, got %d COMMIT", path, n)
+		}
+	}
+	if names := srv.names("/docs"); len(names) != 1 || names[0] != "existing.txt" {
+		t.Fatalf("expected the partial file gone, got %v", names)
+	}
+	// our deadline says nothing about the connection
+	for _, c := range ActiveShares() {
+		if c.Host == srv.host && c.Stale {
+			t.Fatalf("expected the connection to stay in the pool, got %+v", c)
+		}
+	}
+}
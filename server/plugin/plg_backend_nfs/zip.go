@@ -0,0 +1,64 @@
+package plg_backend_nfs
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+)
+
+// Zip streams the content of a directory as a zip archive. Files are read
+// with READ as we go so the archive never sits in memory
+func (this NfsShare) Zip(path string, w io.Writer) (err error) {
+	defer this.Close()
+	defer this.wrapError("zip", path, &err)
+	this.dataOp()
+	if this.noList {
+		// an archive of a folder gives away its listing
+		return ErrPermissionDenied
+	}
+
+	root := this.nfsPath(path)
+	if err = this.pool.coalescer.flushNow(root); err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	err = this.Walk(root, func(p string, entry *nfs.EntryPlus) error {
+		name := this.decodeName(strings.TrimPrefix(p, root+"/"))
+		attr := entry.Attr.Attr
+		if attr.Type == nfs.NF3Dir {
+			_, err := zw.Create(name + "/")
+			return err
+		} else if attr.Type != nfs.NF3Reg {
+			return nil
+		}
+		f, err := this.v.Open(p)
+		if err != nil && this.zipSkipErrors {
+			Log.Warning("plg_backend_nfs::zip skip '%s' err[%s]", p, err.Error())
+			return nil
+		} else if err != nil {
+			return err
+		}
+		zf, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: time.Unix(int64(attr.Mtime.Seconds), 0),
+		})
+		if err != nil {
+			return err
+		}
+		// past the header, the entry is part of the archive already. A
+		// failure can't be skipped without shipping a truncated file
+		_, err = io.Copy(zf, f)
+		return err
+	})
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
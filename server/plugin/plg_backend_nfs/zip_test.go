@@ -0,0 +1,84 @@
+package plg_backend_nfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+func zipServer(t *testing.T) *fakeServer {
+	srv := newFakeServer(t)
+	srv.file("/docs/readme.txt", "hello")
+	srv.file("/docs/sub/notes.txt", "some notes")
+	srv.file("/docs/sub/secret.txt", "top secret")
+	srv.dir("/docs/sub/empty")
+	return srv
+}
+
+func zipContent(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	out := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		out[f.Name] = string(b)
+	}
+	return out
+}
+
+func TestZip(t *testing.T) {
+	srv := zipServer(t)
+	var buf bytes.Buffer
+	if err := srv.share(t, nil).Zip("/docs", &buf); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	expected := map[string]string{
+		"readme.txt":     "hello",
+		"sub/":           "",
+		"sub/notes.txt":  "some notes",
+		"sub/secret.txt": "top secret",
+		"sub/empty/":     "",
+	}
+	if got := zipContent(t, buf.Bytes()); reflect.DeepEqual(got, expected) == false {
+		t.Fatalf("unexpected archive %v", got)
+	}
+}
+
+func TestZipReadErrors(t *testing.T) {
+	srv := zipServer(t)
+	srv.setHook(func(c *fakeCall) uint32 {
+		args := nfs.Diropargs3{}
+		if c.Prog == nfs.Nfs3Prog && c.Proc == NFSPROC3_LOOKUP && xdr.Read(bytes.NewReader(c.Args), &args) == nil && args.Filename == "secret.txt" {
+			return nfs.NFS3ErrAcces
+		}
+		return 0
+	})
+
+	var buf bytes.Buffer
+	if err := srv.share(t, nil).Zip("/docs", &buf); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	got := zipContent(t, buf.Bytes())
+	if _, ok := got["sub/secret.txt"]; ok {
+		t.Fatalf("expected the unreadable file to be skipped, got %v", got)
+	} else if got["sub/notes.txt"] != "some notes" || len(got) != 4 {
+		t.Fatalf("expected the rest of the archive, got %v", got)
+	}
+
+	buf.Reset()
+	if err := srv.share(t, map[string]string{"zip_errors": "abort"}).Zip("/docs", &buf); err == nil {
+		t.Fatalf("expected the archive to be aborted")
+	}
+}